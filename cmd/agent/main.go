@@ -33,8 +33,13 @@ func printStartupInfo() {
 func main() {
 	printStartupInfo()
 	agentConfig := config.GetAgentConfig(os.Args[1:])
-	log.Printf("INFO main agent config: PollInterval: %v, ReportInterval: %v, ServerAddress: %s, PublicKeyPath: %s",
-		agentConfig.PollInterval, agentConfig.ReportInterval, agentConfig.ServerAddress, agentConfig.PublicKeyPath)
+	log.Printf("INFO main agent config: PollInterval: %v, ReportInterval: %v, ServerAddress: %s, PublicKeyPath: %s, "+
+		"BatchSize: %d, RetryAttempts: %d, RetryBackoff: %s, RetryMaxBackoff: %s, "+
+		"SpoolDir: %s, SpoolMaxSize: %d, SpoolMaxAge: %s, TLSCA: %s, TLSCert: %s, TLSKey: %s",
+		agentConfig.PollInterval, agentConfig.ReportInterval, agentConfig.ServerAddress, agentConfig.PublicKeyPath,
+		agentConfig.BatchSize, agentConfig.RetryAttempts, agentConfig.RetryBackoff, agentConfig.RetryMaxBackoff,
+		agentConfig.SpoolDir, agentConfig.SpoolMaxSize, agentConfig.SpoolMaxAge,
+		agentConfig.TLSCA, agentConfig.TLSCert, agentConfig.TLSKey)
 
 	var pubKey *rsa.PublicKey
 	var err error
@@ -45,10 +50,17 @@ func main() {
 		}
 	}
 
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("WARN failed to get hostname: %s", err.Error())
+	}
+	labels := poller.Identity(hostname, agentConfig.Instance, agentConfig.Labels)
+	log.Printf("INFO main agent identity: %s", labels)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	// starting poller
 	wg.Add(1)
-	go poller.Poll(ctx, &wg, agentConfig.PollInterval)
+	go poller.Poll(ctx, &wg, agentConfig.PollInterval, labels)
 	// starting reporter
 	wg.Add(1)
 	go reporter.Report(ctx, &wg, agentConfig, pubKey)
@@ -1,14 +1,31 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"strings"
 
+	"github.com/zklevsha/go-musthave-devops/internal/certs"
 	"github.com/zklevsha/go-musthave-devops/internal/rsaencrypt"
 )
 
 func main() {
-	err := rsaencrypt.Generate(".")
+	var outDir, hosts string
+	var withCerts bool
+	flag.StringVar(&outDir, "o", ".", "directory to write keys and certificates to")
+	flag.BoolVar(&withCerts, "tls", false, "also generate CA, server and agent TLS certificates")
+	flag.StringVar(&hosts, "hosts", "localhost,127.0.0.1",
+		"comma separated DNS names and IPs the server certificate is valid for")
+	flag.Parse()
+
+	err := rsaencrypt.Generate(outDir)
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
+	if withCerts {
+		err = certs.Generate(outDir, strings.Split(hosts, ","))
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+	}
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"crypto/rsa"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,14 +12,22 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/zklevsha/go-musthave-devops/internal/agents"
+	"github.com/zklevsha/go-musthave-devops/internal/boltdb"
+	"github.com/zklevsha/go-musthave-devops/internal/certs"
+	"github.com/zklevsha/go-musthave-devops/internal/compactor"
 	"github.com/zklevsha/go-musthave-devops/internal/config"
 	"github.com/zklevsha/go-musthave-devops/internal/db"
 	"github.com/zklevsha/go-musthave-devops/internal/dumper"
+	"github.com/zklevsha/go-musthave-devops/internal/graphite"
 	"github.com/zklevsha/go-musthave-devops/internal/gserver"
 	"github.com/zklevsha/go-musthave-devops/internal/handlers"
 	"github.com/zklevsha/go-musthave-devops/internal/rsaencrypt"
+	"github.com/zklevsha/go-musthave-devops/internal/statsd"
 	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"github.com/zklevsha/go-musthave-devops/internal/wal"
 )
 
 var wg sync.WaitGroup
@@ -35,16 +45,114 @@ func printStartupInfo() {
 	log.Printf("DEBUG ENVs: %v", os.Environ())
 }
 
+// openStorage initializes storage backend chosen in config. Memory storage is restored from
+// and dumped to the store file
+func openStorage(ctx context.Context, c config.ServerConfig) structs.Storage {
+	switch c.StorageBackend {
+	case config.StoragePostgres:
+		log.Printf("INFO main QueryTimeout: %s", c.QueryTimeout)
+		s := &db.DBConnector{DSN: c.DSN, Ctx: ctx, KeepHistory: c.KeepHistory, QueryTimeout: c.QueryTimeout}
+		err := s.Init()
+		if err != nil {
+			log.Panicf("failed to init connection to database: %s", err.Error())
+		}
+		return s
+	case config.StorageBolt:
+		log.Printf("INFO main storage file: %s", c.StoragePath)
+		s := &boltdb.BoltStorage{Path: c.StoragePath, KeepHistory: c.KeepHistory}
+		err := s.Init()
+		if err != nil {
+			log.Panicf("failed to open storage file: %s", err.Error())
+		}
+		return s
+	default:
+		log.Printf("INFO main StoreInterval: %s, StoreFile: %s, StoreGenerations: %d, StoreCompress: %t, Restore: %t, "+
+			"WALFile: %s, WALSync: %s, WALSyncInterval: %s",
+			c.StoreInterval, c.StoreFile, c.StoreGenerations, c.StoreCompress, c.Restore,
+			c.WALFile, c.WALSync, c.WALSyncInterval)
+		storeFile := dumper.StoreFile{Path: c.StoreFile, Generations: c.StoreGenerations, Compress: c.StoreCompress}
+		var s structs.Storage
+		if c.KeepHistory {
+			s = structs.NewMemoryStorageWithHistory()
+		} else {
+			s = structs.NewMemoryStorage()
+		}
+		var seq uint64
+		if c.Restore {
+			// WAL holds changes made after the dump, so it is replayed on top of the restored dump
+			seq = dumper.RestoreData(ctx, storeFile, s)
+			if c.WALFile != "" {
+				seq = dumper.ReplayWAL(ctx, c.WALFile, s, seq)
+			}
+		} else {
+			// new changes must be numbered after the ones saved by the old dump
+			seq = dumper.LastSeq(storeFile)
+		}
+		var walLog *wal.Log
+		var syncInterval time.Duration
+		if c.WALFile == "" || c.WALSync == config.WALSyncOff {
+			log.Println("INFO main WAL is disabled")
+			if c.WALFile != "" {
+				// stale records must not be replayed once WAL is enabled again
+				err := os.Remove(c.WALFile)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					log.Printf("WARN main failed to remove WAL %s: %s", c.WALFile, err.Error())
+				}
+			}
+		} else {
+			var err error
+			walLog, err = wal.Open(c.WALFile, c.WALSync == config.WALSyncAlways)
+			if err != nil {
+				log.Panicf("failed to open WAL: %s", err.Error())
+			}
+			if !c.Restore {
+				// changes of the previous run are not restored, so they are not replayed next time either
+				err = walLog.Truncate()
+				if err != nil {
+					log.Panicf("failed to truncate WAL: %s", err.Error())
+				}
+			}
+			if c.WALSync == config.WALSyncInterval {
+				syncInterval = c.WALSyncInterval
+			}
+		}
+		// Starting dumper
+		var ds *dumper.Storage
+		if c.StoreInterval == 0 {
+			// every change is dumped before the response is sent
+			ds = dumper.NewSyncStorage(s, walLog, syncInterval, seq, storeFile)
+		} else {
+			ds = dumper.NewStorage(s, walLog, syncInterval, seq)
+		}
+		wg.Add(1)
+		go dumper.Start(ctx, &wg, c.StoreInterval, storeFile, ds)
+		return ds
+	}
+}
+
 func main() {
 	printStartupInfo()
 
 	config := config.GetServerConfig(os.Args[1:])
 
-	logMsg := fmt.Sprintf("INFO main server config: ServerAddress: %s, UseDB: %t, privateKeyPath %s, GRPCAddress: %s",
-		config.ServerAddress, config.UseDB, config.PrivateKeyPath, config.GRPCAddress)
-	if !config.UseDB {
-		logMsg += fmt.Sprintf(", StoreInterval: %s, StoreFile: %s, Restore: %t",
-			config.StoreInterval, config.StoreFile, config.Restore)
+	logMsg := fmt.Sprintf("INFO main server config: ServerAddress: %s, StorageBackend: %s, privateKeyPath %s, GRPCAddress: %s, KeepHistory: %t, "+
+		"TLSCert: %s, TLSKey: %s, TLSClientCA: %s, AgentTimeout: %s",
+		config.ServerAddress, config.StorageBackend, config.PrivateKeyPath, config.GRPCAddress, config.KeepHistory,
+		config.TLSCert, config.TLSKey, config.TLSClientCA, config.AgentTimeout)
+	if config.KeepHistory {
+		logMsg += fmt.Sprintf(", RetentionRaw: %s, RetentionRollup: %s, RetentionRollupHour: %s, CompactInterval: %s",
+			config.RetentionRaw, config.RetentionRollup, config.RetentionRollupHour, config.CompactInterval)
+	}
+	if config.StatsDAddress != "" {
+		logMsg += fmt.Sprintf(", StatsDAddress: %s, StatsDFlushInterval: %s",
+			config.StatsDAddress, config.StatsDFlushInterval)
+	}
+	if config.GraphiteAddress != "" {
+		logMsg += fmt.Sprintf(", GraphiteAddress: %s, GraphiteCounters: %v",
+			config.GraphiteAddress, config.GraphiteCounters)
+	}
+	if len(config.InfluxCounters) > 0 {
+		logMsg += fmt.Sprintf(", InfluxCounters: %v", config.InfluxCounters)
 	}
 	log.Println(logMsg)
 
@@ -57,39 +165,52 @@ func main() {
 		}
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	var s structs.Storage
-	if config.UseDB {
-		s = &db.DBConnector{DSN: config.DSN, Ctx: ctx}
-		err := s.Init()
+	var tlsConf *tls.Config
+	// client CA without certificate and key would silently serve plain HTTP without client verification
+	if config.TLSCert != "" || config.TLSKey != "" || config.TLSClientCA != "" {
+		tlsConf, err = certs.ServerTLSConfig(config.TLSCert, config.TLSKey, config.TLSClientCA)
 		if err != nil {
-			log.Panicf("failed to init connection to database: %s", err.Error())
+			log.Fatalf("CRITICAL failed to load TLS configuration: %s", err.Error())
 		}
-		defer s.Close()
-	} else {
-		s = structs.NewMemoryStorage()
-		if config.Restore {
-			dumper.RestoreData(config.StoreFile, s)
-		}
-		// Starting dumper
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := openStorage(ctx, config)
+	defer s.Close()
+
+	// Starting history compactor
+	if config.KeepHistory {
+		policy := structs.RetentionPolicy{Raw: config.RetentionRaw,
+			Rollup: config.RetentionRollup, RollupHour: config.RetentionRollupHour}
 		wg.Add(1)
-		go dumper.Start(ctx, &wg, config.StoreInterval, config.StoreFile, s)
+		go compactor.Start(ctx, &wg, config.CompactInterval, policy, s)
 	}
 
+	// Tracking agents reporting metrics (for /agents endpoint)
+	s = agents.NewTracker(s)
+
 	// Starting web server
 	handler := handlers.GetHandler(config, s, privKey)
 	fmt.Printf("INFO starting web server at %s\n", config.ServerAddress)
 
 	srv := &http.Server{
-		Addr:    config.ServerAddress,
-		Handler: handler,
+		Addr:      config.ServerAddress,
+		Handler:   handler,
+		TLSConfig: tlsConf,
 	}
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConf != nil {
+			// certificates are already loaded into srv.TLSConfig
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			cancel()
 			wg.Wait()
 			log.Fatalf("CRITICAL Failed to start web server: %s\n", err)
@@ -99,9 +220,33 @@ func main() {
 
 	// Srarting gRPC server
 	log.Printf("INFO starting gRPC server")
-	go gserver.Start(config.GRPCAddress, s)
+	go gserver.Start(config, s, tlsConf)
 	log.Printf("INFO gRPC server was started")
 
+	// Starting StatsD listener
+	if config.StatsDAddress != "" {
+		statsdServer, err := statsd.Listen(config.StatsDAddress, config.StatsDFlushInterval, s)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			log.Fatalf("CRITICAL Failed to start StatsD listener: %s", err.Error())
+		}
+		wg.Add(1)
+		go statsdServer.Start(ctx, &wg)
+	}
+
+	// Starting Graphite listener
+	if config.GraphiteAddress != "" {
+		graphiteServer, err := graphite.Listen(config.GraphiteAddress, config.GraphiteCounters, config.TrustedSubnet, s)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			log.Fatalf("CRITICAL Failed to start Graphite listener: %s", err.Error())
+		}
+		wg.Add(1)
+		go graphiteServer.Start(ctx, &wg)
+	}
+
 	// Handling shutdown
 	sig := <-done
 	log.Printf("INFO main got a signal '%v', start shutting down...\n", sig)
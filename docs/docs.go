@@ -16,6 +16,320 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/agents": {
+            "get": {
+                "description": "Listing agents which have reported metrics (identified by instance label) with their last-seen time.\nAgents which have not reported for the agent timeout are marked as silent",
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "agents"
+                ],
+                "summary": "List agents",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "list only silent agents",
+                        "name": "silent",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/structs.AgentList"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/metrics": {
+            "get": {
+                "description": "Listing metrics page filtered by type and ID prefix/regex.\nPass next_cursor of the response as cursor to get the next page",
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "metrics"
+                ],
+                "summary": "List metrics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "metric type (counter, gauge)",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "metric ID prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "metric ID regular expression",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "id",
+                        "description": "sort key (id, type)",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "asc",
+                        "description": "sort order (asc, desc)",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "page size (max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/structs.MetricList"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/write": {
+            "post": {
+                "description": "Storing series pushed by Prometheus (snappy compressed protobuf WriteRequest).\nSeries labels are stored as metric labels, samples are stored as gauges",
+                "consumes": [
+                    "application/x-protobuf"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "metrics"
+                ],
+                "summary": "Prometheus remote write",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "HMAC-SHA256 of the body signed with the key (required if the key is set)",
+                        "name": "HashSHA256",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/history/{metricType}/{metricID}": {
+            "get": {
+                "description": "Retreiving timestamped metric samples (or their rollups) for the time range",
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "metrics"
+                ],
+                "summary": "Get metric history",
+                "parameters": [
+                    {
+                        "enum": [
+                            "counter",
+                            "gauge"
+                        ],
+                        "type": "string",
+                        "description": "metric type",
+                        "name": "metricType",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "metric id",
+                        "name": "metricID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "range start (RFC3339 or unix timestamp)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "range end (RFC3339 or unix timestamp)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "raw",
+                            "1m",
+                            "1h"
+                        ],
+                        "type": "string",
+                        "description": "rollup resolution",
+                        "name": "resolution",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "label matchers (name=value, name!=value, name=~regexp, name!~regexp)",
+                        "name": "match",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/structs.MetricHistory"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics": {
+            "get": {
+                "description": "Exposing all metrics in Prometheus text format (or OpenMetrics if client accepts it)",
+                "produces": [
+                    "text/plain",
+                    "application/openmetrics-text"
+                ],
+                "tags": [
+                    "metrics"
+                ],
+                "summary": "Prometheus metrics",
+                "parameters": [
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "label matchers (name=value, name!=value, name=~regexp, name!~regexp)",
+                        "name": "match",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    }
+                }
+            }
+        },
         "/ping": {
             "get": {
                 "description": "Checking if DB is available",
@@ -37,6 +351,76 @@ const docTemplate = `{
                 }
             }
         },
+        "/reset/{metricID}": {
+            "post": {
+                "description": "Setting series of the counter selected by matchers (all series if none) to zero.\nRefused unless key or trusted subnet is configured, requests must be signed when key is set",
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "metrics"
+                ],
+                "summary": "Reset counter",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "counter id",
+                        "name": "metricID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "label matchers (name=value, name!=value, name=~regexp, name!~regexp)",
+                        "name": "match",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "unix time the request was signed at, accepted within 5 minutes",
+                        "name": "ts",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "hmac of reset:metricID:counter:matchers joined by ';':ts",
+                        "name": "hash",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    }
+                }
+            }
+        },
         "/update/": {
             "post": {
                 "description": "Set or Update metrics value",
@@ -206,6 +590,60 @@ const docTemplate = `{
                 }
             }
         },
+        "/v1/metrics": {
+            "post": {
+                "description": "Storing metrics sent by OpenTelemetry exporters (OTLP/HTTP, protobuf or JSON encoded).\nMonotonic sums are stored as counter deltas, gauges and non-monotonic sums as gauges,\nhistograms as \u003cname\u003e_count and \u003cname\u003e_bucket counters and \u003cname\u003e_sum gauge",
+                "consumes": [
+                    "application/x-protobuf",
+                    "application/json"
+                ],
+                "produces": [
+                    "application/x-protobuf",
+                    "application/json"
+                ],
+                "tags": [
+                    "metrics"
+                ],
+                "summary": "OTLP metrics export",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "HMAC-SHA256 of the body signed with the key (required if the key is set)",
+                        "name": "HashSHA256",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    }
+                }
+            }
+        },
         "/value/": {
             "post": {
                 "description": "Retreiving metric value",
@@ -255,7 +693,7 @@ const docTemplate = `{
         },
         "/value/{metricType}/{metricID}": {
             "get": {
-                "description": "Retreiving metric value",
+                "description": "Retreiving metric value. Without matchers the series without labels is returned,\nor the only series of the metric if there is no such series.\nAgents label their series with host and instance, so once several agents report the metric\nthe request is answered with 409 and must select the series (e.g. ?match=instance=web1)",
                 "produces": [
                     "application/json"
                 ],
@@ -281,6 +719,16 @@ const docTemplate = `{
                         "name": "metricID",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "label matchers (name=value, name!=value, name=~regexp, name!~regexp)",
+                        "name": "match",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -290,6 +738,103 @@ const docTemplate = `{
                             "$ref": "#/definitions/structs.Metric"
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Deleting series of the metric selected by matchers (all series if none) together with their history.\nRefused unless key or trusted subnet is configured, requests must be signed when key is set",
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "metrics"
+                ],
+                "summary": "Delete metric",
+                "parameters": [
+                    {
+                        "enum": [
+                            "counter",
+                            "gauge"
+                        ],
+                        "type": "string",
+                        "description": "metric type",
+                        "name": "metricType",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "metric id",
+                        "name": "metricID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "label matchers (name=value, name!=value, name=~regexp, name!~regexp)",
+                        "name": "match",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "unix time the request was signed at, accepted within 5 minutes",
+                        "name": "ts",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "hmac of delete:metricID:metricType:matchers joined by ';':ts",
+                        "name": "hash",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -304,9 +849,100 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "/write": {
+            "post": {
+                "description": "Storing metrics sent in InfluxDB line protocol (Telegraf compatible, gzip bodies are supported).\nEvery field becomes a metric with measurement_field{tags} id. Float and boolean fields are stored as gauges,\ninteger fields are stored as counters if they match -influx-counters patterns, otherwise as gauges",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "metrics"
+                ],
+                "summary": "InfluxDB line protocol write",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "timestamp precision (ns, us, ms, s, m, h)",
+                        "name": "precision",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "HMAC-SHA256 of the body signed with the key (required if the key is set)",
+                        "name": "HashSHA256",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/structs.Response"
+                        }
+                    }
+                }
+            }
         }
     },
     "definitions": {
+        "structs.Agent": {
+            "type": "object",
+            "properties": {
+                "host": {
+                    "type": "string"
+                },
+                "instance": {
+                    "type": "string"
+                },
+                "last_seen": {
+                    "type": "string"
+                },
+                "silent": {
+                    "description": "Silent is set when agent has not reported metrics for the server agent timeout",
+                    "type": "boolean"
+                }
+            }
+        },
+        "structs.AgentList": {
+            "type": "object",
+            "properties": {
+                "agents": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/structs.Agent"
+                    }
+                },
+                "hash": {
+                    "type": "string"
+                }
+            }
+        },
+        "structs.Labels": {
+            "type": "object",
+            "additionalProperties": {
+                "type": "string"
+            }
+        },
         "structs.Metric": {
             "type": "object",
             "properties": {
@@ -322,6 +958,10 @@ const docTemplate = `{
                     "description": "имя метрики",
                     "type": "string"
                 },
+                "labels": {
+                    "description": "метки, различающие серии одной метрики",
+                    "$ref": "#/definitions/structs.Labels"
+                },
                 "type": {
                     "description": "параметр, принимающий значение gauge или counter",
                     "type": "string"
@@ -340,6 +980,10 @@ const docTemplate = `{
                     "type": "string",
                     "example": "CPU"
                 },
+                "labels": {
+                    "description": "метки серии",
+                    "$ref": "#/definitions/structs.Labels"
+                },
                 "type": {
                     "description": "параметр, принимающий значение gauge или counter",
                     "type": "string",
@@ -347,6 +991,55 @@ const docTemplate = `{
                 }
             }
         },
+        "structs.MetricHistory": {
+            "type": "object",
+            "properties": {
+                "hash": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "labels": {
+                    "$ref": "#/definitions/structs.Labels"
+                },
+                "resolution": {
+                    "type": "string"
+                },
+                "rollups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/structs.Rollup"
+                    }
+                },
+                "samples": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/structs.Sample"
+                    }
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "structs.MetricList": {
+            "type": "object",
+            "properties": {
+                "hash": {
+                    "type": "string"
+                },
+                "metrics": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/structs.Metric"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                }
+            }
+        },
         "structs.Response": {
             "type": "object",
             "properties": {
@@ -360,6 +1053,40 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "structs.Rollup": {
+            "type": "object",
+            "properties": {
+                "avg": {
+                    "type": "number"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "max": {
+                    "type": "number"
+                },
+                "min": {
+                    "type": "number"
+                },
+                "timestamp": {
+                    "type": "string"
+                }
+            }
+        },
+        "structs.Sample": {
+            "type": "object",
+            "properties": {
+                "delta": {
+                    "type": "integer"
+                },
+                "timestamp": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "number"
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,77 @@
+// Package agents keeps track of agents reporting metrics to the server.
+// Agents are identified by the instance label attached to metrics updates sent by agents
+// (see structs.AgentContext), series of other ingestion protocols do not register agents
+package agents
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// Tracker is a storage wrapper recording the last time each agent has updated metrics.
+// Agents are kept in memory only, so after restart the list is filled as agents report again
+type Tracker struct {
+	structs.Storage
+	mx     sync.Mutex
+	agents map[string]*structs.Agent
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewTracker(store structs.Storage) *Tracker {
+	return &Tracker{Storage: store, agents: map[string]*structs.Agent{}, now: time.Now}
+}
+
+func (t *Tracker) UpdateMetric(ctx context.Context, m structs.Metric) error {
+	err := t.Storage.UpdateMetric(ctx, m)
+	if err == nil && structs.IsAgentContext(ctx) {
+		t.seen([]structs.Metric{m})
+	}
+	return err
+}
+
+func (t *Tracker) UpdateMetrics(ctx context.Context, metrics []structs.Metric) error {
+	err := t.Storage.UpdateMetrics(ctx, metrics)
+	if err == nil && structs.IsAgentContext(ctx) {
+		t.seen(metrics)
+	}
+	return err
+}
+
+func (t *Tracker) seen(metrics []structs.Metric) {
+	now := t.now()
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for _, m := range metrics {
+		instance := m.Labels[structs.LabelInstance]
+		if instance == "" {
+			continue
+		}
+		a, ok := t.agents[instance]
+		if !ok {
+			a = &structs.Agent{Instance: instance}
+			t.agents[instance] = a
+		}
+		a.Host = m.Labels[structs.LabelHost]
+		a.LastSeen = now
+	}
+}
+
+// Agents returns known agents sorted by instance. Agents which have not reported for timeout are marked as silent
+func (t *Tracker) Agents(timeout time.Duration) []structs.Agent {
+	now := t.now()
+	t.mx.Lock()
+	agents := make([]structs.Agent, 0, len(t.agents))
+	for _, a := range t.agents {
+		agent := *a
+		agent.Silent = now.Sub(agent.LastSeen) > timeout
+		agents = append(agents, agent)
+	}
+	t.mx.Unlock()
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Instance < agents[j].Instance })
+	return agents
+}
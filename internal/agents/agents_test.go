@@ -0,0 +1,63 @@
+package agents
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker(structs.NewMemoryStorage())
+	tracker.now = func() time.Time { return now }
+
+	gauge := func(id string, labels structs.Labels) structs.Metric {
+		v := 1.0
+		return structs.Metric{ID: id, MType: "gauge", Value: &v, Labels: labels}
+	}
+	ctx := structs.AgentContext(context.Background())
+	err := tracker.UpdateMetrics(ctx, []structs.Metric{
+		gauge("Alloc", structs.Labels{"host": "web1", "instance": "web1"}),
+		gauge("Alloc", structs.Labels{"host": "web2", "instance": "shop"}),
+		gauge("Alloc", nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Minute)
+	err = tracker.UpdateMetric(ctx, gauge("Alloc", structs.Labels{"host": "web3", "instance": "shop"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// series of other ingestion protocols (e.g. remote_write) do not register agents
+	err = tracker.UpdateMetric(context.Background(), gauge("up", structs.Labels{"instance": "node:9100"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// failed updates do not count
+	err = tracker.UpdateMetric(ctx, structs.Metric{ID: "bad", MType: "histogram",
+		Labels: structs.Labels{"instance": "broken"}})
+	if err == nil {
+		t.Fatal("update with bad type must fail")
+	}
+
+	want := []structs.Agent{
+		{Instance: "shop", Host: "web3", LastSeen: now},
+		{Instance: "web1", Host: "web1", LastSeen: now.Add(-2 * time.Minute), Silent: true},
+	}
+	have := tracker.Agents(time.Minute)
+	if len(have) != len(want) {
+		t.Fatalf("agents count mismatch: have %v, want %v", have, want)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("agent %d mismatch: have %v, want %v", i, have[i], want[i])
+		}
+	}
+
+	if _, err := tracker.GetMetric(context.Background(), gauge("Alloc", structs.Labels{"host": "web1", "instance": "web1"})); err != nil {
+		t.Errorf("metric was not stored: %s", err.Error())
+	}
+}
@@ -0,0 +1,620 @@
+// Package boltdb keeps metrics in a single file with embedded bbolt key-value store.
+// Every update is committed in a fsynced transaction, so no data is lost on crash
+package boltdb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Top level buckets. Series are keyed by structs.SeriesKey,
+// history and rollups are nested buckets keyed by series type and key
+var (
+	countersBucket = []byte("counters")
+	gaugesBucket   = []byte("gauges")
+	historyBucket  = []byte("history")
+	rollupsBucket  = []byte("rollups")
+)
+
+var rollupResolutions = []time.Duration{structs.RollupMinute, structs.RollupHour}
+
+type BoltStorage struct {
+	Path        string
+	KeepHistory bool
+	db          *bolt.DB
+}
+
+func (s *BoltStorage) Init() error {
+	db, err := bolt.Open(s.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("%w: failed to open %s: %s", structs.ErrStorageUnavailable, s.Path, err.Error())
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{countersBucket, gaugesBucket, historyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		rollups, err := tx.CreateBucketIfNotExists(rollupsBucket)
+		if err != nil {
+			return err
+		}
+		for _, resolution := range rollupResolutions {
+			if _, err := rollups.CreateBucketIfNotExists(resolutionKey(resolution)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to create buckets in %s: %s", s.Path, err.Error())
+	}
+	s.db = db
+	return nil
+}
+
+func (s *BoltStorage) Close() {
+	if s.db == nil {
+		return
+	}
+	err := s.db.Close()
+	if err != nil {
+		log.Printf("ERROR failed to close %s: %s", s.Path, err.Error())
+	}
+}
+
+func (s *BoltStorage) Avaliable(ctx context.Context) error {
+	err := s.checkInit(ctx)
+	if err != nil {
+		return err
+	}
+	return s.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+// checkInit also checks ctx: bolt transactions can not be cancelled, so they are not started for done requests
+func (s *BoltStorage) checkInit(ctx context.Context) error {
+	if s.db == nil {
+		return fmt.Errorf("%w: storage %s is not initialized, call Init() first",
+			structs.ErrStorageUnavailable, s.Path)
+	}
+	return ctx.Err()
+}
+
+// seriesBucket returns bucket keeping series of the metric type
+func seriesBucket(tx *bolt.Tx, mtype string) (*bolt.Bucket, error) {
+	switch mtype {
+	case "counter":
+		return tx.Bucket(countersBucket), nil
+	case "gauge":
+		return tx.Bucket(gaugesBucket), nil
+	default:
+		return nil, structs.ErrMetricBadType
+	}
+}
+
+func historyKey(mtype string, id string, labels structs.Labels) []byte {
+	return []byte(mtype + ":" + structs.SeriesKey(id, labels))
+}
+
+func resolutionKey(resolution time.Duration) []byte {
+	return []byte(strconv.FormatInt(int64(resolution/time.Second), 10))
+}
+
+// timeKey encodes timestamp so keys are sorted in time order.
+// Timestamps out of UnixNano range (e.g. zero time of open ranges) are clamped
+func timeKey(t time.Time) []byte {
+	var nanos uint64
+	switch {
+	case t.Before(time.Unix(0, 0)):
+		nanos = 0
+	case t.After(time.Unix(0, math.MaxInt64)):
+		nanos = math.MaxInt64
+	default:
+		nanos = uint64(t.UnixNano())
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, nanos)
+	return key
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+}
+
+func decodeMetric(v []byte) (structs.Metric, error) {
+	var m structs.Metric
+	err := json.Unmarshal(v, &m)
+	if err != nil {
+		return structs.Metric{}, fmt.Errorf("failed to decode metric %s: %s", v, err.Error())
+	}
+	return m, nil
+}
+
+func (s *BoltStorage) GetMetric(ctx context.Context, m structs.Metric) (structs.Metric, error) {
+	err := s.checkInit(ctx)
+	if err != nil {
+		return structs.Metric{}, err
+	}
+	var stored structs.Metric
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b, err := seriesBucket(tx, m.MType)
+		if err != nil {
+			return err
+		}
+		v := b.Get([]byte(structs.SeriesKey(m.ID, m.Labels)))
+		if v == nil {
+			return structs.ErrMetricNotFound
+		}
+		stored, err = decodeMetric(v)
+		return err
+	})
+	if err != nil {
+		return structs.Metric{}, err
+	}
+	m.Delta = stored.Delta
+	m.Value = stored.Value
+	return m, nil
+}
+
+func (s *BoltStorage) GetMetrics(ctx context.Context) ([]structs.Metric, error) {
+	err := s.checkInit(ctx)
+	if err != nil {
+		return []structs.Metric{}, err
+	}
+	var metrics = []structs.Metric{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{countersBucket, gaugesBucket} {
+			err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+				m, err := decodeMetric(v)
+				if err != nil {
+					return err
+				}
+				metrics = append(metrics, m)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return []structs.Metric{}, err
+	}
+	return metrics, nil
+}
+
+func (s *BoltStorage) ListMetrics(ctx context.Context, q structs.ListQuery) (structs.MetricList, error) {
+	metrics, err := s.GetMetrics(ctx)
+	if err != nil {
+		return structs.MetricList{}, err
+	}
+	return structs.ListMetrics(metrics, q)
+}
+
+// updateMetric adds counter delta or overwrites gauge value and records history sample
+func (s *BoltStorage) updateMetric(tx *bolt.Tx, m structs.Metric, now time.Time) error {
+	b, err := seriesBucket(tx, m.MType)
+	if err != nil {
+		log.Printf("ERROR: cant update %s. Metric has unknown type: %s", m.ID, m.MType)
+		return err
+	}
+	if m.MType == "counter" && m.Delta == nil || m.MType == "gauge" && m.Value == nil {
+		return structs.ErrMetricNullAttr
+	}
+	key := []byte(structs.SeriesKey(m.ID, m.Labels))
+	stored := structs.Metric{ID: m.ID, MType: m.MType, Labels: m.Labels}
+	sample := structs.Sample{Timestamp: now}
+	if m.MType == "counter" {
+		delta := *m.Delta
+		if v := b.Get(key); v != nil {
+			prev, err := decodeMetric(v)
+			if err != nil {
+				return err
+			}
+			delta += *prev.Delta
+		}
+		stored.Delta = &delta
+		sample.Delta = &delta
+	} else {
+		value := *m.Value
+		stored.Value = &value
+		sample.Value = &value
+	}
+	v, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	err = b.Put(key, v)
+	if err != nil {
+		return err
+	}
+	if !s.KeepHistory {
+		return nil
+	}
+	return addSample(tx, historyKey(m.MType, m.ID, m.Labels), sample)
+}
+
+// addSample stores sample under its timestamp. Samples of the same nanosecond are shifted
+// by 1ns to keep them all in update order
+func addSample(tx *bolt.Tx, key []byte, sample structs.Sample) error {
+	b, err := tx.Bucket(historyBucket).CreateBucketIfNotExists(key)
+	if err != nil {
+		return err
+	}
+	if last, _ := b.Cursor().Last(); last != nil && !keyTime(last).Before(sample.Timestamp) {
+		sample.Timestamp = keyTime(last).Add(time.Nanosecond)
+	}
+	v, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	return b.Put(timeKey(sample.Timestamp), v)
+}
+
+func (s *BoltStorage) UpdateMetric(ctx context.Context, m structs.Metric) error {
+	return s.UpdateMetrics(ctx, []structs.Metric{m})
+}
+
+// UpdateMetrics saves metrics in a single transaction: either all metrics are saved or none
+func (s *BoltStorage) UpdateMetrics(ctx context.Context, metrics []structs.Metric) error {
+	err := s.checkInit(ctx)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		// timestamp is taken inside of the (exclusive) transaction to keep samples ordered
+		now := time.Now()
+		for _, m := range metrics {
+			err := s.updateMetric(tx, m, now)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ResetCounter resets series of the counter selected by matchers
+func (s *BoltStorage) ResetCounter(ctx context.Context, ID string, matchers []structs.LabelMatcher) error {
+	err := s.checkInit(ctx)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(countersBucket)
+		var reset []structs.Metric
+		err := b.ForEach(func(k, v []byte) error {
+			m, err := decodeMetric(v)
+			if err != nil {
+				return err
+			}
+			if m.ID == ID && structs.MatchLabels(m.Labels, matchers) {
+				reset = append(reset, m)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(reset) == 0 {
+			return structs.ErrMetricNotFound
+		}
+		for _, m := range reset {
+			var zero int64
+			m.Delta = &zero
+			v, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(structs.SeriesKey(m.ID, m.Labels)), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteMetric deletes series of the metric selected by matchers together with their history
+func (s *BoltStorage) DeleteMetric(ctx context.Context, m structs.Metric, matchers []structs.LabelMatcher) error {
+	err := s.checkInit(ctx)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := seriesBucket(tx, m.MType)
+		if err != nil {
+			return err
+		}
+		var deleted []structs.Metric
+		err = b.ForEach(func(k, v []byte) error {
+			stored, err := decodeMetric(v)
+			if err != nil {
+				return err
+			}
+			if stored.ID == m.ID && structs.MatchLabels(stored.Labels, matchers) {
+				deleted = append(deleted, stored)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(deleted) == 0 {
+			return structs.ErrMetricNotFound
+		}
+
+		rollups := tx.Bucket(rollupsBucket)
+		for _, d := range deleted {
+			err = b.Delete([]byte(structs.SeriesKey(d.ID, d.Labels)))
+			if err != nil {
+				return err
+			}
+			key := historyKey(m.MType, d.ID, d.Labels)
+			err = deleteBucket(tx.Bucket(historyBucket), key)
+			if err != nil {
+				return err
+			}
+			for _, resolution := range rollupResolutions {
+				err = deleteBucket(rollups.Bucket(resolutionKey(resolution)), key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// deleteBucket deletes nested bucket if it exists
+func deleteBucket(parent *bolt.Bucket, key []byte) error {
+	err := parent.DeleteBucket(key)
+	if err == bolt.ErrBucketNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *BoltStorage) GetMetricHistory(ctx context.Context, m structs.Metric,
+	from time.Time, to time.Time) ([]structs.Sample, error) {
+	if !s.KeepHistory {
+		return []structs.Sample{}, structs.ErrHistoryDisabled
+	}
+	if m.MType != "counter" && m.MType != "gauge" {
+		return []structs.Sample{}, structs.ErrMetricBadType
+	}
+	err := s.checkInit(ctx)
+	if err != nil {
+		return []structs.Sample{}, err
+	}
+	var samples = []structs.Sample{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket).Bucket(historyKey(m.MType, m.ID, m.Labels))
+		if b == nil {
+			return structs.ErrMetricNotFound
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil && !keyTime(k).After(to); k, v = c.Next() {
+			var sample structs.Sample
+			if err := json.Unmarshal(v, &sample); err != nil {
+				return fmt.Errorf("failed to decode sample %s: %s", v, err.Error())
+			}
+			samples = append(samples, sample)
+		}
+		return nil
+	})
+	if err != nil {
+		return []structs.Sample{}, err
+	}
+	return samples, nil
+}
+
+func (s *BoltStorage) GetMetricRollups(ctx context.Context, m structs.Metric, resolution time.Duration,
+	from time.Time, to time.Time) ([]structs.Rollup, error) {
+	if !s.KeepHistory {
+		return []structs.Rollup{}, structs.ErrHistoryDisabled
+	}
+	if m.MType != "counter" && m.MType != "gauge" {
+		return []structs.Rollup{}, structs.ErrMetricBadType
+	}
+	err := s.checkInit(ctx)
+	if err != nil {
+		return []structs.Rollup{}, err
+	}
+	var rollups = []structs.Rollup{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		byKey := tx.Bucket(rollupsBucket).Bucket(resolutionKey(resolution))
+		if byKey == nil {
+			return structs.ErrBadResolution
+		}
+		key := historyKey(m.MType, m.ID, m.Labels)
+		b := byKey.Bucket(key)
+		if b == nil {
+			if tx.Bucket(historyBucket).Bucket(key) == nil {
+				return structs.ErrMetricNotFound
+			}
+			return nil
+		}
+		var err error
+		rollups, err = readRollups(b, from, to)
+		return err
+	})
+	if err != nil {
+		return []structs.Rollup{}, err
+	}
+	return rollups, nil
+}
+
+// readRollups returns rollups of buckets started within [from, to]
+func readRollups(b *bolt.Bucket, from time.Time, to time.Time) ([]structs.Rollup, error) {
+	var rollups = []structs.Rollup{}
+	c := b.Cursor()
+	for k, v := c.Seek(timeKey(from)); k != nil && !keyTime(k).After(to); k, v = c.Next() {
+		var r structs.Rollup
+		if err := json.Unmarshal(v, &r); err != nil {
+			return []structs.Rollup{}, fmt.Errorf("failed to decode rollup %s: %s", v, err.Error())
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, nil
+}
+
+// writeRollups merges rollups into the stored ones
+func writeRollups(b *bolt.Bucket, rollups []structs.Rollup) error {
+	for _, r := range rollups {
+		key := timeKey(r.Timestamp)
+		if v := b.Get(key); v != nil {
+			var stored structs.Rollup
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return fmt.Errorf("failed to decode rollup %s: %s", v, err.Error())
+			}
+			r = stored.Merge(r)
+		}
+		v, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteBefore deletes bucket keys of timestamps before cutoff
+func deleteBefore(b *bolt.Bucket, cutoff time.Time) error {
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && keyTime(k).Before(cutoff); k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nestedKeys returns keys of nested buckets (keys are copied, they are valid after the transaction)
+func nestedKeys(b *bolt.Bucket) [][]byte {
+	var keys [][]byte
+	b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	return keys
+}
+
+// isEmpty reports whether bucket is missing or has no keys
+func isEmpty(b *bolt.Bucket) bool {
+	if b == nil {
+		return true
+	}
+	k, _ := b.Cursor().First()
+	return k == nil
+}
+
+func (s *BoltStorage) CompactHistory(ctx context.Context, policy structs.RetentionPolicy, now time.Time) error {
+	if !s.KeepHistory {
+		return structs.ErrHistoryDisabled
+	}
+	err := s.checkInit(ctx)
+	if err != nil {
+		return err
+	}
+	rawCutoff := now.Add(-policy.Raw).Truncate(structs.RollupMinute)
+	minuteCutoff := now.Add(-policy.Rollup).Truncate(structs.RollupHour)
+	hourCutoff := now.Add(-policy.RollupHour)
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket)
+		rollups := tx.Bucket(rollupsBucket)
+		minutes := rollups.Bucket(resolutionKey(structs.RollupMinute))
+		hours := rollups.Bucket(resolutionKey(structs.RollupHour))
+
+		// raw samples -> minute rollups
+		if policy.Raw > 0 {
+			for _, key := range nestedKeys(history) {
+				b := history.Bucket(key)
+				var samples []structs.Sample
+				c := b.Cursor()
+				for k, v := c.First(); k != nil && keyTime(k).Before(rawCutoff); k, v = c.Next() {
+					var sample structs.Sample
+					if err := json.Unmarshal(v, &sample); err != nil {
+						return fmt.Errorf("failed to decode sample %s: %s", v, err.Error())
+					}
+					samples = append(samples, sample)
+				}
+				if len(samples) == 0 {
+					continue
+				}
+				dst, err := minutes.CreateBucketIfNotExists(key)
+				if err != nil {
+					return err
+				}
+				if err := writeRollups(dst, structs.RollupSamples(samples, structs.RollupMinute)); err != nil {
+					return err
+				}
+				if err := deleteBefore(b, rawCutoff); err != nil {
+					return err
+				}
+			}
+		}
+
+		// minute rollups -> hour rollups
+		if policy.Rollup > 0 {
+			for _, key := range nestedKeys(minutes) {
+				b := minutes.Bucket(key)
+				old, err := readRollups(b, time.Time{}, minuteCutoff.Add(-time.Nanosecond))
+				if err != nil {
+					return err
+				}
+				if len(old) == 0 {
+					continue
+				}
+				dst, err := hours.CreateBucketIfNotExists(key)
+				if err != nil {
+					return err
+				}
+				if err := writeRollups(dst, structs.RollupRollups(old, structs.RollupHour)); err != nil {
+					return err
+				}
+				if err := deleteBefore(b, minuteCutoff); err != nil {
+					return err
+				}
+			}
+		}
+
+		// deleting old hour rollups
+		if policy.RollupHour > 0 {
+			for _, key := range nestedKeys(hours) {
+				if err := deleteBefore(hours.Bucket(key), hourCutoff); err != nil {
+					return err
+				}
+			}
+		}
+
+		// forgetting metrics without any history left
+		for _, key := range nestedKeys(history) {
+			if !isEmpty(history.Bucket(key)) || !isEmpty(minutes.Bucket(key)) || !isEmpty(hours.Bucket(key)) {
+				continue
+			}
+			for _, parent := range []*bolt.Bucket{history, minutes, hours} {
+				if err := deleteBucket(parent, key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,135 @@
+package boltdb
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+func openStorage(t *testing.T, path string, keepHistory bool) *BoltStorage {
+	s := &BoltStorage{Path: path, KeepHistory: keepHistory}
+	err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	s := &BoltStorage{Path: path}
+	err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := int64(2)
+	v := 1.5
+	labels := structs.Labels{"host": "web1"}
+	for i := 0; i < 2; i++ {
+		err = s.UpdateMetrics(context.Background(), []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d, Labels: labels},
+			{ID: "Alloc", MType: "gauge", Value: &v}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	s = openStorage(t, path, false)
+	counter, err := s.GetMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter", Labels: labels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *counter.Delta != 4 {
+		t.Errorf("counter value mismatch: have %d, want 4", *counter.Delta)
+	}
+	gauge, err := s.GetMetric(context.Background(), structs.Metric{ID: "Alloc", MType: "gauge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *gauge.Value != v {
+		t.Errorf("gauge value mismatch: have %f, want %f", *gauge.Value, v)
+	}
+}
+
+func TestUpdateMetricsAtomic(t *testing.T) {
+	s := openStorage(t, filepath.Join(t.TempDir(), "metrics.db"), false)
+	v := 1.5
+	err := s.UpdateMetrics(context.Background(), []structs.Metric{{ID: "Alloc", MType: "gauge", Value: &v},
+		{ID: "Bad", MType: "histogram"}})
+	if !errors.Is(err, structs.ErrMetricBadType) {
+		t.Fatalf("error mismatch: have %v, want %v", err, structs.ErrMetricBadType)
+	}
+	_, err = s.GetMetric(context.Background(), structs.Metric{ID: "Alloc", MType: "gauge"})
+	if !errors.Is(err, structs.ErrMetricNotFound) {
+		t.Errorf("metric of failed batch was saved: %v", err)
+	}
+}
+
+func TestCompactHistory(t *testing.T) {
+	s := openStorage(t, filepath.Join(t.TempDir(), "metrics.db"), true)
+	m := structs.Metric{ID: "Alloc", MType: "gauge"}
+	for _, v := range []float64{1, 3} {
+		value := v
+		m.Value = &value
+		err := s.UpdateMetric(context.Background(), m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// all raw samples are older than retention an hour later
+	now := time.Now().Add(time.Hour)
+	err := s.CompactHistory(context.Background(), structs.RetentionPolicy{Raw: time.Minute}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := s.GetMetricHistory(context.Background(), m, time.Time{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 0 {
+		t.Errorf("raw samples were not compacted: %v", samples)
+	}
+	rollups, err := s.GetMetricRollups(context.Background(), m, structs.RollupMinute, time.Time{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	for _, r := range rollups {
+		count += r.Count
+	}
+	if count != 2 {
+		t.Errorf("rollups samples count mismatch: have %d, want 2 (%v)", count, rollups)
+	}
+
+	// deleting all history
+	err = s.CompactHistory(context.Background(), structs.RetentionPolicy{Raw: time.Minute, Rollup: time.Minute, RollupHour: time.Minute},
+		now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetMetricRollups(context.Background(), m, structs.RollupHour, time.Time{}, now)
+	if !errors.Is(err, structs.ErrMetricNotFound) {
+		t.Errorf("history was not forgotten: %v", err)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	s := openStorage(t, filepath.Join(t.TempDir(), "metrics.db"), false)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	v := 1.5
+	err := s.UpdateMetric(ctx, structs.Metric{ID: "Alloc", MType: "gauge", Value: &v})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error mismatch: have %v, want %v", err, context.Canceled)
+	}
+	_, err = s.GetMetric(context.Background(), structs.Metric{ID: "Alloc", MType: "gauge"})
+	if !errors.Is(err, structs.ErrMetricNotFound) {
+		t.Errorf("metric of cancelled call was saved: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+// Package certs implements TLS configuration for servers and agents
+// and generation of certificates for local setups
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"time"
+)
+
+// Files created by Generate
+const CAFile = "ca.pem"
+const CAKeyFile = "ca-key.pem"
+const ServerCertFile = "server.pem"
+const ServerKeyFile = "server-key.pem"
+const AgentCertFile = "agent.pem"
+const AgentKeyFile = "agent-key.pem"
+
+const certValidity = 365 * 24 * time.Hour
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cant read file: {%s}: %s", caFile, err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// ServerTLSConfig returns TLS config for the server.
+// If clientCAFile is set clients must present a certificate signed by this CA (mTLS)
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both certificate and key must be set")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key pair %s/%s: %s", certFile, keyFile, err.Error())
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// ClientTLSConfig returns TLS config for the agent.
+// If caFile is not set system roots are used to verify the server.
+// certFile and keyFile are the client certificate presented to the server (mTLS)
+func ClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key pair %s/%s: %s", certFile, keyFile, err.Error())
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+func writePEM(fname string, blockType string, b []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("error when create %s: %s", fname, err.Error())
+	}
+	defer f.Close()
+	err = pem.Encode(f, &pem.Block{Type: blockType, Bytes: b})
+	if err != nil {
+		return fmt.Errorf("error when encode %s: %s", fname, err.Error())
+	}
+	return nil
+}
+
+func writeKeyPair(outDir, certFile, keyFile string, certDER []byte, key *ecdsa.PrivateKey) error {
+	err := writePEM(path.Join(outDir, certFile), "CERTIFICATE", certDER, 0644)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %s", err.Error())
+	}
+	return writePEM(path.Join(outDir, keyFile), "EC PRIVATE KEY", keyDER, 0600)
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// issue creates certificate from template signed by the CA and returns it with the generated key
+func issue(template *x509.Certificate, ca *x509.Certificate,
+	caKey *ecdsa.PrivateKey) ([]byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %s", err.Error())
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %s", err.Error())
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(certValidity)
+	if ca == nil {
+		// self signed
+		ca, caKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate %s: %s", template.Subject.CommonName, err.Error())
+	}
+	return der, key, nil
+}
+
+// Generate creates CA, server certificate for hosts (DNS names or IPs) and agent client certificate in outDir
+func Generate(outDir string, hosts []string) error {
+	// CA
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "devops-metrics CA"},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, caKey, err := issue(caTemplate, nil, nil)
+	if err != nil {
+		return err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA certificate: %s", err.Error())
+	}
+	err = writeKeyPair(outDir, CAFile, CAKeyFile, caDER, caKey)
+	if err != nil {
+		return err
+	}
+
+	// server
+	serverTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "devops-metrics server"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, h)
+		}
+	}
+	serverDER, serverKey, err := issue(serverTemplate, ca, caKey)
+	if err != nil {
+		return err
+	}
+	err = writeKeyPair(outDir, ServerCertFile, ServerKeyFile, serverDER, serverKey)
+	if err != nil {
+		return err
+	}
+
+	// agent
+	agentTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "devops-metrics agent"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	agentDER, agentKey, err := issue(agentTemplate, ca, caKey)
+	if err != nil {
+		return err
+	}
+	return writeKeyPair(outDir, AgentCertFile, AgentKeyFile, agentDER, agentKey)
+}
@@ -0,0 +1,76 @@
+package certs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+)
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	err := Generate(dir, []string{"127.0.0.1", "localhost"})
+	if err != nil {
+		t.Fatalf("Cant generate certificates: %s", err.Error())
+	}
+	file := func(name string) string { return path.Join(dir, name) }
+
+	tt := []struct {
+		name     string
+		clientCA string
+		certFile string
+		keyFile  string
+		caFile   string
+		wantErr  bool
+	}{
+		{name: "TLS", certFile: "", keyFile: "", caFile: file(CAFile)},
+		{name: "mTLS", clientCA: file(CAFile),
+			certFile: file(AgentCertFile), keyFile: file(AgentKeyFile), caFile: file(CAFile)},
+		{name: "mTLS without client certificate", clientCA: file(CAFile),
+			caFile: file(CAFile), wantErr: true},
+		{name: "unknown server CA", caFile: "", wantErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			serverConf, err := ServerTLSConfig(file(ServerCertFile), file(ServerKeyFile), tc.clientCA)
+			if err != nil {
+				t.Fatalf("Cant create server TLS config: %s", err.Error())
+			}
+			ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			ts.TLS = serverConf
+			ts.StartTLS()
+			defer ts.Close()
+
+			clientConf, err := ClientTLSConfig(tc.certFile, tc.keyFile, tc.caFile)
+			if err != nil {
+				t.Fatalf("Cant create client TLS config: %s", err.Error())
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConf}}
+			resp, err := client.Get(ts.URL)
+			if tc.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Errorf("Request must fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Request failed: %s", err.Error())
+			}
+			resp.Body.Close()
+		})
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	_, err := ServerTLSConfig("", "", "")
+	if err == nil {
+		t.Errorf("ServerTLSConfig must fail without certificate")
+	}
+	_, err = ServerTLSConfig("/nonexistent.pem", "/nonexistent-key.pem", "")
+	if err == nil {
+		t.Errorf("ServerTLSConfig must fail with nonexistent files")
+	}
+}
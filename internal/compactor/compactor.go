@@ -0,0 +1,38 @@
+// Package compactor enforces metric history retention: rolls up old samples and deletes expired rollups
+package compactor
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+func compact(ctx context.Context, policy structs.RetentionPolicy, store structs.Storage) {
+	log.Println("INFO compactor compacting metric history")
+	err := store.CompactHistory(ctx, policy, time.Now())
+	if err != nil {
+		log.Printf("ERROR compactor failed to compact history: %s\n", err.Error())
+	} else {
+		log.Println("INFO compactor successfully compacted history")
+	}
+}
+
+func Start(ctx context.Context, wg *sync.WaitGroup,
+	interval time.Duration, policy structs.RetentionPolicy, store structs.Storage) {
+	log.Println("INFO compactor starting")
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("INFO compactor received ctx.Done(), returning")
+			return
+		case <-ticker.C:
+			compact(ctx, policy, store)
+		}
+	}
+}
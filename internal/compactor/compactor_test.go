@@ -0,0 +1,148 @@
+package compactor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+var policy = structs.RetentionPolicy{
+	Raw:        time.Hour,
+	Rollup:     24 * time.Hour,
+	RollupHour: 7 * 24 * time.Hour,
+}
+
+func fillStorage(t *testing.T) structs.Storage {
+	store := structs.NewMemoryStorageWithHistory()
+	for _, v := range []float64{1, 2, 6} {
+		value := v
+		err := store.UpdateMetric(context.Background(), structs.Metric{ID: "testGauge", MType: "gauge", Value: &value})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	delta := int64(5)
+	for i := 0; i < 2; i++ {
+		err := store.UpdateMetric(context.Background(), structs.Metric{ID: "testCounter", MType: "counter", Delta: &delta})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestCompactHistory(t *testing.T) {
+	gauge := structs.Metric{ID: "testGauge", MType: "gauge"}
+	counter := structs.Metric{ID: "testCounter", MType: "counter"}
+	from := time.Time{}
+	to := time.Now().Add(365 * 24 * time.Hour)
+
+	type want struct {
+		samples int
+		minutes int
+		hours   int
+		avg     float64
+		err     error
+	}
+
+	tt := []struct {
+		name   string
+		metric structs.Metric
+		after  time.Duration
+		want   want
+	}{
+		{
+			name:   "raw samples are kept",
+			metric: gauge,
+			after:  time.Minute,
+			want:   want{samples: 3},
+		},
+		{
+			name:   "raw samples are rolled up per minute",
+			metric: gauge,
+			after:  2 * time.Hour,
+			want:   want{minutes: 1, avg: 3},
+		},
+		{
+			name:   "counter samples are rolled up per minute",
+			metric: counter,
+			after:  2 * time.Hour,
+			want:   want{minutes: 1, avg: 7.5},
+		},
+		{
+			name:   "minute rollups are rolled up per hour",
+			metric: gauge,
+			after:  48 * time.Hour,
+			want:   want{hours: 1, avg: 3},
+		},
+		{
+			name:   "hour rollups are deleted",
+			metric: gauge,
+			after:  30 * 24 * time.Hour,
+			want:   want{err: structs.ErrMetricNotFound},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			store := fillStorage(t)
+			err := store.CompactHistory(context.Background(), policy, time.Now().Add(tc.after))
+			if err != nil {
+				t.Fatalf("CompactHistory() have returned an error: %s", err.Error())
+			}
+
+			samples, err := store.GetMetricHistory(context.Background(), tc.metric, from, to)
+			if err != tc.want.err {
+				t.Fatalf("GetMetricHistory() error mismatch: have %v, want %v", err, tc.want.err)
+			}
+			if len(samples) != tc.want.samples {
+				t.Errorf("samples count mismatch: have %d, want %d", len(samples), tc.want.samples)
+			}
+
+			minutes, err := store.GetMetricRollups(context.Background(), tc.metric, structs.RollupMinute, from, to)
+			if err != tc.want.err {
+				t.Fatalf("GetMetricRollups() error mismatch: have %v, want %v", err, tc.want.err)
+			}
+			if len(minutes) != tc.want.minutes {
+				t.Errorf("minute rollups count mismatch: have %d, want %d", len(minutes), tc.want.minutes)
+			}
+
+			hours, err := store.GetMetricRollups(context.Background(), tc.metric, structs.RollupHour, from, to)
+			if err != tc.want.err {
+				t.Fatalf("GetMetricRollups() error mismatch: have %v, want %v", err, tc.want.err)
+			}
+			if len(hours) != tc.want.hours {
+				t.Errorf("hour rollups count mismatch: have %d, want %d", len(hours), tc.want.hours)
+			}
+
+			for _, r := range append(minutes, hours...) {
+				if r.Avg != tc.want.avg {
+					t.Errorf("rollup avg mismatch: have %f, want %f", r.Avg, tc.want.avg)
+				}
+			}
+		})
+	}
+}
+
+func TestCompactHistoryDisabled(t *testing.T) {
+	t.Run("history is disabled", func(t *testing.T) {
+		err := structs.NewMemoryStorage().CompactHistory(context.Background(), policy, time.Now())
+		if err != structs.ErrHistoryDisabled {
+			t.Errorf("CompactHistory() error mismatch: have %v, want %v", err, structs.ErrHistoryDisabled)
+		}
+	})
+}
+
+func TestStart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	t.Run("testing Start", func(t *testing.T) {
+		Start(ctx, &wg, time.Second, policy, fillStorage(t))
+	})
+}
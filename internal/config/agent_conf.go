@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -14,6 +15,12 @@ func GetAgentConfig(args []string) AgentConfig {
 
 	f := flag.NewFlagSet("agent", flag.ExitOnError)
 	var addressF, reportF, pollF, keyF, publicKeyPathF, configPathF, gAddressF string
+	var batchSizeF, retryAttemptsF int
+	var retryBackoffF, retryMaxBackoffF string
+	var spoolDirF, spoolMaxAgeF string
+	var spoolMaxSizeF int64
+	var tlsCertF, tlsKeyF, tlsCAF string
+	var instanceF string
 	f.StringVar(&addressF, "a", "",
 		fmt.Sprintf("server`s socket (default: %s)", serverAddressDefault))
 	f.StringVar(&reportF, "r", "",
@@ -26,6 +33,25 @@ func GetAgentConfig(args []string) AgentConfig {
 	f.StringVar(&configPathF, "c", "", "configuration file to use")
 	f.StringVar(&gAddressF, "g", "",
 		"server`s gRPC socket (if not set, metrics will be sent via REST)")
+	f.IntVar(&batchSizeF, "b", 0,
+		fmt.Sprintf("max number of metrics sent in one batch request (default: %d)", batchSizeDefault))
+	f.IntVar(&retryAttemptsF, "retry-attempts", 0,
+		fmt.Sprintf("max number of attempts to send metrics (default: %d)", retryAttemptsDefault))
+	f.StringVar(&retryBackoffF, "retry-backoff", "",
+		fmt.Sprintf("delay before the first retry, doubled on each next one (default: %s)", retryBackoffDefault))
+	f.StringVar(&retryMaxBackoffF, "retry-max-backoff", "",
+		fmt.Sprintf("max delay between retries (default: %s)", retryMaxBackoffDefault))
+	f.StringVar(&spoolDirF, "spool-dir", "",
+		"directory to keep unsent metrics in (if not set, unsent metrics are kept in memory only)")
+	f.Int64Var(&spoolMaxSizeF, "spool-max-size", 0,
+		fmt.Sprintf("max size of the spool in bytes (default: %d)", spoolMaxSizeDefault))
+	f.StringVar(&spoolMaxAgeF, "spool-max-age", "",
+		fmt.Sprintf("max age of spooled metrics (default: %s)", spoolMaxAgeDefault))
+	f.StringVar(&tlsCAF, "tls-ca", "",
+		"CA to verify server certificate with (if set or client certificate is set, TLS is used)")
+	f.StringVar(&tlsCertF, "tls-cert", "", "client TLS certificate (for servers requiring mTLS)")
+	f.StringVar(&tlsKeyF, "tls-key", "", "client TLS private key")
+	f.StringVar(&instanceF, "instance", "", "agent instance name reported with metrics (default: hostname)")
 	f.Parse(args)
 
 	pollEnv := os.Getenv("POLL_INTERVAL")
@@ -35,6 +61,17 @@ func GetAgentConfig(args []string) AgentConfig {
 	publicKeyPathEnv := os.Getenv("CRYPTO_KEY")
 	configPathEnv := os.Getenv("CONFIG")
 	gAddressEnv := os.Getenv("GRPC_ADDRESS")
+	batchSizeEnv := os.Getenv("BATCH_SIZE")
+	retryAttemptsEnv := os.Getenv("RETRY_ATTEMPTS")
+	retryBackoffEnv := os.Getenv("RETRY_BACKOFF")
+	retryMaxBackoffEnv := os.Getenv("RETRY_MAX_BACKOFF")
+	spoolDirEnv := os.Getenv("SPOOL_DIR")
+	spoolMaxSizeEnv := os.Getenv("SPOOL_MAX_SIZE")
+	spoolMaxAgeEnv := os.Getenv("SPOOL_MAX_AGE")
+	tlsCertEnv := os.Getenv("TLS_CERT")
+	tlsKeyEnv := os.Getenv("TLS_KEY")
+	tlsCAEnv := os.Getenv("TLS_CA")
+	instanceEnv := os.Getenv("INSTANCE")
 
 	// checking config file
 	var configJSON AgentConfigJSON
@@ -154,5 +191,88 @@ func GetAgentConfig(args []string) AgentConfig {
 		config.GRPCAddress = configJSON.GRPCAddress
 	}
 
+	// batch size
+	if batchSizeEnv != "" {
+		batchSize, err := strconv.Atoi(batchSizeEnv)
+		if err != nil || batchSize <= 0 {
+			log.Printf("WARN failed to parse batch size from 'BATCH_SIZE' "+
+				"enviroment variable (%s). Default value (%d) will be used", batchSizeEnv, batchSizeDefault)
+			config.BatchSize = batchSizeDefault
+		} else {
+			config.BatchSize = batchSize
+		}
+	} else if batchSizeF > 0 {
+		config.BatchSize = batchSizeF
+	} else if configJSON.BatchSize > 0 {
+		config.BatchSize = configJSON.BatchSize
+	} else {
+		config.BatchSize = batchSizeDefault
+	}
+
+	// retry attempts
+	if retryAttemptsEnv != "" {
+		retryAttempts, err := strconv.Atoi(retryAttemptsEnv)
+		if err != nil || retryAttempts <= 0 {
+			log.Printf("WARN failed to parse retry attempts from 'RETRY_ATTEMPTS' "+
+				"enviroment variable (%s). Default value (%d) will be used", retryAttemptsEnv, retryAttemptsDefault)
+			config.RetryAttempts = retryAttemptsDefault
+		} else {
+			config.RetryAttempts = retryAttempts
+		}
+	} else if retryAttemptsF > 0 {
+		config.RetryAttempts = retryAttemptsF
+	} else if configJSON.RetryAttempts > 0 {
+		config.RetryAttempts = configJSON.RetryAttempts
+	} else {
+		config.RetryAttempts = retryAttemptsDefault
+	}
+
+	// retry backoff
+	config.RetryBackoff = getDuration("RETRY_BACKOFF", retryBackoffEnv,
+		"retry-backoff", retryBackoffF,
+		"retry_backoff", configJSON.RetryBackoff, retryBackoffDefault)
+	config.RetryMaxBackoff = getDuration("RETRY_MAX_BACKOFF", retryMaxBackoffEnv,
+		"retry-max-backoff", retryMaxBackoffF,
+		"retry_max_backoff", configJSON.RetryMaxBackoff, retryMaxBackoffDefault)
+
+	// spool
+	if spoolDirEnv != "" {
+		config.SpoolDir = spoolDirEnv
+	} else if spoolDirF != "" {
+		config.SpoolDir = spoolDirF
+	} else {
+		config.SpoolDir = configJSON.SpoolDir
+	}
+
+	if spoolMaxSizeEnv != "" {
+		spoolMaxSize, err := strconv.ParseInt(spoolMaxSizeEnv, 10, 64)
+		if err != nil || spoolMaxSize <= 0 {
+			log.Printf("WARN failed to parse spool max size from 'SPOOL_MAX_SIZE' "+
+				"enviroment variable (%s). Default value (%d) will be used", spoolMaxSizeEnv, spoolMaxSizeDefault)
+			config.SpoolMaxSize = spoolMaxSizeDefault
+		} else {
+			config.SpoolMaxSize = spoolMaxSize
+		}
+	} else if spoolMaxSizeF > 0 {
+		config.SpoolMaxSize = spoolMaxSizeF
+	} else if configJSON.SpoolMaxSize > 0 {
+		config.SpoolMaxSize = configJSON.SpoolMaxSize
+	} else {
+		config.SpoolMaxSize = spoolMaxSizeDefault
+	}
+
+	config.SpoolMaxAge = getDuration("SPOOL_MAX_AGE", spoolMaxAgeEnv,
+		"spool-max-age", spoolMaxAgeF,
+		"spool_max_age", configJSON.SpoolMaxAge, spoolMaxAgeDefault)
+
+	// TLS
+	config.TLSCert = getString(tlsCertEnv, tlsCertF, configJSON.TLSCert, "")
+	config.TLSKey = getString(tlsKeyEnv, tlsKeyF, configJSON.TLSKey, "")
+	config.TLSCA = getString(tlsCAEnv, tlsCAF, configJSON.TLSCA, "")
+
+	// identity
+	config.Instance = getString(instanceEnv, instanceF, configJSON.Instance, "")
+	config.Labels = configJSON.Labels
+
 	return config
 }
@@ -53,6 +53,84 @@ func compareServerConfig(have ServerConfig, want ServerConfig) string {
 			fmt.Sprintf("GRPCAddress have:%s want:%s",
 				have.GRPCAddress, want.GRPCAddress))
 	}
+
+	if have.KeepHistory != want.KeepHistory {
+		mismatch = append(mismatch,
+			fmt.Sprintf("KeepHistory have:%t want:%t",
+				have.KeepHistory, want.KeepHistory))
+	}
+
+	if have.RetentionRaw != want.RetentionRaw ||
+		have.RetentionRollup != want.RetentionRollup ||
+		have.RetentionRollupHour != want.RetentionRollupHour {
+		mismatch = append(mismatch,
+			fmt.Sprintf("Retention have:%s/%s/%s want:%s/%s/%s",
+				have.RetentionRaw, have.RetentionRollup, have.RetentionRollupHour,
+				want.RetentionRaw, want.RetentionRollup, want.RetentionRollupHour))
+	}
+
+	if have.CompactInterval != want.CompactInterval {
+		mismatch = append(mismatch,
+			fmt.Sprintf("CompactInterval have:%s want:%s",
+				have.CompactInterval, want.CompactInterval))
+	}
+
+	if have.TLSCert != want.TLSCert || have.TLSKey != want.TLSKey || have.TLSClientCA != want.TLSClientCA {
+		mismatch = append(mismatch,
+			fmt.Sprintf("TLS have:%s/%s/%s want:%s/%s/%s",
+				have.TLSCert, have.TLSKey, have.TLSClientCA,
+				want.TLSCert, want.TLSKey, want.TLSClientCA))
+	}
+
+	if have.StatsDAddress != want.StatsDAddress || have.StatsDFlushInterval != want.StatsDFlushInterval {
+		mismatch = append(mismatch,
+			fmt.Sprintf("StatsD have:%s/%s want:%s/%s",
+				have.StatsDAddress, have.StatsDFlushInterval,
+				want.StatsDAddress, want.StatsDFlushInterval))
+	}
+
+	if !reflect.DeepEqual(have.InfluxCounters, want.InfluxCounters) {
+		mismatch = append(mismatch,
+			fmt.Sprintf("InfluxCounters have:%v want:%v", have.InfluxCounters, want.InfluxCounters))
+	}
+
+	if have.GraphiteAddress != want.GraphiteAddress ||
+		!reflect.DeepEqual(have.GraphiteCounters, want.GraphiteCounters) {
+		mismatch = append(mismatch,
+			fmt.Sprintf("Graphite have:%s/%v want:%s/%v",
+				have.GraphiteAddress, have.GraphiteCounters,
+				want.GraphiteAddress, want.GraphiteCounters))
+	}
+
+	if have.AgentTimeout != want.AgentTimeout {
+		mismatch = append(mismatch,
+			fmt.Sprintf("AgentTimeout have:%s want:%s", have.AgentTimeout, want.AgentTimeout))
+	}
+
+	if have.UseDB != want.UseDB || have.StorageBackend != want.StorageBackend || have.StoragePath != want.StoragePath {
+		mismatch = append(mismatch,
+			fmt.Sprintf("Storage have:%t/%s/%s want:%t/%s/%s",
+				have.UseDB, have.StorageBackend, have.StoragePath,
+				want.UseDB, want.StorageBackend, want.StoragePath))
+	}
+
+	if have.QueryTimeout != want.QueryTimeout {
+		mismatch = append(mismatch,
+			fmt.Sprintf("QueryTimeout have:%s want:%s", have.QueryTimeout, want.QueryTimeout))
+	}
+
+	if have.WALFile != want.WALFile || have.WALSync != want.WALSync || have.WALSyncInterval != want.WALSyncInterval {
+		mismatch = append(mismatch,
+			fmt.Sprintf("WAL have:%s/%s/%s want:%s/%s/%s",
+				have.WALFile, have.WALSync, have.WALSyncInterval,
+				want.WALFile, want.WALSync, want.WALSyncInterval))
+	}
+
+	if have.StoreGenerations != want.StoreGenerations || have.StoreCompress != want.StoreCompress {
+		mismatch = append(mismatch,
+			fmt.Sprintf("Store file have:%d/%t want:%d/%t",
+				have.StoreGenerations, have.StoreCompress, want.StoreGenerations, want.StoreCompress))
+	}
 	return strings.Join(mismatch, ";")
 }
 
@@ -65,6 +143,7 @@ var testServerJSON = ServerConfigJSON{
 	StoreFile:      "/tmp/test.json",
 	TrustedSubnet:  "192.168.23.0/24",
 	GRPCAddress:    "1.1.1.1:5429",
+	RetentionRaw:   "1h",
 }
 
 var testAgentConfig = AgentConfigJSON{
@@ -74,6 +153,11 @@ var testAgentConfig = AgentConfigJSON{
 	PublicKeyPath:  "/tmp/test/public.pem",
 	Key:            "test_hash",
 	GRPCAddress:    "1.1.1.1:5429",
+	BatchSize:      10,
+	RetryAttempts:  5,
+	RetryBackoff:   "2s",
+	Instance:       "web-1",
+	Labels:         map[string]string{"env": "prod", "dc": "eu-1"},
 }
 
 // creating json file
@@ -111,26 +195,42 @@ func TestGetAgentConfig(t *testing.T) {
 	}{
 		{name: "no flags", args: []string{},
 			want: AgentConfig{ServerAddress: serverAddressDefault,
-				PollInterval: pollIntervalDefault, ReportInterval: reportIntervalDefault}},
+				PollInterval: pollIntervalDefault, ReportInterval: reportIntervalDefault,
+				BatchSize: batchSizeDefault, RetryAttempts: retryAttemptsDefault,
+				RetryBackoff: retryBackoffDefault, RetryMaxBackoff: retryMaxBackoffDefault,
+				SpoolMaxSize: spoolMaxSizeDefault, SpoolMaxAge: spoolMaxAgeDefault}},
 		{name: "all flags", args: []string{"-a", "test_socket", "-c", "test_file.json",
 			"-crypto-key", "test.pem", "-k", "test_hash", "-p", "5s", "-r", "20s",
-			"-g", "1.1.1.1:5429"},
+			"-g", "1.1.1.1:5429", "-b", "20", "-retry-attempts", "4",
+			"-retry-backoff", "100ms", "-retry-max-backoff", "1s",
+			"-spool-dir", "/tmp/spool", "-spool-max-size", "1024", "-spool-max-age", "1h",
+			"-tls-ca", "ca.pem", "-tls-cert", "agent.pem", "-tls-key", "agent-key.pem",
+			"-instance", "web-2"},
 			want: AgentConfig{ServerAddress: "test_socket", Key: "test_hash",
 				PollInterval: time.Second * 5, ReportInterval: time.Second * 20,
-				PublicKeyPath: "test.pem", GRPCAddress: "1.1.1.1:5429"}},
+				PublicKeyPath: "test.pem", GRPCAddress: "1.1.1.1:5429", BatchSize: 20,
+				RetryAttempts: 4, RetryBackoff: 100 * time.Millisecond, RetryMaxBackoff: time.Second,
+				SpoolDir: "/tmp/spool", SpoolMaxSize: 1024, SpoolMaxAge: time.Hour,
+				TLSCA: "ca.pem", TLSCert: "agent.pem", TLSKey: "agent-key.pem", Instance: "web-2"}},
 		{name: "read from file", args: []string{"-c", fname},
 			want: AgentConfig{ServerAddress: tconf.ServerAddress,
 				Key: tconf.Key, PollInterval: tconfPollInterval,
 				ReportInterval: tconfReportInterval, PublicKeyPath: tconf.PublicKeyPath,
-				GRPCAddress: tconf.GRPCAddress}},
+				GRPCAddress: tconf.GRPCAddress, BatchSize: tconf.BatchSize,
+				RetryAttempts: tconf.RetryAttempts, RetryBackoff: 2 * time.Second,
+				RetryMaxBackoff: retryMaxBackoffDefault, SpoolMaxSize: spoolMaxSizeDefault,
+				SpoolMaxAge: spoolMaxAgeDefault, Instance: tconf.Instance, Labels: tconf.Labels}},
 		{name: "bad duration", args: []string{"-p", "bad", "-r", "bad"},
 			want: AgentConfig{ServerAddress: serverAddressDefault,
-				PollInterval: pollIntervalDefault, ReportInterval: reportIntervalDefault}},
+				PollInterval: pollIntervalDefault, ReportInterval: reportIntervalDefault,
+				BatchSize: batchSizeDefault, RetryAttempts: retryAttemptsDefault,
+				RetryBackoff: retryBackoffDefault, RetryMaxBackoff: retryMaxBackoffDefault,
+				SpoolMaxSize: spoolMaxSizeDefault, SpoolMaxAge: spoolMaxAgeDefault}},
 	}
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
 			res := GetAgentConfig(tc.args)
-			if res != tc.want {
+			if !reflect.DeepEqual(res, tc.want) {
 				t.Errorf("AgentConfig mismatch: have: %v,  want: %v", res, tc.want)
 			}
 		})
@@ -141,7 +241,11 @@ func TestGetAgentConfig(t *testing.T) {
 func TestAgentConfigEnv(t *testing.T) {
 	want := AgentConfig{PollInterval: time.Second * 25,
 		ReportInterval: time.Second * 14, ServerAddress: "test_serv",
-		Key: "test_hash", PublicKeyPath: "public.pem", GRPCAddress: "1.1.1.1:1244"}
+		Key: "test_hash", PublicKeyPath: "public.pem", GRPCAddress: "1.1.1.1:1244",
+		BatchSize: 15, RetryAttempts: 2, RetryBackoff: time.Second * 3,
+		RetryMaxBackoff: time.Second * 30, SpoolDir: "/var/spool/agent",
+		SpoolMaxSize: 2048, SpoolMaxAge: time.Hour * 2, TLSCA: "ca.pem",
+		TLSCert: "agent.pem", TLSKey: "agent-key.pem", Instance: "web-3"}
 	t.Run("Get agent config with env variables", func(t *testing.T) {
 		t.Setenv("POLL_INTERVAL", want.PollInterval.String())
 		t.Setenv("REPORT_INTERVAL", want.ReportInterval.String())
@@ -150,8 +254,19 @@ func TestAgentConfigEnv(t *testing.T) {
 		t.Setenv("CRYPTO_KEY", want.PublicKeyPath)
 		t.Setenv("CONFIG", "test.json")
 		t.Setenv("GRPC_ADDRESS", want.GRPCAddress)
+		t.Setenv("BATCH_SIZE", "15")
+		t.Setenv("RETRY_ATTEMPTS", "2")
+		t.Setenv("RETRY_BACKOFF", "3s")
+		t.Setenv("RETRY_MAX_BACKOFF", "30s")
+		t.Setenv("SPOOL_DIR", want.SpoolDir)
+		t.Setenv("SPOOL_MAX_SIZE", "2048")
+		t.Setenv("SPOOL_MAX_AGE", "2h")
+		t.Setenv("TLS_CA", want.TLSCA)
+		t.Setenv("TLS_CERT", want.TLSCert)
+		t.Setenv("TLS_KEY", want.TLSKey)
+		t.Setenv("INSTANCE", want.Instance)
 		res := GetAgentConfig([]string{})
-		if res != want {
+		if !reflect.DeepEqual(res, want) {
 			t.Errorf("AgentConfig mismatch: have: %v,  want: %v", res, want)
 		}
 	})
@@ -171,22 +286,148 @@ func TestGetServerConfigFlags(t *testing.T) {
 		{name: "no flags", args: []string{},
 			want: ServerConfig{ServerAddress: serverAddressDefault,
 				StoreFile: storeFileDefault, StoreInterval: storeIntervalDefault,
-				Restore:       false,
-				TrustedSubnet: trunstedSubnetDefault,
-				GRPCAddress:   gAddressDefault}},
+				Restore:             false,
+				TrustedSubnet:       trunstedSubnetDefault,
+				GRPCAddress:         gAddressDefault,
+				RetentionRaw:        retentionRawDefault,
+				RetentionRollup:     retentionRollupDefault,
+				RetentionRollupHour: retentionRollupHourDefault,
+				CompactInterval:     compactIntervalDefault,
+				StatsDFlushInterval: statsdFlushIntervalDefault,
+				AgentTimeout:        agentTimeoutDefault,
+				StorageBackend:      StorageMemory,
+				StoragePath:         storagePathDefault,
+				WALFile:             walFileDefault,
+				WALSync:             walSyncDefault,
+				WALSyncInterval:     walSyncIntervalDefault,
+				StoreGenerations:    storeGenerationsDefault,
+				QueryTimeout:        queryTimeoutDefault}},
+		{name: "zero compact interval", args: []string{"-compact-interval", "0s"},
+			want: ServerConfig{ServerAddress: serverAddressDefault,
+				StoreFile: storeFileDefault, StoreInterval: storeIntervalDefault,
+				Restore:             false,
+				TrustedSubnet:       trunstedSubnetDefault,
+				GRPCAddress:         gAddressDefault,
+				RetentionRaw:        retentionRawDefault,
+				RetentionRollup:     retentionRollupDefault,
+				RetentionRollupHour: retentionRollupHourDefault,
+				CompactInterval:     compactIntervalDefault,
+				StatsDFlushInterval: statsdFlushIntervalDefault,
+				AgentTimeout:        agentTimeoutDefault,
+				StorageBackend:      StorageMemory,
+				StoragePath:         storagePathDefault,
+				WALFile:             walFileDefault,
+				WALSync:             walSyncDefault,
+				WALSyncInterval:     walSyncIntervalDefault,
+				StoreGenerations:    storeGenerationsDefault,
+				QueryTimeout:        queryTimeoutDefault}},
+		{name: "negative compact interval", args: []string{"-compact-interval", "-1m"},
+			want: ServerConfig{ServerAddress: serverAddressDefault,
+				StoreFile: storeFileDefault, StoreInterval: storeIntervalDefault,
+				Restore:             false,
+				TrustedSubnet:       trunstedSubnetDefault,
+				GRPCAddress:         gAddressDefault,
+				RetentionRaw:        retentionRawDefault,
+				RetentionRollup:     retentionRollupDefault,
+				RetentionRollupHour: retentionRollupHourDefault,
+				CompactInterval:     compactIntervalDefault,
+				StatsDFlushInterval: statsdFlushIntervalDefault,
+				AgentTimeout:        agentTimeoutDefault,
+				StorageBackend:      StorageMemory,
+				StoragePath:         storagePathDefault,
+				WALFile:             walFileDefault,
+				WALSync:             walSyncDefault,
+				WALSyncInterval:     walSyncIntervalDefault,
+				StoreGenerations:    storeGenerationsDefault,
+				QueryTimeout:        queryTimeoutDefault}},
+		{name: "zero statsd flush interval", args: []string{"-statsd-flush-interval", "0s"},
+			want: ServerConfig{ServerAddress: serverAddressDefault,
+				StoreFile: storeFileDefault, StoreInterval: storeIntervalDefault,
+				Restore:             false,
+				TrustedSubnet:       trunstedSubnetDefault,
+				GRPCAddress:         gAddressDefault,
+				RetentionRaw:        retentionRawDefault,
+				RetentionRollup:     retentionRollupDefault,
+				RetentionRollupHour: retentionRollupHourDefault,
+				CompactInterval:     compactIntervalDefault,
+				StatsDFlushInterval: statsdFlushIntervalDefault,
+				AgentTimeout:        agentTimeoutDefault,
+				StorageBackend:      StorageMemory,
+				StoragePath:         storagePathDefault,
+				WALFile:             walFileDefault,
+				WALSync:             walSyncDefault,
+				WALSyncInterval:     walSyncIntervalDefault,
+				StoreGenerations:    storeGenerationsDefault,
+				QueryTimeout:        queryTimeoutDefault}},
+		{name: "zero wal sync interval", args: []string{"-wal-sync", "interval", "-wal-sync-interval", "0s"},
+			want: ServerConfig{ServerAddress: serverAddressDefault,
+				StoreFile: storeFileDefault, StoreInterval: storeIntervalDefault,
+				Restore:             false,
+				TrustedSubnet:       trunstedSubnetDefault,
+				GRPCAddress:         gAddressDefault,
+				RetentionRaw:        retentionRawDefault,
+				RetentionRollup:     retentionRollupDefault,
+				RetentionRollupHour: retentionRollupHourDefault,
+				CompactInterval:     compactIntervalDefault,
+				StatsDFlushInterval: statsdFlushIntervalDefault,
+				AgentTimeout:        agentTimeoutDefault,
+				StorageBackend:      StorageMemory,
+				StoragePath:         storagePathDefault,
+				WALFile:             walFileDefault,
+				WALSync:             WALSyncInterval,
+				WALSyncInterval:     walSyncIntervalDefault,
+				StoreGenerations:    storeGenerationsDefault,
+				QueryTimeout:        queryTimeoutDefault}},
+		{name: "wal off", args: []string{"-wal-sync", "off"},
+			want: ServerConfig{ServerAddress: serverAddressDefault,
+				StoreFile: storeFileDefault, StoreInterval: storeIntervalDefault,
+				Restore:             false,
+				TrustedSubnet:       trunstedSubnetDefault,
+				GRPCAddress:         gAddressDefault,
+				RetentionRaw:        retentionRawDefault,
+				RetentionRollup:     retentionRollupDefault,
+				RetentionRollupHour: retentionRollupHourDefault,
+				CompactInterval:     compactIntervalDefault,
+				StatsDFlushInterval: statsdFlushIntervalDefault,
+				AgentTimeout:        agentTimeoutDefault,
+				StorageBackend:      StorageMemory,
+				StoragePath:         storagePathDefault,
+				WALFile:             walFileDefault,
+				WALSync:             WALSyncOff,
+				WALSyncInterval:     walSyncIntervalDefault,
+				StoreGenerations:    storeGenerationsDefault,
+				QueryTimeout:        queryTimeoutDefault}},
 		{name: "all flags", args: []string{
 			"-a", "server", "-c", "config.json", "-f", "/tmp/test.json",
 			"-k", "hash",
 			"-d", "postgress//test:5432/tesd_db",
 			"-i", "1s", "-r", "-crypto-key", "private.pem",
-			"-t", "192.168.23.0/24", "-g", "1.1.1.1:5429"},
+			"-t", "192.168.23.0/24", "-g", "1.1.1.1:5429",
+			"-keep-history", "-retention-raw", "2h", "-retention-rollup", "48h",
+			"-retention-rollup-hour", "96h", "-compact-interval", "30s",
+			"-tls-cert", "server.pem", "-tls-key", "server-key.pem", "-tls-client-ca", "ca.pem",
+			"-statsd-address", ":8125", "-statsd-flush-interval", "5s",
+			"-influx-counters", "net_bytes_*, http_requests",
+			"-graphite-address", ":2003", "-graphite-counters", "*.requests,",
+			"-agent-timeout", "5m", "-storage-backend", "bolt", "-storage-path", "/var/lib/metrics.db",
+			"-wal-file", "/var/lib/metrics.wal", "-wal-sync", "always", "-wal-sync-interval", "5s",
+			"-store-generations", "5", "-store-compress", "-query-timeout", "0s"},
 			want: ServerConfig{
 				ServerAddress: "server", Key: "hash", DSN: "postgress//test:5432/tesd_db",
 				StoreFile: "/tmp/test.json", StoreInterval: time.Second,
-				Restore: true, UseDB: true, PrivateKeyPath: "private.pem",
+				Restore: true, PrivateKeyPath: "private.pem",
 				TrustedSubnet: net.IPNet{IP: net.IPv4(192, 168, 23, 0),
 					Mask: net.IPv4Mask(255, 255, 255, 0)},
-				GRPCAddress: "1.1.1.1:5429"},
+				GRPCAddress: "1.1.1.1:5429", KeepHistory: true,
+				RetentionRaw: 2 * time.Hour, RetentionRollup: 48 * time.Hour,
+				RetentionRollupHour: 96 * time.Hour, CompactInterval: 30 * time.Second,
+				TLSCert: "server.pem", TLSKey: "server-key.pem", TLSClientCA: "ca.pem",
+				StatsDAddress: ":8125", StatsDFlushInterval: 5 * time.Second,
+				InfluxCounters:  []string{"net_bytes_*", "http_requests"},
+				GraphiteAddress: ":2003", GraphiteCounters: []string{"*.requests"},
+				AgentTimeout: 5 * time.Minute, StorageBackend: StorageBolt, StoragePath: "/var/lib/metrics.db",
+				WALFile: "/var/lib/metrics.wal", WALSync: WALSyncAlways, WALSyncInterval: 5 * time.Second,
+				StoreGenerations: 5, StoreCompress: true, QueryTimeout: 0},
 		},
 		{name: "read from file", args: []string{"-c", fname},
 			want: ServerConfig{ServerAddress: tconf.ServerAddress,
@@ -195,7 +436,55 @@ func TestGetServerConfigFlags(t *testing.T) {
 				Restore: false, UseDB: true, PrivateKeyPath: tconf.PrivateKeyPath,
 				TrustedSubnet: net.IPNet{IP: net.IPv4(192, 168, 23, 0),
 					Mask: net.IPv4Mask(255, 255, 255, 0)},
-				GRPCAddress: tconf.GRPCAddress}},
+				GRPCAddress: tconf.GRPCAddress, RetentionRaw: time.Hour,
+				RetentionRollup:     retentionRollupDefault,
+				RetentionRollupHour: retentionRollupHourDefault,
+				CompactInterval:     compactIntervalDefault,
+				StatsDFlushInterval: statsdFlushIntervalDefault,
+				AgentTimeout:        agentTimeoutDefault,
+				StorageBackend:      StoragePostgres,
+				StoragePath:         storagePathDefault,
+				WALFile:             walFileDefault,
+				WALSync:             walSyncDefault,
+				WALSyncInterval:     walSyncIntervalDefault,
+				StoreGenerations:    storeGenerationsDefault,
+				QueryTimeout:        queryTimeoutDefault}},
+		{name: "postgres backend without DSN", args: []string{"-storage-backend", "postgres"},
+			want: ServerConfig{ServerAddress: serverAddressDefault,
+				StoreFile: storeFileDefault, StoreInterval: storeIntervalDefault,
+				TrustedSubnet:       trunstedSubnetDefault,
+				GRPCAddress:         gAddressDefault,
+				RetentionRaw:        retentionRawDefault,
+				RetentionRollup:     retentionRollupDefault,
+				RetentionRollupHour: retentionRollupHourDefault,
+				CompactInterval:     compactIntervalDefault,
+				StatsDFlushInterval: statsdFlushIntervalDefault,
+				AgentTimeout:        agentTimeoutDefault,
+				StorageBackend:      StorageMemory,
+				StoragePath:         storagePathDefault,
+				WALFile:             walFileDefault,
+				WALSync:             walSyncDefault,
+				WALSyncInterval:     walSyncIntervalDefault,
+				StoreGenerations:    storeGenerationsDefault,
+				QueryTimeout:        queryTimeoutDefault}},
+		{name: "bad retention", args: []string{"-retention-raw", "bad"},
+			want: ServerConfig{ServerAddress: serverAddressDefault,
+				StoreFile: storeFileDefault, StoreInterval: storeIntervalDefault,
+				TrustedSubnet:       trunstedSubnetDefault,
+				GRPCAddress:         gAddressDefault,
+				RetentionRaw:        retentionRawDefault,
+				RetentionRollup:     retentionRollupDefault,
+				RetentionRollupHour: retentionRollupHourDefault,
+				CompactInterval:     compactIntervalDefault,
+				StatsDFlushInterval: statsdFlushIntervalDefault,
+				AgentTimeout:        agentTimeoutDefault,
+				StorageBackend:      StorageMemory,
+				StoragePath:         storagePathDefault,
+				WALFile:             walFileDefault,
+				WALSync:             walSyncDefault,
+				WALSyncInterval:     walSyncIntervalDefault,
+				StoreGenerations:    storeGenerationsDefault,
+				QueryTimeout:        queryTimeoutDefault}},
 	}
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
@@ -221,13 +510,40 @@ func TestGetServerConfigEnv(t *testing.T) {
 		t.Setenv("CONFIG", "test.json")
 		t.Setenv("TRUSTED_SUBNET", "192.168.23.0/24")
 		t.Setenv("GRPC_ADDRESS", "1.1.1.1:1244")
+		t.Setenv("KEEP_HISTORY", "true")
+		t.Setenv("RETENTION_RAW", "3h")
+		t.Setenv("RETENTION_ROLLUP", "12h")
+		t.Setenv("RETENTION_ROLLUP_HOUR", "24h")
+		t.Setenv("COMPACT_INTERVAL", "10s")
+		t.Setenv("STATSD_ADDRESS", "127.0.0.1:8125")
+		t.Setenv("STATSD_FLUSH_INTERVAL", "1m")
+		t.Setenv("INFLUX_COUNTERS", "requests_*")
+		t.Setenv("GRAPHITE_ADDRESS", "127.0.0.1:2003")
+		t.Setenv("GRAPHITE_COUNTERS", "collectd.*.if_octets.*")
+		t.Setenv("AGENT_TIMEOUT", "30s")
+		t.Setenv("STORAGE_BACKEND", "postgres")
+		t.Setenv("STORAGE_PATH", "metrics.db")
+		t.Setenv("WAL_FILE", "metrics.wal")
+		t.Setenv("WAL_SYNC", "bad")
+		t.Setenv("WAL_SYNC_INTERVAL", "100ms")
+		t.Setenv("STORE_GENERATIONS", "0")
+		t.Setenv("STORE_COMPRESS", "true")
+		t.Setenv("QUERY_TIMEOUT", "2s")
 		have := GetServerConfig([]string{})
 		want := ServerConfig{ServerAddress: "testServ", StoreInterval: time.Second,
 			StoreFile: "storeFile", Restore: true, UseDB: true, Key: "test_hash", DSN: "test_dsn",
 			PrivateKeyPath: "private.pem",
 			TrustedSubnet: net.IPNet{IP: net.IPv4(192, 168, 23, 0),
 				Mask: net.IPv4Mask(255, 255, 255, 0)},
-			GRPCAddress: "1.1.1.1:1244"}
+			GRPCAddress: "1.1.1.1:1244", KeepHistory: true,
+			RetentionRaw: 3 * time.Hour, RetentionRollup: 12 * time.Hour,
+			RetentionRollupHour: 24 * time.Hour, CompactInterval: 10 * time.Second,
+			StatsDAddress: "127.0.0.1:8125", StatsDFlushInterval: time.Minute,
+			InfluxCounters:  []string{"requests_*"},
+			GraphiteAddress: "127.0.0.1:2003", GraphiteCounters: []string{"collectd.*.if_octets.*"},
+			AgentTimeout: 30 * time.Second, StorageBackend: StoragePostgres, StoragePath: "metrics.db",
+			WALFile: "metrics.wal", WALSync: walSyncDefault, WALSyncInterval: 100 * time.Millisecond,
+			StoreGenerations: storeGenerationsDefault, StoreCompress: true, QueryTimeout: 2 * time.Second}
 		compareErr := compareServerConfig(have, want)
 		if compareErr != "" {
 			t.Errorf("ServerConfig mismatch: %s", compareErr)
@@ -8,12 +8,48 @@ import (
 const pollIntervalDefault = time.Duration(2 * time.Second)
 const reportIntervalDefault = time.Duration(10 * time.Second)
 const serverAddressDefault = "127.0.0.1:8080"
+const batchSizeDefault = 100
+const retryAttemptsDefault = 3
+const retryBackoffDefault = time.Duration(time.Second)
+const retryMaxBackoffDefault = time.Duration(5 * time.Second)
+const spoolMaxSizeDefault = int64(10 * 1024 * 1024)
+const spoolMaxAgeDefault = time.Duration(24 * time.Hour)
 const storeIntervalDefault = time.Duration(300 * time.Second)
 const storeFileDefault = "/tmp/devops-metrics-db.json"
+const storeGenerationsDefault = 3
+const storeCompressDefault = false
 const restoreDefault = true
 const gAddressDefault = "127.0.0.1:5000"
+const keepHistoryDefault = false
+const retentionRawDefault = time.Duration(6 * time.Hour)
+const retentionRollupDefault = time.Duration(7 * 24 * time.Hour)
+const retentionRollupHourDefault = time.Duration(30 * 24 * time.Hour)
+const compactIntervalDefault = time.Duration(time.Minute)
+const statsdFlushIntervalDefault = time.Duration(10 * time.Second)
+const agentTimeoutDefault = time.Duration(time.Minute)
+const storagePathDefault = "/tmp/devops-metrics.db"
+const queryTimeoutDefault = time.Duration(5 * time.Second)
+const walFileDefault = "/tmp/devops-metrics-db.wal"
+const walSyncDefault = WALSyncInterval
+const walSyncIntervalDefault = time.Duration(time.Second)
 
 var trunstedSubnetDefault = net.IPNet{IP: net.IPv4(0, 0, 0, 0), Mask: net.IPv4Mask(0, 0, 0, 0)}
 
+// Storage backends
+const (
+	StorageMemory   = "memory"
+	StoragePostgres = "postgres"
+	StorageBolt     = "bolt"
+)
+
+// WAL fsync policies: fsync every record, fsync every WALSyncInterval, leave flushing to the OS,
+// do not write WAL at all
+const (
+	WALSyncAlways   = "always"
+	WALSyncInterval = "interval"
+	WALSyncNever    = "never"
+	WALSyncOff      = "off"
+)
+
 // label for Encrypt/Decrypt functions
 const RsaLabel = "metrics"
@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // loadServerConfig загружает конфигурацию сервера из json файла
@@ -53,3 +57,89 @@ func isFlagPassed(name string, fs *flag.FlagSet) bool {
 	})
 	return found
 }
+
+// getDuration возвращает значение интервала из переменной окружения, флага или конфигурационного файла
+// (в порядке убывания приоритета). Если значение не задано или не может быть распознано,
+// возвращается значение по умолчанию
+func getDuration(envName, envValue, flagName, flagValue, attrName, attrValue string,
+	defaultValue time.Duration) time.Duration {
+	var source, value string
+	switch {
+	case envValue != "":
+		source, value = fmt.Sprintf("%s env variable", envName), envValue
+	case flagValue != "":
+		source, value = fmt.Sprintf("'-%s' flag", flagName), flagValue
+	case attrValue != "":
+		source, value = fmt.Sprintf("'%s' configuration attribute", attrName), attrValue
+	default:
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("WARN can`t parse %s (%s): %s. Default value will be used (%s)",
+			source, value, err.Error(), defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// getPositiveDuration возвращает положительное значение интервала (см. getDuration).
+// Нулевые и отрицательные значения заменяются значением по умолчанию
+func getPositiveDuration(envName, envValue, flagName, flagValue, attrName, attrValue string,
+	defaultValue time.Duration) time.Duration {
+	d := getDuration(envName, envValue, flagName, flagValue, attrName, attrValue, defaultValue)
+	if d <= 0 {
+		log.Printf("WARN %s must be positive (%s). Default value will be used (%s)", attrName, d, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// getPositiveInt возвращает целое положительное значение из переменной окружения, флага или
+// конфигурационного файла (в порядке убывания приоритета). Нулевые значения флага и атрибута считаются
+// незаданными. Если значение не задано или не может быть распознано, возвращается значение по умолчанию
+func getPositiveInt(envName, envValue string, flagValue, attrValue, defaultValue int) int {
+	switch {
+	case envValue != "":
+		v, err := strconv.Atoi(envValue)
+		if err != nil || v <= 0 {
+			log.Printf("WARN can`t parse %s env variable (%s): must be positive integer. Default value will be used (%d)",
+				envName, envValue, defaultValue)
+			return defaultValue
+		}
+		return v
+	case flagValue > 0:
+		return flagValue
+	case attrValue > 0:
+		return attrValue
+	default:
+		return defaultValue
+	}
+}
+
+// getString возвращает строковое значение из переменной окружения, флага или конфигурационного файла
+// (в порядке убывания приоритета). Если значение не задано, возвращается значение по умолчанию
+func getString(envValue, flagValue, attrValue, defaultValue string) string {
+	switch {
+	case envValue != "":
+		return envValue
+	case flagValue != "":
+		return flagValue
+	case attrValue != "":
+		return attrValue
+	default:
+		return defaultValue
+	}
+}
+
+// splitList разбивает строку со списком значений, разделенных запятыми.
+// Пробелы вокруг значений и пустые значения отбрасываются
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -6,44 +6,119 @@ import (
 )
 
 type AgentConfig struct {
-	ServerAddress  string
-	PollInterval   time.Duration
-	ReportInterval time.Duration
-	Key            string
-	PublicKeyPath  string
-	GRPCAddress    string
+	ServerAddress   string
+	PollInterval    time.Duration
+	ReportInterval  time.Duration
+	Key             string
+	PublicKeyPath   string
+	GRPCAddress     string
+	BatchSize       int
+	RetryAttempts   int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	SpoolDir        string
+	SpoolMaxSize    int64
+	SpoolMaxAge     time.Duration
+	TLSCert         string
+	TLSKey          string
+	TLSCA           string
+	// Instance identifies the agent on the server (hostname is used if not set)
+	Instance string
+	// Labels are static labels attached to every reported metric
+	Labels map[string]string
 }
 
 type AgentConfigJSON struct {
-	ServerAddress  string `json:"address,omitempty"`
-	PollInterval   string `json:"poll_interval,omitempty"`
-	ReportInterval string `json:"report_interval,omitempty"`
-	PublicKeyPath  string `json:"crypto_key,omitempty"`
-	Key            string `json:"hash_key,omitempty"`
-	GRPCAddress    string `json:"grpc_address,omitempty"`
+	ServerAddress   string            `json:"address,omitempty"`
+	PollInterval    string            `json:"poll_interval,omitempty"`
+	ReportInterval  string            `json:"report_interval,omitempty"`
+	PublicKeyPath   string            `json:"crypto_key,omitempty"`
+	Key             string            `json:"hash_key,omitempty"`
+	GRPCAddress     string            `json:"grpc_address,omitempty"`
+	BatchSize       int               `json:"batch_size,omitempty"`
+	RetryAttempts   int               `json:"retry_attempts,omitempty"`
+	RetryBackoff    string            `json:"retry_backoff,omitempty"`
+	RetryMaxBackoff string            `json:"retry_max_backoff,omitempty"`
+	SpoolDir        string            `json:"spool_dir,omitempty"`
+	SpoolMaxSize    int64             `json:"spool_max_size,omitempty"`
+	SpoolMaxAge     string            `json:"spool_max_age,omitempty"`
+	TLSCert         string            `json:"tls_cert,omitempty"`
+	TLSKey          string            `json:"tls_key,omitempty"`
+	TLSCA           string            `json:"tls_ca,omitempty"`
+	Instance        string            `json:"instance,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
 }
 
 type ServerConfig struct {
-	ServerAddress  string
-	StoreFile      string
-	Key            string
-	DSN            string
-	StoreInterval  time.Duration
-	UseDB          bool
-	Restore        bool
-	PrivateKeyPath string
-	TrustedSubnet  net.IPNet
-	GRPCAddress    string
+	ServerAddress       string
+	StoreFile           string
+	StoreGenerations    int
+	StoreCompress       bool
+	Key                 string
+	DSN                 string
+	StoreInterval       time.Duration
+	UseDB               bool
+	Restore             bool
+	PrivateKeyPath      string
+	TrustedSubnet       net.IPNet
+	GRPCAddress         string
+	KeepHistory         bool
+	RetentionRaw        time.Duration
+	RetentionRollup     time.Duration
+	RetentionRollupHour time.Duration
+	CompactInterval     time.Duration
+	TLSCert             string
+	TLSKey              string
+	TLSClientCA         string
+	StatsDAddress       string
+	StatsDFlushInterval time.Duration
+	InfluxCounters      []string
+	GraphiteAddress     string
+	GraphiteCounters    []string
+	AgentTimeout        time.Duration
+	StorageBackend      string
+	StoragePath         string
+	QueryTimeout        time.Duration
+	WALFile             string
+	WALSync             string
+	WALSyncInterval     time.Duration
 }
 
 type ServerConfigJSON struct {
-	ServerAddress  string `json:"address,omitempty"`
-	StoreFile      string `json:"store_file,omitempty"`
-	Key            string `json:"hash_key,omitempty"`
-	DSN            string `json:"database_dsn,omitempty"`
-	StoreInterval  string `json:"store_interval,omitempty"`
-	Restore        *bool  `json:"restore,omitempty"`
-	PrivateKeyPath string `json:"crypto_key,omitempty"`
-	TrustedSubnet  string `json:"trusted_subnet,omitempty"`
-	GRPCAddress    string `json:"grpc_address,omitempty"`
+	ServerAddress       string `json:"address,omitempty"`
+	StoreFile           string `json:"store_file,omitempty"`
+	StoreGenerations    int    `json:"store_generations,omitempty"`
+	StoreCompress       *bool  `json:"store_compress,omitempty"`
+	Key                 string `json:"hash_key,omitempty"`
+	DSN                 string `json:"database_dsn,omitempty"`
+	StoreInterval       string `json:"store_interval,omitempty"`
+	Restore             *bool  `json:"restore,omitempty"`
+	PrivateKeyPath      string `json:"crypto_key,omitempty"`
+	TrustedSubnet       string `json:"trusted_subnet,omitempty"`
+	GRPCAddress         string `json:"grpc_address,omitempty"`
+	KeepHistory         *bool  `json:"keep_history,omitempty"`
+	RetentionRaw        string `json:"retention_raw,omitempty"`
+	RetentionRollup     string `json:"retention_rollup,omitempty"`
+	RetentionRollupHour string `json:"retention_rollup_hour,omitempty"`
+	CompactInterval     string `json:"compact_interval,omitempty"`
+	TLSCert             string `json:"tls_cert,omitempty"`
+	TLSKey              string `json:"tls_key,omitempty"`
+	TLSClientCA         string `json:"tls_client_ca,omitempty"`
+	StatsDAddress       string `json:"statsd_address,omitempty"`
+	StatsDFlushInterval string `json:"statsd_flush_interval,omitempty"`
+	InfluxCounters      string `json:"influx_counters,omitempty"`
+	GraphiteAddress     string `json:"graphite_address,omitempty"`
+	GraphiteCounters    string `json:"graphite_counters,omitempty"`
+	AgentTimeout        string `json:"agent_timeout,omitempty"`
+	StorageBackend      string `json:"storage_backend,omitempty"`
+	StoragePath         string `json:"storage_path,omitempty"`
+	QueryTimeout        string `json:"query_timeout,omitempty"`
+	WALFile             string `json:"wal_file,omitempty"`
+	WALSync             string `json:"wal_sync,omitempty"`
+	WALSyncInterval     string `json:"wal_sync_interval,omitempty"`
+}
+
+// UseTLS reports whether agent should connect to the server over TLS
+func (c AgentConfig) UseTLS() bool {
+	return c.TLSCA != "" || c.TLSCert != ""
 }
@@ -3,19 +3,131 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/zklevsha/go-musthave-devops/internal/structs"
 )
 
+const counterSQL = `INSERT INTO counters (metric_id, labels_key, labels, metric_value)
+			VALUES($1, $2, $3::jsonb, $4)
+			ON CONFLICT (metric_id, labels_key)
+			DO
+				UPDATE SET metric_value = counters.metric_value + $4
+				WHERE counters.metric_id = $1 AND counters.labels_key = $2;`
+
+const counterHistorySQL = `WITH c AS (
+				INSERT INTO counters (metric_id, labels_key, labels, metric_value)
+				VALUES($1, $2, $3::jsonb, $4)
+				ON CONFLICT (metric_id, labels_key)
+				DO
+					UPDATE SET metric_value = counters.metric_value + $4
+					WHERE counters.metric_id = $1 AND counters.labels_key = $2
+				RETURNING metric_id, labels_key, metric_value)
+			INSERT INTO counters_history (metric_id, labels_key, metric_value, created_at)
+			SELECT metric_id, labels_key, metric_value, now() FROM c;`
+
+const gaugeSQL = `INSERT INTO gauges (metric_id, labels_key, labels, metric_value)
+			VALUES($1, $2, $3::jsonb, $4)
+			ON CONFLICT (metric_id, labels_key)
+			DO
+				UPDATE SET metric_value = $4 WHERE gauges.metric_id = $1 AND gauges.labels_key = $2;`
+
+const gaugeHistorySQL = `WITH g AS (
+				INSERT INTO gauges (metric_id, labels_key, labels, metric_value)
+				VALUES($1, $2, $3::jsonb, $4)
+				ON CONFLICT (metric_id, labels_key)
+				DO
+					UPDATE SET metric_value = $4 WHERE gauges.metric_id = $1 AND gauges.labels_key = $2
+				RETURNING metric_id, labels_key, metric_value)
+			INSERT INTO gauges_history (metric_id, labels_key, metric_value, created_at)
+			SELECT metric_id, labels_key, metric_value, now() FROM g;`
+
+// labelsSQL adds labels columns to tables created before metrics had labels
+// and moves primary key to (metric_id, labels_key)
+const labelsSQL = `ALTER TABLE %[1]s
+		ADD COLUMN IF NOT EXISTS labels_key text NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.key_column_usage
+				WHERE table_name = '%[1]s' AND constraint_name = '%[1]s_pkey' AND column_name = 'labels_key') THEN
+			ALTER TABLE %[1]s DROP CONSTRAINT %[1]s_pkey, ADD PRIMARY KEY (metric_id, labels_key);
+		END IF;
+	END $$`
+
+// metricIDSQL widens metric_id of tables created when metric ids were limited to 45 characters
+const metricIDSQL = `DO $$
+	BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns
+				WHERE table_name = '%[1]s' AND column_name = 'metric_id' AND data_type = 'character varying') THEN
+			ALTER TABLE %[1]s ALTER COLUMN metric_id TYPE text;
+		END IF;
+	END $$`
+
+// labelsJSON encodes labels for jsonb columns
+func labelsJSON(labels structs.Labels) string {
+	if len(labels) == 0 {
+		return "{}"
+	}
+	b, err := json.Marshal(labels)
+	if err != nil {
+		// map[string]string is always encodable
+		panic(err)
+	}
+	return string(b)
+}
+
+func parseLabels(raw string) (structs.Labels, error) {
+	var labels structs.Labels
+	err := json.Unmarshal([]byte(raw), &labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode labels %s: %s", raw, err.Error())
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, nil
+}
+
+// DBConnector keeps metrics in Postgres. Ctx is used to connect and create tables,
+// queries are bound by the context of the storage call
 type DBConnector struct {
 	Ctx         context.Context
 	Pool        *pgxpool.Pool
 	DSN         string
-	initialized bool
+	KeepHistory bool
+	// QueryTimeout limits every storage call made for a client request (zero means no limit).
+	// Background work (history compaction, flushes of other ingestion protocols) is bounded by its own ctx
+	QueryTimeout time.Duration
+	initialized  bool
+}
+
+// queryCtx bounds the storage call with QueryTimeout if it is made for a client request
+func (d *DBConnector) queryCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.QueryTimeout > 0 && structs.IsClientContext(ctx) {
+		return context.WithTimeout(ctx, d.QueryTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (d *DBConnector) getCounterSQL() string {
+	if d.KeepHistory {
+		return counterHistorySQL
+	}
+	return counterSQL
+}
+
+func (d *DBConnector) getGaugeSQL() string {
+	if d.KeepHistory {
+		return gaugeHistorySQL
+	}
+	return gaugeSQL
 }
 
 func (d *DBConnector) checkInit() error {
@@ -47,27 +159,33 @@ func (d *DBConnector) Close() {
 	}
 }
 
-func (d *DBConnector) Avaliable() error {
+func (d *DBConnector) Avaliable(ctx context.Context) error {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
 	err := d.checkInit()
 	if err != nil {
 		return err
 	}
-	return d.Pool.Ping(d.Ctx)
+	err = d.Pool.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s", structs.ErrStorageUnavailable, err.Error())
+	}
+	return nil
 }
 
-func (d *DBConnector) getGauge(metricID string) (float64, error) {
+func (d *DBConnector) getGauge(ctx context.Context, metricID string, labels structs.Labels) (float64, error) {
 	err := d.checkInit()
 	if err != nil {
 		return -1, err
 	}
 	var gauge float64
-	sql := `SELECT metric_value FROM gauges WHERE metric_id=$1;`
-	conn, err := d.Pool.Acquire(d.Ctx)
+	sql := `SELECT metric_value FROM gauges WHERE metric_id=$1 AND labels_key=$2;`
+	conn, err := d.Pool.Acquire(ctx)
 	if err != nil {
-		return -1, fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return -1, fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
 	}
 	defer conn.Release()
-	row := conn.QueryRow(d.Ctx, sql, metricID)
+	row := conn.QueryRow(ctx, sql, metricID, labels.String())
 	switch err := row.Scan(&gauge); err {
 	case pgx.ErrNoRows:
 		return -1, structs.ErrMetricNotFound
@@ -79,75 +197,75 @@ func (d *DBConnector) getGauge(metricID string) (float64, error) {
 	}
 }
 
-func (d *DBConnector) setGauge(metricID string, metricValue float64) error {
+func (d *DBConnector) setGauge(ctx context.Context, metricID string, labels structs.Labels,
+	metricValue float64) error {
 	err := d.checkInit()
 	if err != nil {
 		return err
 	}
-	conn, err := d.Pool.Acquire(d.Ctx)
+	conn, err := d.Pool.Acquire(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
 	}
 	defer conn.Release()
-	sql := `INSERT INTO gauges (metric_id, metric_value)
-			VALUES($1, $2) 
-			ON CONFLICT (metric_id) 
-			DO 
-				UPDATE SET metric_value = $2 WHERE gauges.metric_id = $1;`
-	_, err = conn.Exec(d.Ctx, sql, metricID, metricValue)
+	_, err = conn.Exec(ctx, d.getGaugeSQL(), metricID, labels.String(), labelsJSON(labels), metricValue)
 	return err
 }
 
-func (d *DBConnector) getAllGauges() (map[string]float64, error) {
+func (d *DBConnector) getAllGauges(ctx context.Context) ([]structs.Metric, error) {
 	err := d.checkInit()
 	if err != nil {
-		return make(map[string]float64), err
+		return []structs.Metric{}, err
 	}
-	conn, err := d.Pool.Acquire(d.Ctx)
+	conn, err := d.Pool.Acquire(ctx)
 	if err != nil {
-		return make(map[string]float64), fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return []structs.Metric{}, fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
 	}
 	defer conn.Release()
-	gauges := make(map[string]float64)
-	sql := "SELECT metric_id, metric_value FROM gauges"
-	rows, err := conn.Query(d.Ctx, sql)
+	gauges := []structs.Metric{}
+	sql := "SELECT metric_id, labels::text, metric_value FROM gauges"
+	rows, err := conn.Query(ctx, sql)
 	if err != nil {
 		e := fmt.Errorf("failed to query gauges table: %s", err.Error())
-		return make(map[string]float64), e
+		return []structs.Metric{}, e
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var metricID string
+		var metricID, rawLabels string
 		var metricValue float64
-		if err := rows.Scan(&metricID, &metricValue); err != nil {
-			e := fmt.Errorf("failed to convert row to map entry: %s", err.Error())
-			return make(map[string]float64), e
+		if err := rows.Scan(&metricID, &rawLabels, &metricValue); err != nil {
+			e := fmt.Errorf("failed to convert row to metric: %s", err.Error())
+			return []structs.Metric{}, e
+		}
+		labels, err := parseLabels(rawLabels)
+		if err != nil {
+			return []structs.Metric{}, err
 		}
-		gauges[metricID] = metricValue
+		gauges = append(gauges, structs.Metric{ID: metricID, MType: "gauge", Value: &metricValue, Labels: labels})
 	}
 
 	if err := rows.Err(); err != nil {
 		e := fmt.Errorf("error(s) occured during gauges table scanning: %s", err.Error())
-		return make(map[string]float64), e
+		return []structs.Metric{}, e
 	}
 
 	return gauges, nil
 }
 
-func (d *DBConnector) getCounter(metricID string) (int64, error) {
+func (d *DBConnector) getCounter(ctx context.Context, metricID string, labels structs.Labels) (int64, error) {
 	err := d.checkInit()
 	if err != nil {
 		return -1, err
 	}
-	conn, err := d.Pool.Acquire(d.Ctx)
+	conn, err := d.Pool.Acquire(ctx)
 	if err != nil {
-		return -1, fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return -1, fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
 	}
 	defer conn.Release()
 	var counter int64
-	sql := `SELECT metric_value FROM counters WHERE metric_id=$1;`
-	row := conn.QueryRow(d.Ctx, sql, metricID)
+	sql := `SELECT metric_value FROM counters WHERE metric_id=$1 AND labels_key=$2;`
+	row := conn.QueryRow(ctx, sql, metricID, labels.String())
 	switch err := row.Scan(&counter); err {
 	case pgx.ErrNoRows:
 		return -1, structs.ErrMetricNotFound
@@ -159,97 +277,252 @@ func (d *DBConnector) getCounter(metricID string) (int64, error) {
 	}
 }
 
-func (d *DBConnector) increaseCounter(metricID string, metricValue int64) error {
+func (d *DBConnector) increaseCounter(ctx context.Context, metricID string, labels structs.Labels,
+	metricValue int64) error {
 	err := d.checkInit()
 	if err != nil {
 		return err
 	}
-	conn, err := d.Pool.Acquire(d.Ctx)
+	conn, err := d.Pool.Acquire(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
 	}
 	defer conn.Release()
-	sql := `INSERT INTO counters (metric_id, metric_value)
-			VALUES($1, $2) 
-			ON CONFLICT (metric_id)
-			DO
-				UPDATE SET metric_value = counters.metric_value + $2
-				WHERE counters.metric_id = $1;`
-	_, err = conn.Query(d.Ctx, sql, metricID, metricValue)
+	_, err = conn.Exec(ctx, d.getCounterSQL(), metricID, labels.String(), labelsJSON(labels), metricValue)
 	return err
 }
-func (d *DBConnector) getAllCounters() (map[string]int64, error) {
+func (d *DBConnector) getAllCounters(ctx context.Context) ([]structs.Metric, error) {
 	err := d.checkInit()
 	if err != nil {
-		return make(map[string]int64), err
+		return []structs.Metric{}, err
 	}
-	conn, err := d.Pool.Acquire(d.Ctx)
+	conn, err := d.Pool.Acquire(ctx)
 	if err != nil {
-		return make(map[string]int64), fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return []structs.Metric{}, fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
 	}
 	defer conn.Release()
-	counters := make(map[string]int64)
-	sql := "SELECT metric_id, metric_value FROM counters"
-	rows, err := conn.Query(d.Ctx, sql)
+	counters := []structs.Metric{}
+	sql := "SELECT metric_id, labels::text, metric_value FROM counters"
+	rows, err := conn.Query(ctx, sql)
 	if err != nil {
 		e := fmt.Errorf("failed to query counters table: %s", err.Error())
-		return make(map[string]int64), e
+		return []structs.Metric{}, e
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var metricID string
+		var metricID, rawLabels string
 		var metricValue int64
-		if err := rows.Scan(&metricID, &metricValue); err != nil {
-			e := fmt.Errorf("failed to convert row to map entry: %s", err.Error())
-			return make(map[string]int64), e
+		if err := rows.Scan(&metricID, &rawLabels, &metricValue); err != nil {
+			e := fmt.Errorf("failed to convert row to metric: %s", err.Error())
+			return []structs.Metric{}, e
 		}
-		counters[metricID] = metricValue
+		labels, err := parseLabels(rawLabels)
+		if err != nil {
+			return []structs.Metric{}, err
+		}
+		counters = append(counters, structs.Metric{ID: metricID, MType: "counter", Delta: &metricValue, Labels: labels})
 	}
 
 	if err := rows.Err(); err != nil {
 		e := fmt.Errorf("error(s) occured during counters table scanning: %s", err.Error())
-		return make(map[string]int64), e
+		return []structs.Metric{}, e
 	}
 
 	return counters, nil
 }
 
-func (d *DBConnector) ResetCounter(metricID string) error {
+// selectSeries returns labels keys of the metric series selected by matchers.
+// Matchers are applied here, so Go regexp syntax is used as by the other storages
+func selectSeries(ctx context.Context, tx pgx.Tx, table string, metricID string,
+	matchers []structs.LabelMatcher) ([]string, error) {
+	rows, err := tx.Query(ctx,
+		fmt.Sprintf("SELECT labels_key, labels::text FROM %s WHERE metric_id = $1 FOR UPDATE", table), metricID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select series from %s: %s", table, err.Error())
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key, rawLabels string
+		if err := rows.Scan(&key, &rawLabels); err != nil {
+			return nil, fmt.Errorf("failed to convert row to series: %s", err.Error())
+		}
+		labels, err := parseLabels(rawLabels)
+		if err != nil {
+			return nil, err
+		}
+		if structs.MatchLabels(labels, matchers) {
+			keys = append(keys, key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error(s) occured during %s table scanning: %s", table, err.Error())
+	}
+	if len(keys) == 0 {
+		return nil, structs.ErrMetricNotFound
+	}
+	return keys, nil
+}
+
+// ResetCounter resets series of the counter selected by matchers
+func (d *DBConnector) ResetCounter(ctx context.Context, metricID string, matchers []structs.LabelMatcher) error {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
 	err := d.checkInit()
 	if err != nil {
 		return err
 	}
-	conn, err := d.Pool.Acquire(d.Ctx)
+	conn, err := d.Pool.Acquire(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
 	}
 	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %s", err.Error())
+	}
+	defer tx.Rollback(ctx)
+
+	keys, err := selectSeries(ctx, tx, "counters", metricID, matchers)
+	if err != nil {
+		return err
+	}
 	sql := `UPDATE counters 
 			SET metric_value = 0
-			WHERE counters.metric_id = $1;`
-	_, err = conn.Exec(d.Ctx, sql, metricID)
-	return err
+			WHERE counters.metric_id = $1 AND counters.labels_key = ANY($2);`
+	_, err = tx.Exec(ctx, sql, metricID, keys)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
+	return nil
+}
+
+// DeleteMetric deletes series of the metric selected by matchers together with their history
+func (d *DBConnector) DeleteMetric(ctx context.Context, m structs.Metric, matchers []structs.LabelMatcher) error {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
+	tables := map[string][2]string{"counter": {"counters", "counters_history"}, "gauge": {"gauges", "gauges_history"}}
+	table, ok := tables[m.MType]
+	if !ok {
+		return structs.ErrMetricBadType
+	}
+	err := d.checkInit()
+	if err != nil {
+		return err
+	}
+	conn, err := d.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %s", err.Error())
+	}
+	defer tx.Rollback(ctx)
+
+	keys, err := selectSeries(ctx, tx, table[0], m.ID, matchers)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE metric_id = $1 AND labels_key = ANY($2)", table[0]),
+		m.ID, keys)
+	if err != nil {
+		return fmt.Errorf("failed to delete metric from %s: %s", table[0], err.Error())
+	}
+	_, err = tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE metric_id = $1 AND labels_key = ANY($2)", table[1]),
+		m.ID, keys)
+	if err != nil {
+		return fmt.Errorf("failed to delete metric history from %s: %s", table[1], err.Error())
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM history_rollups
+		WHERE metric_type = $1 AND metric_id = $2 AND labels_key = ANY($3)`, m.MType, m.ID, keys)
+	if err != nil {
+		return fmt.Errorf("failed to delete metric rollups: %s", err.Error())
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
+	return nil
 }
 
 func (d *DBConnector) CreateTables() error {
 	conn, err := d.Pool.Acquire(d.Ctx)
 	defer conn.Release()
 	if err != nil {
-		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
 	}
 	countersSQL := `CREATE TABLE IF NOT EXISTS counters(
-		metric_id varchar(45) NOT NULL,
+		metric_id text NOT NULL,
+		labels_key text NOT NULL DEFAULT '',
+		labels jsonb NOT NULL DEFAULT '{}',
 		metric_value bigint NOT NULL,
-		PRIMARY KEY (metric_id)
+		PRIMARY KEY (metric_id, labels_key)
 	  )`
 
 	gaugesSQL := `CREATE TABLE IF NOT EXISTS gauges(
-		metric_id varchar(45) NOT NULL,
+		metric_id text NOT NULL,
+		labels_key text NOT NULL DEFAULT '',
+		labels jsonb NOT NULL DEFAULT '{}',
 		metric_value double precision NOT NULL,
-		PRIMARY KEY (metric_id)
+		PRIMARY KEY (metric_id, labels_key)
 	)`
 
+	countersHistorySQL := `CREATE TABLE IF NOT EXISTS counters_history(
+		metric_id text NOT NULL,
+		labels_key text NOT NULL DEFAULT '',
+		metric_value bigint NOT NULL,
+		created_at timestamptz NOT NULL
+	);
+	ALTER TABLE counters_history ADD COLUMN IF NOT EXISTS labels_key text NOT NULL DEFAULT '';
+	DROP INDEX IF EXISTS counters_history_idx;
+	CREATE INDEX IF NOT EXISTS counters_history_labels_idx
+		ON counters_history (metric_id, labels_key, created_at)`
+
+	gaugesHistorySQL := `CREATE TABLE IF NOT EXISTS gauges_history(
+		metric_id text NOT NULL,
+		labels_key text NOT NULL DEFAULT '',
+		metric_value double precision NOT NULL,
+		created_at timestamptz NOT NULL
+	);
+	ALTER TABLE gauges_history ADD COLUMN IF NOT EXISTS labels_key text NOT NULL DEFAULT '';
+	DROP INDEX IF EXISTS gauges_history_idx;
+	CREATE INDEX IF NOT EXISTS gauges_history_labels_idx
+		ON gauges_history (metric_id, labels_key, created_at)`
+
+	rollupsSQL := `CREATE TABLE IF NOT EXISTS history_rollups(
+		metric_type varchar(10) NOT NULL,
+		metric_id text NOT NULL,
+		labels_key text NOT NULL DEFAULT '',
+		resolution bigint NOT NULL,
+		bucket timestamptz NOT NULL,
+		min_value double precision NOT NULL,
+		max_value double precision NOT NULL,
+		avg_value double precision NOT NULL,
+		samples bigint NOT NULL,
+		PRIMARY KEY (metric_type, metric_id, labels_key, resolution, bucket)
+	);
+	ALTER TABLE history_rollups ADD COLUMN IF NOT EXISTS labels_key text NOT NULL DEFAULT '';
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.key_column_usage
+				WHERE table_name = 'history_rollups' AND constraint_name = 'history_rollups_pkey'
+					AND column_name = 'labels_key') THEN
+			ALTER TABLE history_rollups DROP CONSTRAINT history_rollups_pkey,
+				ADD PRIMARY KEY (metric_type, metric_id, labels_key, resolution, bucket);
+		END IF;
+	END $$`
+
 	_, err = conn.Exec(d.Ctx, countersSQL)
 	if err != nil {
 		return fmt.Errorf("cant create counters table: %s", err.Error())
@@ -259,6 +532,35 @@ func (d *DBConnector) CreateTables() error {
 	if err != nil {
 		return fmt.Errorf("cant create gauge table: %s", err.Error())
 	}
+
+	for _, table := range []string{"counters", "gauges"} {
+		_, err = conn.Exec(d.Ctx, fmt.Sprintf(labelsSQL, table))
+		if err != nil {
+			return fmt.Errorf("cant add labels to %s table: %s", table, err.Error())
+		}
+	}
+
+	_, err = conn.Exec(d.Ctx, countersHistorySQL)
+	if err != nil {
+		return fmt.Errorf("cant create counters_history table: %s", err.Error())
+	}
+
+	_, err = conn.Exec(d.Ctx, gaugesHistorySQL)
+	if err != nil {
+		return fmt.Errorf("cant create gauges_history table: %s", err.Error())
+	}
+
+	_, err = conn.Exec(d.Ctx, rollupsSQL)
+	if err != nil {
+		return fmt.Errorf("cant create history_rollups table: %s", err.Error())
+	}
+
+	for _, table := range []string{"counters", "gauges", "counters_history", "gauges_history", "history_rollups"} {
+		_, err = conn.Exec(d.Ctx, fmt.Sprintf(metricIDSQL, table))
+		if err != nil {
+			return fmt.Errorf("cant change metric_id type of %s table: %s", table, err.Error())
+		}
+	}
 	return nil
 }
 
@@ -266,11 +568,12 @@ func (d *DBConnector) DropTables() error {
 	conn, err := d.Pool.Acquire(d.Ctx)
 	defer conn.Release()
 	if err != nil {
-		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
 	}
 
 	countersSQL := "DROP TABLE IF EXISTS counters"
 	gaugesSQL := "DROP TABLE IF EXISTS gauges"
+	historySQL := "DROP TABLE IF EXISTS counters_history, gauges_history, history_rollups"
 
 	_, err = conn.Exec(d.Ctx, countersSQL)
 	if err != nil {
@@ -281,99 +584,233 @@ func (d *DBConnector) DropTables() error {
 	if err != nil {
 		return fmt.Errorf("cant drop gauge table: %s", err.Error())
 	}
+
+	_, err = conn.Exec(d.Ctx, historySQL)
+	if err != nil {
+		return fmt.Errorf("cant drop history tables: %s", err.Error())
+	}
 	return nil
 }
 
-func (d *DBConnector) UpdateMetrics(metrics []structs.Metric) error {
+func (d *DBConnector) UpdateMetrics(ctx context.Context, metrics []structs.Metric) error {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
 	var err error
 	err = d.checkInit()
 	if err != nil {
 		return err
 	}
-	conn, err := d.Pool.Acquire(d.Ctx)
+	conn, err := d.Pool.Acquire(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to acquire connection: %s", err.Error())
+		return fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
 	}
 	defer conn.Release()
 
-	sqlCounters := `INSERT INTO counters (metric_id, metric_value)
-					VALUES($1, $2) 
-					ON CONFLICT (metric_id)
-					DO
-						UPDATE SET metric_value = counters.metric_value + $2
-						WHERE counters.metric_id = $1;`
-	sqlGauges := `INSERT INTO gauges (metric_id, metric_value)
-				  VALUES($1, $2) 
-				  ON CONFLICT (metric_id) 
-				  DO 
-					UPDATE SET metric_value = $2 WHERE gauges.metric_id = $1;`
-	tx, err := conn.Begin(d.Ctx)
+	sqlCounters := d.getCounterSQL()
+	sqlGauges := d.getGaugeSQL()
+	tx, err := conn.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to start transaction: %s", err.Error())
 	}
 	// Rollback is safe to call even if the tx is already closed, so if
 	// the tx commits successfully, this is a no-op
-	defer tx.Rollback(d.Ctx)
+	defer tx.Rollback(ctx)
 
 	for _, m := range metrics {
 		switch m.MType {
 		case "counter":
-			_, err = tx.Exec(d.Ctx, sqlCounters, m.ID, m.Delta)
+			_, err = tx.Exec(ctx, sqlCounters, m.ID, m.Labels.String(), labelsJSON(m.Labels), m.Delta)
 			if err != nil {
-				return fmt.Errorf("failed to update counter %s(%d): %s", m.ID, *m.Delta, err.Error())
+				return fmt.Errorf("failed to update counter %s%s(%d): %s", m.ID, m.Labels.String(), *m.Delta, err.Error())
 			}
 		case "gauge":
-			_, err = tx.Exec(d.Ctx, sqlGauges, m.ID, m.Value)
+			_, err = tx.Exec(ctx, sqlGauges, m.ID, m.Labels.String(), labelsJSON(m.Labels), m.Value)
 			if err != nil {
-				return fmt.Errorf("failed to update gauge %s(%f): %s", m.ID, *m.Value, err.Error())
+				return fmt.Errorf("failed to update gauge %s%s(%f): %s", m.ID, m.Labels.String(), *m.Value, err.Error())
 			}
 		default:
 			// we shoudn`t be here. Metric type were checked at serializer.DecodeBodyBatch()
 			return structs.ErrMetricBadType
 		}
 	}
-	err = tx.Commit(d.Ctx)
+	err = tx.Commit(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to commit transaction: %s", err.Error())
 	}
 	return nil
 }
 
-func (d *DBConnector) GetMetrics() ([]structs.Metric, error) {
-	var metrics []structs.Metric
-
-	counters, err := d.getAllCounters()
+func (d *DBConnector) GetMetrics(ctx context.Context) ([]structs.Metric, error) {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
+	counters, err := d.getAllCounters(ctx)
 	if err != nil {
 		return []structs.Metric{}, fmt.Errorf("cant get counters: %s", err.Error())
 	}
 
-	gauges, err := d.getAllGauges()
+	gauges, err := d.getAllGauges(ctx)
 	if err != nil {
 		return []structs.Metric{}, fmt.Errorf("cant get gauges: %s", err.Error())
 	}
 
-	for k, v := range counters {
-		metrics = append(metrics, structs.Metric{ID: k, Delta: &v, MType: "counter"})
+	return append(counters, gauges...), nil
+}
+
+// listRegexpBatch is the number of rows scanned per query when metrics are filtered by regexp
+const listRegexpBatch = 1000
+
+// listSQL builds query selecting metrics page (one row more than the limit to detect the next page).
+// Metric IDs are compared in "C" collation to match byte order of in-memory listing.
+// Regexp is not applied here (see listFiltered)
+func listSQL(q structs.ListQuery, limit int) (string, []interface{}, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if q.MType != "" {
+		where = append(where, "mtype = "+arg(q.MType))
+	}
+	if q.Prefix != "" {
+		p := arg(q.Prefix)
+		where = append(where, fmt.Sprintf("left(metric_id, length(%s)) = %s", p, p))
+	}
+
+	columns := `metric_id COLLATE "C", mtype COLLATE "C", labels_key COLLATE "C"`
+	if q.SortBy == structs.SortByType {
+		columns = `mtype COLLATE "C", metric_id COLLATE "C", labels_key COLLATE "C"`
+	}
+	order := "ASC"
+	cmp := ">"
+	if q.Desc {
+		order = "DESC"
+		cmp = "<"
+	}
+	if q.Cursor != "" {
+		c, err := structs.DecodeListCursor(q.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		id, mtype, labels := arg(c.ID), arg(c.MType), arg(c.Labels)
+		cursor := fmt.Sprintf(`%s COLLATE "C", %s COLLATE "C", %s COLLATE "C"`, id, mtype, labels)
+		if q.SortBy == structs.SortByType {
+			cursor = fmt.Sprintf(`%s COLLATE "C", %s COLLATE "C", %s COLLATE "C"`, mtype, id, labels)
+		}
+		where = append(where, fmt.Sprintf("(%s) %s (%s)", columns, cmp, cursor))
+	}
+
+	sql := `SELECT metric_id, mtype, labels::text, delta, value FROM (
+			SELECT metric_id, 'counter' AS mtype, labels_key, labels, metric_value AS delta, NULL::double precision AS value
+			FROM counters
+			UNION ALL
+			SELECT metric_id, 'gauge' AS mtype, labels_key, labels, NULL::bigint AS delta, metric_value AS value
+			FROM gauges) AS metrics`
+	if len(where) > 0 {
+		sql += "\n\t\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	sql += fmt.Sprintf("\n\t\t\tORDER BY %s %s LIMIT %s;",
+		strings.ReplaceAll(columns, ", ", " "+order+", "), order, arg(limit+1))
+	return sql, args, nil
+}
+
+func (d *DBConnector) ListMetrics(ctx context.Context, q structs.ListQuery) (structs.MetricList, error) {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
+	err := d.checkInit()
+	if err != nil {
+		return structs.MetricList{}, err
+	}
+	conn, err := d.Pool.Acquire(ctx)
+	if err != nil {
+		return structs.MetricList{}, fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
+	}
+	defer conn.Release()
+
+	return listFiltered(q, func(q structs.ListQuery, limit int) ([]structs.Metric, error) {
+		sql, args, err := listSQL(q, limit)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := conn.Query(ctx, sql, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query metrics: %s", err.Error())
+		}
+		defer rows.Close()
+
+		metrics := []structs.Metric{}
+		for rows.Next() {
+			var m structs.Metric
+			var rawLabels string
+			if err := rows.Scan(&m.ID, &m.MType, &rawLabels, &m.Delta, &m.Value); err != nil {
+				return nil, fmt.Errorf("failed to convert row to metric: %s", err.Error())
+			}
+			m.Labels, err = parseLabels(rawLabels)
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, m)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error(s) occured during metrics scanning: %s", err.Error())
+		}
+		return metrics, nil
+	})
+}
+
+// listFiltered builds metrics page from the rows returned by fetch (see listSQL).
+// Regexp is applied here, so Go regexp syntax is used as by the other storages:
+// rows are fetched in batches following the last fetched row until the page is filled
+func listFiltered(q structs.ListQuery,
+	fetch func(q structs.ListQuery, limit int) ([]structs.Metric, error)) (structs.MetricList, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = structs.ListLimitDefault
+	}
+	batch := limit
+	if q.Regexp != nil && batch < listRegexpBatch {
+		batch = listRegexpBatch
 	}
 
-	for k, v := range gauges {
-		metrics = append(metrics, structs.Metric{ID: k, Value: &v, MType: "gauge"})
+	list := structs.MetricList{Metrics: []structs.Metric{}}
+	for {
+		rows, err := fetch(q, batch)
+		if err != nil {
+			return structs.MetricList{}, err
+		}
+		for _, m := range rows {
+			if q.Regexp == nil || q.Regexp.MatchString(m.ID) {
+				list.Metrics = append(list.Metrics, m)
+			}
+		}
+		if len(rows) <= batch || len(list.Metrics) > limit {
+			break
+		}
+		last := rows[len(rows)-1]
+		q.Cursor = structs.ListCursor{ID: last.ID, MType: last.MType, Labels: last.Labels.String()}.Encode()
 	}
 
-	return metrics, nil
+	if len(list.Metrics) > limit {
+		list.Metrics = list.Metrics[:limit]
+		last := list.Metrics[limit-1]
+		list.NextCursor = structs.ListCursor{ID: last.ID, MType: last.MType, Labels: last.Labels.String()}.Encode()
+	}
+	return list, nil
 }
 
-func (d *DBConnector) GetMetric(m structs.Metric) (structs.Metric, error) {
+func (d *DBConnector) GetMetric(ctx context.Context, m structs.Metric) (structs.Metric, error) {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
 	switch m.MType {
 	case "counter":
-		c, err := d.getCounter(m.ID)
+		c, err := d.getCounter(ctx, m.ID, m.Labels)
 		if err != nil {
 			return structs.Metric{}, err
 		}
 		m.Delta = &c
 		return m, nil
 	case "gauge":
-		g, err := d.getGauge(m.ID)
+		g, err := d.getGauge(ctx, m.ID, m.Labels)
 		if err != nil {
 			return structs.Metric{}, err
 		}
@@ -386,13 +823,15 @@ func (d *DBConnector) GetMetric(m structs.Metric) (structs.Metric, error) {
 	}
 }
 
-func (d *DBConnector) UpdateMetric(m structs.Metric) error {
+func (d *DBConnector) UpdateMetric(ctx context.Context, m structs.Metric) error {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
 	switch m.MType {
 	case "counter":
 		if m.Delta == nil {
 			return structs.ErrMetricNullAttr
 		}
-		err := d.increaseCounter(m.ID, *m.Delta)
+		err := d.increaseCounter(ctx, m.ID, m.Labels, *m.Delta)
 		if err != nil {
 			return err
 		}
@@ -400,7 +839,7 @@ func (d *DBConnector) UpdateMetric(m structs.Metric) error {
 		if m.Value == nil {
 			return structs.ErrMetricNullAttr
 		}
-		err := d.setGauge(m.ID, *m.Value)
+		err := d.setGauge(ctx, m.ID, m.Labels, *m.Value)
 		if err != nil {
 			return err
 		}
@@ -411,3 +850,216 @@ func (d *DBConnector) UpdateMetric(m structs.Metric) error {
 	return nil
 
 }
+
+func (d *DBConnector) GetMetricHistory(ctx context.Context, m structs.Metric,
+	from time.Time, to time.Time) ([]structs.Sample, error) {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
+	if !d.KeepHistory {
+		return []structs.Sample{}, structs.ErrHistoryDisabled
+	}
+	err := d.checkInit()
+	if err != nil {
+		return []structs.Sample{}, err
+	}
+
+	var table string
+	switch m.MType {
+	case "counter":
+		table = "counters_history"
+	case "gauge":
+		table = "gauges_history"
+	default:
+		return []structs.Sample{}, structs.ErrMetricBadType
+	}
+
+	conn, err := d.Pool.Acquire(ctx)
+	if err != nil {
+		return []structs.Sample{}, fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
+	}
+	defer conn.Release()
+
+	sql := fmt.Sprintf(`SELECT metric_value, created_at FROM %s
+			WHERE metric_id=$1 AND labels_key=$2 AND created_at BETWEEN $3 AND $4
+			ORDER BY created_at;`, table)
+	rows, err := conn.Query(ctx, sql, m.ID, m.Labels.String(), from, to)
+	if err != nil {
+		return []structs.Sample{}, fmt.Errorf("failed to query %s table: %s", table, err.Error())
+	}
+	defer rows.Close()
+
+	var samples = []structs.Sample{}
+	for rows.Next() {
+		var sample structs.Sample
+		if m.MType == "counter" {
+			var delta int64
+			err = rows.Scan(&delta, &sample.Timestamp)
+			sample.Delta = &delta
+		} else {
+			var value float64
+			err = rows.Scan(&value, &sample.Timestamp)
+			sample.Value = &value
+		}
+		if err != nil {
+			return []structs.Sample{}, fmt.Errorf("failed to convert row to sample: %s", err.Error())
+		}
+		samples = append(samples, sample)
+	}
+
+	if err := rows.Err(); err != nil {
+		e := fmt.Errorf("error(s) occured during %s table scanning: %s", table, err.Error())
+		return []structs.Sample{}, e
+	}
+
+	if len(samples) == 0 {
+		_, err := d.GetMetric(ctx, m)
+		if err != nil {
+			return []structs.Sample{}, err
+		}
+	}
+	return samples, nil
+}
+
+func (d *DBConnector) GetMetricRollups(ctx context.Context, m structs.Metric, resolution time.Duration,
+	from time.Time, to time.Time) ([]structs.Rollup, error) {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
+	if !d.KeepHistory {
+		return []structs.Rollup{}, structs.ErrHistoryDisabled
+	}
+	if m.MType != "counter" && m.MType != "gauge" {
+		return []structs.Rollup{}, structs.ErrMetricBadType
+	}
+	if resolution != structs.RollupMinute && resolution != structs.RollupHour {
+		return []structs.Rollup{}, structs.ErrBadResolution
+	}
+	err := d.checkInit()
+	if err != nil {
+		return []structs.Rollup{}, err
+	}
+	conn, err := d.Pool.Acquire(ctx)
+	if err != nil {
+		return []structs.Rollup{}, fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
+	}
+	defer conn.Release()
+
+	sql := `SELECT bucket, min_value, max_value, avg_value, samples FROM history_rollups
+			WHERE metric_type=$1 AND metric_id=$2 AND labels_key=$3 AND resolution=$4
+				AND bucket BETWEEN $5 AND $6
+			ORDER BY bucket;`
+	rows, err := conn.Query(ctx, sql, m.MType, m.ID, m.Labels.String(), int64(resolution.Seconds()), from, to)
+	if err != nil {
+		return []structs.Rollup{}, fmt.Errorf("failed to query history_rollups table: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var rollups = []structs.Rollup{}
+	for rows.Next() {
+		var r structs.Rollup
+		if err := rows.Scan(&r.Timestamp, &r.Min, &r.Max, &r.Avg, &r.Count); err != nil {
+			return []structs.Rollup{}, fmt.Errorf("failed to convert row to rollup: %s", err.Error())
+		}
+		rollups = append(rollups, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		e := fmt.Errorf("error(s) occured during history_rollups table scanning: %s", err.Error())
+		return []structs.Rollup{}, e
+	}
+
+	if len(rollups) == 0 {
+		_, err := d.GetMetric(ctx, m)
+		if err != nil {
+			return []structs.Rollup{}, err
+		}
+	}
+	return rollups, nil
+}
+
+func (d *DBConnector) CompactHistory(ctx context.Context, policy structs.RetentionPolicy, now time.Time) error {
+	ctx, cancel := d.queryCtx(ctx)
+	defer cancel()
+	if !d.KeepHistory {
+		return structs.ErrHistoryDisabled
+	}
+	err := d.checkInit()
+	if err != nil {
+		return err
+	}
+	conn, err := d.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: failed to acquire connection: %s", structs.ErrStorageUnavailable, err.Error())
+	}
+	defer conn.Release()
+
+	onConflict := `ON CONFLICT (metric_type, metric_id, labels_key, resolution, bucket)
+			DO
+				UPDATE SET min_value = LEAST(history_rollups.min_value, EXCLUDED.min_value),
+					max_value = GREATEST(history_rollups.max_value, EXCLUDED.max_value),
+					avg_value = (history_rollups.avg_value * history_rollups.samples +
+						EXCLUDED.avg_value * EXCLUDED.samples) /
+						(history_rollups.samples + EXCLUDED.samples),
+					samples = history_rollups.samples + EXCLUDED.samples;`
+	rawSQL := `INSERT INTO history_rollups
+				(metric_type, metric_id, labels_key, resolution, bucket, min_value, max_value, avg_value, samples)
+			SELECT $1::varchar, metric_id, labels_key, 60, date_trunc('minute', created_at),
+				min(metric_value), max(metric_value), avg(metric_value), count(*)
+			FROM %s WHERE created_at < $2
+			GROUP BY metric_id, labels_key, date_trunc('minute', created_at) ` + onConflict
+	minuteSQL := `INSERT INTO history_rollups
+				(metric_type, metric_id, labels_key, resolution, bucket, min_value, max_value, avg_value, samples)
+			SELECT metric_type, metric_id, labels_key, 3600, date_trunc('hour', bucket),
+				min(min_value), max(max_value), sum(avg_value * samples) / sum(samples), sum(samples)
+			FROM history_rollups WHERE resolution = 60 AND bucket < $1
+			GROUP BY metric_type, metric_id, labels_key, date_trunc('hour', bucket) ` + onConflict
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %s", err.Error())
+	}
+	defer tx.Rollback(ctx)
+
+	// raw samples -> minute rollups
+	if policy.Raw > 0 {
+		rawCutoff := now.Add(-policy.Raw).Truncate(structs.RollupMinute)
+		tables := map[string]string{"counter": "counters_history", "gauge": "gauges_history"}
+		for mtype, table := range tables {
+			_, err = tx.Exec(ctx, fmt.Sprintf(rawSQL, table), mtype, rawCutoff)
+			if err != nil {
+				return fmt.Errorf("failed to rollup %s: %s", table, err.Error())
+			}
+			_, err = tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE created_at < $1", table), rawCutoff)
+			if err != nil {
+				return fmt.Errorf("failed to delete raw samples from %s: %s", table, err.Error())
+			}
+		}
+	}
+
+	// minute rollups -> hour rollups
+	if policy.Rollup > 0 {
+		minuteCutoff := now.Add(-policy.Rollup).Truncate(structs.RollupHour)
+		_, err = tx.Exec(ctx, minuteSQL, minuteCutoff)
+		if err != nil {
+			return fmt.Errorf("failed to rollup minute rollups: %s", err.Error())
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM history_rollups WHERE resolution = 60 AND bucket < $1", minuteCutoff)
+		if err != nil {
+			return fmt.Errorf("failed to delete minute rollups: %s", err.Error())
+		}
+	}
+
+	// deleting old hour rollups
+	if policy.RollupHour > 0 {
+		hourCutoff := now.Add(-policy.RollupHour)
+		_, err = tx.Exec(ctx, "DELETE FROM history_rollups WHERE resolution = 3600 AND bucket < $1", hourCutoff)
+		if err != nil {
+			return fmt.Errorf("failed to delete hour rollups: %s", err.Error())
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %s", err.Error())
+	}
+	return nil
+}
@@ -1,5 +1,50 @@
 package db
 
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+func TestQueryCtx(t *testing.T) {
+	tt := []struct {
+		name         string
+		timeout      time.Duration
+		client       bool
+		wantDeadline bool
+	}{
+		{name: "timeout", timeout: time.Second, client: true, wantDeadline: true},
+		{name: "no timeout", timeout: 0, client: true, wantDeadline: false},
+		// compaction of big history must not be cancelled by the request timeout
+		{name: "background call", timeout: time.Second, client: false, wantDeadline: false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			d := DBConnector{QueryTimeout: tc.timeout}
+			parent, cancelParent := context.WithCancel(context.Background())
+			if tc.client {
+				parent = structs.ClientContext(parent)
+			}
+			ctx, cancel := d.queryCtx(parent)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			if ok != tc.wantDeadline || (ok && time.Until(deadline) > tc.timeout) {
+				t.Errorf("deadline mismatch: have %v (%t), want timeout %s", deadline, ok, tc.timeout)
+			}
+			// request cancellation reaches the query
+			cancelParent()
+			if ctx.Err() != context.Canceled {
+				t.Errorf("query context was not cancelled with the request: %v", ctx.Err())
+			}
+		})
+	}
+}
+
 // import (
 // 	"context"
 // 	"fmt"
@@ -56,6 +101,54 @@ package db
 // 	return d
 // }
 
+func TestListFiltered(t *testing.T) {
+	var metrics []structs.Metric
+	for i := 0; i < 2500; i++ {
+		d := int64(i)
+		metrics = append(metrics, structs.Metric{ID: fmt.Sprintf("m%04d", i), MType: "counter", Delta: &d})
+	}
+	// fetch returns rows as listSQL selects them: one row more than the limit, regexp is not applied
+	fetch := func(q structs.ListQuery, limit int) ([]structs.Metric, error) {
+		q.Regexp = nil
+		q.Limit = limit + 1
+		list, err := structs.ListMetrics(metrics, q)
+		return list.Metrics, err
+	}
+
+	tt := []struct {
+		name  string
+		query structs.ListQuery
+	}{
+		{name: "no regexp", query: structs.ListQuery{Limit: 1000}},
+		// matches are spread over several batches
+		{name: "sparse regexp", query: structs.ListQuery{Regexp: regexp.MustCompile(`^m(?P<thousands>\d)99\d$`), Limit: 7}},
+		{name: "descending", query: structs.ListQuery{Regexp: regexp.MustCompile(`\A.*0\z`), Desc: true, Limit: 100}},
+		{name: "no matches", query: structs.ListQuery{Regexp: regexp.MustCompile(`x`), Limit: 10}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			q, want := tc.query, tc.query
+			for page := 0; ; page++ {
+				have, err := listFiltered(q, fetch)
+				if err != nil {
+					t.Fatal(err)
+				}
+				wantList, err := structs.ListMetrics(metrics, want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(have, wantList) {
+					t.Fatalf("page %d mismatch: have %d metrics, want %d", page, len(have.Metrics), len(wantList.Metrics))
+				}
+				if have.NextCursor == "" {
+					break
+				}
+				q.Cursor, want.Cursor = have.NextCursor, wantList.NextCursor
+			}
+		})
+	}
+}
+
 // func TestCheckInit(t *testing.T) {
 // 	notInitilized := DBConnector{Ctx: ctx, DSN: dsn}
 // 	initilized := DBConnector{Ctx: ctx, DSN: dsn}
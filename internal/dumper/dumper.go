@@ -3,80 +3,272 @@ package dumper
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"sync"
 	"time"
 
 	"github.com/zklevsha/go-musthave-devops/internal/serializer"
 	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"github.com/zklevsha/go-musthave-devops/internal/wal"
 )
 
-func dump(filePath string, store structs.Storage) error {
-	encodedMetrics, err := serializer.EncodeMetrics(store)
+// Storage writes changes to the WAL (if set) before applying them,
+// the WAL is truncated after each successful dump.
+// Data is dumped right after metrics were deleted or reset,
+// so deleted metrics are not restored if the server stops before the next scheduled dump.
+// In synchronous mode (see NewSyncStorage) every change is dumped before the call returns,
+// a change whose dump failed is kept in the WAL and saved by the next successful dump.
+// Without WAL a failed dump is returned to the caller, the change stays applied in memory
+type Storage struct {
+	structs.Storage
+	wal *wal.Log
+	// syncInterval is how often WAL is fsynced (zero if it is fsynced by every write or never)
+	syncInterval time.Duration
+	// mx keeps WAL records in the order changes are applied and WAL truncation consistent with dumps
+	mx sync.Mutex
+	// seq numbers changes (see wal.Record), guarded by mx
+	seq     uint64
+	changed chan struct{}
+	// storeFile is set in synchronous mode
+	storeFile *StoreFile
+	// flushMx serializes synchronous dumps, flushed is seq saved by the last one
+	flushMx sync.Mutex
+	flushed uint64
+}
+
+// NewStorage returns storage wrapper numbering changes after seq (seq of the restored data, see RestoreData)
+func NewStorage(store structs.Storage, walLog *wal.Log, syncInterval time.Duration, seq uint64) *Storage {
+	return &Storage{Storage: store, wal: walLog, syncInterval: syncInterval, seq: seq, flushed: seq,
+		changed: make(chan struct{}, 1)}
+}
+
+// NewSyncStorage returns storage that dumps data to storeFile after every change, before the call returns.
+// Concurrent changes are saved by one dump
+func NewSyncStorage(store structs.Storage, walLog *wal.Log, syncInterval time.Duration, seq uint64,
+	storeFile StoreFile) *Storage {
+	s := NewStorage(store, walLog, syncInterval, seq)
+	s.storeFile = &storeFile
+	return s
+}
+
+// write logs the change and applies it to the storage
+func (s *Storage) write(ctx context.Context, r wal.Record) error {
+	s.mx.Lock()
+	s.seq++
+	r.Seq = s.seq
+	if s.wal != nil {
+		err := s.wal.Append(r)
+		if err != nil {
+			s.mx.Unlock()
+			return err
+		}
+	}
+	err := r.Apply(ctx, s.Storage)
 	if err != nil {
-		return fmt.Errorf("failed to convert metrics to json: %s", err.Error())
+		s.mx.Unlock()
+		return err
+	}
+	seq := s.seq
+	s.mx.Unlock()
+	if s.storeFile != nil {
+		return s.flush(seq)
+	}
+	return nil
+}
+
+// flush dumps data unless change seq was saved by another dump meanwhile.
+// The change is applied already, so with WAL a failed dump is not reported to the caller (a retried update
+// would be counted twice): the change is fsynced to the WAL and saved by the next successful dump.
+// Without WAL the change would be lost on restart, so the error is returned
+func (s *Storage) flush(seq uint64) error {
+	s.flushMx.Lock()
+	defer s.flushMx.Unlock()
+	if s.flushed >= seq {
+		return nil
 	}
-	log.Printf("INFO dump file content:\n %s\n", encodedMetrics)
-	err = ioutil.WriteFile(filePath, encodedMetrics, 0644)
+	saved, err := s.save(*s.storeFile)
+	if err == nil {
+		s.flushed = saved
+		return nil
+	}
+	log.Printf("ERROR dump failed to save change %d: %s", seq, err.Error())
+	if s.wal == nil {
+		return fmt.Errorf("failed to dump change %d: %s", seq, err.Error())
+	}
+	err = s.wal.Sync()
 	if err != nil {
-		return fmt.Errorf("failed to dump metric to file %s: %s", filePath, err.Error())
+		log.Printf("ERROR dump %s", err.Error())
 	}
 	return nil
 }
 
-func restore(filePath string, store structs.Storage) error {
-	jsonFile, err := ioutil.ReadFile(filePath)
+func (s *Storage) UpdateMetric(ctx context.Context, m structs.Metric) error {
+	return s.write(ctx, wal.Record{Op: wal.OpUpdate, Metrics: []structs.Metric{m}})
+}
+
+func (s *Storage) UpdateMetrics(ctx context.Context, metrics []structs.Metric) error {
+	return s.write(ctx, wal.Record{Op: wal.OpUpdate, Metrics: metrics})
+}
+
+func (s *Storage) DeleteMetric(ctx context.Context, m structs.Metric, matchers []structs.LabelMatcher) error {
+	err := s.write(ctx, wal.Record{Op: wal.OpDelete, ID: m.ID, MType: m.MType, Match: wal.NewMatch(matchers)})
+	if err == nil {
+		s.notify()
+	}
+	return err
+}
+
+func (s *Storage) ResetCounter(ctx context.Context, ID string, matchers []structs.LabelMatcher) error {
+	err := s.write(ctx, wal.Record{Op: wal.OpReset, ID: ID, Match: wal.NewMatch(matchers)})
+	if err == nil {
+		s.notify()
+	}
+	return err
+}
+
+// notify requests a dump, changes made while dump is pending are saved by that dump.
+// Synchronous mode has nothing to request, changes are already dumped
+func (s *Storage) notify() {
+	if s.storeFile != nil {
+		return
+	}
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// save dumps data and truncates WAL. Changes are blocked meanwhile,
+// so every change is either in the dump or in the WAL. Returns seq of the last saved change
+func (s *Storage) save(f StoreFile) (uint64, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	// dumps are not bound to the request which triggered them
+	err := dump(context.Background(), f, s.Storage, s.seq)
+	if err != nil {
+		return 0, err
+	}
+	if s.wal != nil {
+		err = s.wal.Truncate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return s.seq, nil
+}
+
+func dump(ctx context.Context, f StoreFile, store structs.Storage, seq uint64) error {
+	encodedMetrics, err := serializer.EncodeMetrics(ctx, store)
 	if err != nil {
-		return fmt.Errorf("failed to open dump file %s: %s", filePath, err.Error())
+		return fmt.Errorf("failed to convert metrics to json: %s", err.Error())
 	}
-	metrics := []structs.Metric{}
-	err = json.Unmarshal(jsonFile, &metrics)
+	data, err := encodeSnapshot(encodedMetrics, f.Compress, seq)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshall json to serializer.Metrics: %s", err.Error())
+		return fmt.Errorf("failed to encode dump: %s", err.Error())
 	}
-	err = store.UpdateMetrics(metrics)
+	err = f.write(data)
 	if err != nil {
-		return fmt.Errorf("failed to restore metrics: %s", err.Error())
+		return fmt.Errorf("failed to dump metric to file %s: %s", f.Path, err.Error())
 	}
+	log.Printf("INFO dump wrote %d bytes to %s", len(data), f.Path)
 	return nil
 }
 
-func dumpData(storeFile string, store structs.Storage) {
+// restore loads the newest valid dump generation, returns its path and seq
+func restore(ctx context.Context, f StoreFile, store structs.Storage) (string, uint64, error) {
+	metrics, seq, path, err := f.read()
+	if err != nil {
+		return "", 0, err
+	}
+	err = store.UpdateMetrics(ctx, metrics)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to restore metrics from %s: %s", path, err.Error())
+	}
+	return path, seq, nil
+}
+
+func dumpData(storeFile StoreFile, store *Storage) {
 	log.Println("INFO dump dumping data to disk")
-	err := dump(storeFile, store)
+	_, err := store.save(storeFile)
 	if err != nil {
 		log.Printf("ERROR dump failed to save data: %s\n", err.Error())
 	} else {
-		log.Printf("INFO dump successfully saved data (%s)", storeFile)
+		log.Printf("INFO dump successfully saved data (%s)", storeFile.Path)
 	}
 
 }
 
-func RestoreData(storeFile string, store structs.Storage) {
+// RestoreData loads the newest dump generation that passes validation and returns its seq
+func RestoreData(ctx context.Context, storeFile StoreFile, store structs.Storage) uint64 {
 	log.Println("INFO dump restore data from disk")
-	err := restore(storeFile, store)
+	path, seq, err := restore(ctx, storeFile, store)
 	if err != nil {
 		log.Printf("ERROR dump failed to restore data: %s\n", err.Error())
-	} else {
-		log.Printf("INFO dump successfully restored data (%s)", storeFile)
+		return 0
+	}
+	log.Printf("INFO dump successfully restored data (%s, seq %d)", path, seq)
+	return seq
+}
+
+// LastSeq returns seq of the newest valid dump, so changes of a run which does not restore data
+// are numbered after it
+func LastSeq(storeFile StoreFile) uint64 {
+	_, seq, _, err := storeFile.read()
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// ReplayWAL applies changes made after the dump with seq after and returns seq of the last change.
+// WAL must be replayed after RestoreData: restored gauges are older than the logged ones,
+// and records saved by the dump before the WAL was truncated are skipped by seq
+func ReplayWAL(ctx context.Context, walFile string, store structs.Storage, after uint64) uint64 {
+	log.Printf("INFO dump replaying WAL %s after seq %d", walFile, after)
+	n, last, err := wal.Replay(ctx, walFile, store, after)
+	if err != nil {
+		log.Printf("ERROR dump failed to replay WAL: %s", err.Error())
+	}
+	log.Printf("INFO dump replayed %d WAL records", n)
+	if last > after {
+		return last
 	}
+	return after
 }
 
 func Start(ctx context.Context, wg *sync.WaitGroup,
-	storeInterval time.Duration, storeFile string, store structs.Storage) {
+	storeInterval time.Duration, storeFile StoreFile, store *Storage) {
 	log.Println("INFO dump starting")
 	defer wg.Done()
-	ticker := time.NewTicker(storeInterval)
+	// zero interval means synchronous mode, there is nothing to dump periodically
+	var ticker <-chan time.Time
+	if storeInterval > 0 {
+		t := time.NewTicker(storeInterval)
+		defer t.Stop()
+		ticker = t.C
+	}
+	var syncTicker <-chan time.Time
+	if store.wal != nil && store.syncInterval > 0 {
+		t := time.NewTicker(store.syncInterval)
+		defer t.Stop()
+		syncTicker = t.C
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("INFO dump received ctx.Done()'. Dumping and exiting")
 			dumpData(storeFile, store)
 			return
-		case <-ticker.C:
+		case <-syncTicker:
+			err := store.wal.Sync()
+			if err != nil {
+				log.Printf("ERROR dump %s", err.Error())
+			}
+		case <-ticker:
+			dumpData(storeFile, store)
+		case <-store.changed:
+			log.Println("INFO dump metrics were deleted or reset")
 			dumpData(storeFile, store)
 		}
 	}
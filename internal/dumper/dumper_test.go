@@ -0,0 +1,286 @@
+package dumper
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"github.com/zklevsha/go-musthave-devops/internal/wal"
+)
+
+func TestStorageDumpsDeletions(t *testing.T) {
+	file := StoreFile{Path: filepath.Join(t.TempDir(), "metrics.json")}
+	store := NewStorage(structs.NewMemoryStorage(), nil, 0, 0)
+	v := 1.5
+	d := int64(3)
+	err := store.UpdateMetrics(context.Background(), []structs.Metric{{ID: "Alloc", MType: "gauge", Value: &v},
+		{ID: "PollCount", MType: "counter", Delta: &d}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dump(context.Background(), file, store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	// dumps are expected on deletion only, not by interval
+	go Start(ctx, &wg, time.Hour, file, store)
+
+	err = store.DeleteMetric(context.Background(), structs.Metric{ID: "Alloc", MType: "gauge"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.ResetCounter(context.Background(), "PollCount", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		restored := structs.NewMemoryStorage()
+		_, _, err = restore(context.Background(), file, restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, errAlloc := restored.GetMetric(context.Background(), structs.Metric{ID: "Alloc", MType: "gauge"})
+		counter, errCounter := restored.GetMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter"})
+		if errAlloc == structs.ErrMetricNotFound && errCounter == nil && *counter.Delta == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deletion was not dumped: Alloc error %v, PollCount %v (%v)", errAlloc, counter, errCounter)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestStorageWAL(t *testing.T) {
+	dir := t.TempDir()
+	file := StoreFile{Path: filepath.Join(dir, "metrics.json")}
+	walFile := filepath.Join(dir, "metrics.wal")
+	d := int64(2)
+	v := 1.5
+
+	walLog, err := wal.Open(walFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStorage(structs.NewMemoryStorage(), walLog, 0, 0)
+	err = store.UpdateMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter", Delta: &d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.save(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// changes after the dump are in the WAL only
+	err = store.UpdateMetrics(context.Background(), []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d},
+		{ID: "Alloc", MType: "gauge", Value: &v}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walLog.Close()
+
+	// restart after crash
+	restored := structs.NewMemoryStorage()
+	seq := RestoreData(context.Background(), file, restored)
+	seq = ReplayWAL(context.Background(), walFile, restored, seq)
+	counter, err := restored.GetMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *counter.Delta != 4 {
+		t.Errorf("counter mismatch: have %d, want 4", *counter.Delta)
+	}
+	if _, err := restored.GetMetric(context.Background(), structs.Metric{ID: "Alloc", MType: "gauge"}); err != nil {
+		t.Errorf("gauge was not restored: %s", err.Error())
+	}
+
+	// WAL is truncated by the dump, restored data is not counted twice
+	walLog, err = wal.Open(walFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer walLog.Close()
+	store = NewStorage(restored, walLog, 0, seq)
+	_, err = store.save(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := structs.NewMemoryStorage()
+	seq = RestoreData(context.Background(), file, again)
+	ReplayWAL(context.Background(), walFile, again, seq)
+	counter, err = again.GetMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *counter.Delta != 4 {
+		t.Errorf("counter mismatch after dump: have %d, want 4", *counter.Delta)
+	}
+}
+
+func TestReplayAfterDumpCrash(t *testing.T) {
+	dir := t.TempDir()
+	file := StoreFile{Path: filepath.Join(dir, "metrics.json")}
+	walFile := filepath.Join(dir, "metrics.wal")
+	d := int64(2)
+
+	walLog, err := wal.Open(walFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStorage(structs.NewMemoryStorage(), walLog, 0, 0)
+	for i := 0; i < 2; i++ {
+		err = store.UpdateMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter", Delta: &d})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// crash after the dump was renamed into place, but before the WAL was truncated
+	err = dump(context.Background(), file, store.Storage, store.seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.UpdateMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter", Delta: &d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walLog.Close()
+
+	restored := structs.NewMemoryStorage()
+	seq := RestoreData(context.Background(), file, restored)
+	seq = ReplayWAL(context.Background(), walFile, restored, seq)
+	counter, err := restored.GetMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *counter.Delta != 6 {
+		t.Errorf("counter mismatch: have %d, want 6", *counter.Delta)
+	}
+	if seq != 3 {
+		t.Errorf("seq mismatch: have %d, want 3", seq)
+	}
+}
+
+func TestSyncStorageDumpFailure(t *testing.T) {
+	dir := t.TempDir()
+	// dump directory does not exist, so every dump fails
+	file := StoreFile{Path: filepath.Join(dir, "missing", "metrics.json")}
+	walFile := filepath.Join(dir, "metrics.wal")
+	walLog, err := wal.Open(walFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSyncStorage(structs.NewMemoryStorage(), walLog, time.Second, 0, file)
+	d := int64(2)
+	err = store.UpdateMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter", Delta: &d})
+	if err != nil {
+		t.Fatalf("applied change was reported as failed: %s", err.Error())
+	}
+	walLog.Close()
+
+	restored := structs.NewMemoryStorage()
+	ReplayWAL(context.Background(), walFile, restored, 0)
+	counter, err := restored.GetMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *counter.Delta != 2 {
+		t.Errorf("counter mismatch: have %d, want 2", *counter.Delta)
+	}
+}
+
+func TestSyncStorageDumpFailureNoWAL(t *testing.T) {
+	// dump directory does not exist, so every dump fails
+	file := StoreFile{Path: filepath.Join(t.TempDir(), "missing", "metrics.json")}
+	store := NewSyncStorage(structs.NewMemoryStorage(), nil, 0, 0, file)
+	d := int64(2)
+	err := store.UpdateMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter", Delta: &d})
+	if err == nil {
+		t.Fatal("failed dump was not reported without WAL")
+	}
+}
+
+func TestStoreInterval(t *testing.T) {
+	tt := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{name: "synchronous", interval: 0},
+		{name: "periodic", interval: 50 * time.Millisecond},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			file := StoreFile{Path: filepath.Join(t.TempDir(), "metrics.json")}
+			var store *Storage
+			if tc.interval == 0 {
+				store = NewSyncStorage(structs.NewMemoryStorage(), nil, 0, 0, file)
+			} else {
+				store = NewStorage(structs.NewMemoryStorage(), nil, 0, 0)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go Start(ctx, &wg, tc.interval, file, store)
+
+			const writers = 10
+			d := int64(1)
+			var updates sync.WaitGroup
+			errs := make(chan error, writers)
+			for i := 0; i < writers; i++ {
+				updates.Add(1)
+				go func() {
+					defer updates.Done()
+					errs <- store.UpdateMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter", Delta: &d})
+				}()
+			}
+			updates.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			restoredCounter := func() int64 {
+				restored := structs.NewMemoryStorage()
+				_, _, err := restore(context.Background(), file, restored)
+				if err != nil {
+					return 0
+				}
+				counter, err := restored.GetMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter"})
+				if err != nil {
+					return 0
+				}
+				return *counter.Delta
+			}
+			if tc.interval == 0 {
+				// every update is on disk when the call returns
+				if have := restoredCounter(); have != writers {
+					t.Errorf("counter was not dumped synchronously: have %d, want %d", have, writers)
+				}
+			} else {
+				deadline := time.Now().Add(5 * time.Second)
+				for restoredCounter() != writers {
+					if time.Now().After(deadline) {
+						t.Fatalf("counter was not dumped by interval: have %d, want %d", restoredCounter(), writers)
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			cancel()
+			wg.Wait()
+		})
+	}
+}
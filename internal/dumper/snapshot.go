@@ -0,0 +1,181 @@
+package dumper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/zklevsha/go-musthave-devops/internal/archive"
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// snapshotVersion is the format version written to the dump header
+const snapshotVersion = 1
+
+// snapshotHeader is the first line of a dump file. Metrics JSON (gzipped if Compressed) follows it,
+// Checksum is SHA-256 of the stored payload. Seq is the last change saved by the dump (see wal.Record)
+type snapshotHeader struct {
+	Version    int    `json:"version"`
+	Seq        uint64 `json:"seq"`
+	Compressed bool   `json:"compressed"`
+	Checksum   string `json:"sha256"`
+}
+
+// StoreFile is the dump file. Dumps are written to a temporary file renamed over Path,
+// so a crash mid-write leaves the previous dump intact.
+// Generations dumps are kept: Path is the newest one, Path.1 is the previous one and so on
+type StoreFile struct {
+	Path        string
+	Generations int
+	Compress    bool
+}
+
+// generation returns path of the i-th newest dump
+func (f StoreFile) generation(i int) string {
+	if i == 0 {
+		return f.Path
+	}
+	return fmt.Sprintf("%s.%d", f.Path, i)
+}
+
+func (f StoreFile) generations() int {
+	if f.Generations < 1 {
+		return 1
+	}
+	return f.Generations
+}
+
+// encodeSnapshot prepends the header to metrics JSON
+func encodeSnapshot(metrics []byte, compress bool, seq uint64) ([]byte, error) {
+	payload := metrics
+	if compress {
+		var err error
+		payload, err = archive.Compress(metrics)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sum := sha256.Sum256(payload)
+	header, err := json.Marshal(snapshotHeader{Version: snapshotVersion, Seq: seq, Compressed: compress,
+		Checksum: hex.EncodeToString(sum[:])})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode dump header: %s", err.Error())
+	}
+	return append(append(header, '\n'), payload...), nil
+}
+
+// decodeSnapshot validates the dump and returns metrics JSON and seq of the dump.
+// Files without header (written before the header was introduced) are returned as is with zero seq
+func decodeSnapshot(data []byte) ([]byte, uint64, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return data, 0, nil
+	}
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, 0, errors.New("dump header is missing")
+	}
+	var header snapshotHeader
+	err := json.Unmarshal(data[:i], &header)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decode dump header: %s", err.Error())
+	}
+	if header.Version != snapshotVersion {
+		return nil, 0, fmt.Errorf("unsupported dump format version %d", header.Version)
+	}
+	payload := data[i+1:]
+	sum := sha256.Sum256(payload)
+	if hex.EncodeToString(sum[:]) != header.Checksum {
+		return nil, 0, errors.New("dump checksum mismatch")
+	}
+	if header.Compressed {
+		payload, err = archive.Decompress(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return payload, header.Seq, nil
+}
+
+// write atomically replaces the newest dump with data, shifting older generations
+func (f StoreFile) write(data []byte) error {
+	dir := filepath.Dir(f.Path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(f.Path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary dump file: %s", err.Error())
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write dump file %s: %s", tmp.Name(), err.Error())
+	}
+
+	for i := f.generations() - 1; i > 0; i-- {
+		err = os.Rename(f.generation(i-1), f.generation(i))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmp.Name())
+			return fmt.Errorf("failed to rotate dump file %s: %s", f.generation(i-1), err.Error())
+		}
+	}
+	err = os.Rename(tmp.Name(), f.Path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to rename dump file %s: %s", tmp.Name(), err.Error())
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes renames in the directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open dump directory %s: %s", dir, err.Error())
+	}
+	defer d.Close()
+	err = d.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync dump directory %s: %s", dir, err.Error())
+	}
+	return nil
+}
+
+// read returns metrics and seq of the newest valid dump and its path. Invalid dumps are skipped
+func (f StoreFile) read() ([]structs.Metric, uint64, string, error) {
+	for i := 0; i < f.generations(); i++ {
+		path := f.generation(i)
+		data, err := ioutil.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("WARN dump failed to read %s: %s", path, err.Error())
+			continue
+		}
+		payload, seq, err := decodeSnapshot(data)
+		if err != nil {
+			log.Printf("WARN dump %s is invalid: %s", path, err.Error())
+			continue
+		}
+		metrics := []structs.Metric{}
+		err = json.Unmarshal(payload, &metrics)
+		if err != nil {
+			log.Printf("WARN dump %s is invalid: failed to unmarshall json to serializer.Metrics: %s",
+				path, err.Error())
+			continue
+		}
+		return metrics, seq, path, nil
+	}
+	return nil, 0, "", fmt.Errorf("no valid dump file found at %s", f.Path)
+}
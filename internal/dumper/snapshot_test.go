@@ -0,0 +1,131 @@
+package dumper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// dumpGauge dumps storage holding single Alloc gauge
+func dumpGauge(t *testing.T, f StoreFile, v float64) {
+	store := structs.NewMemoryStorage()
+	err := store.UpdateMetric(context.Background(), structs.Metric{ID: "Alloc", MType: "gauge", Value: &v})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dump(context.Background(), f, store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func restoredGauge(t *testing.T, f StoreFile) (float64, string) {
+	store := structs.NewMemoryStorage()
+	path, _, err := restore(context.Background(), f, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := store.GetMetric(context.Background(), structs.Metric{ID: "Alloc", MType: "gauge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *m.Value, path
+}
+
+func TestStoreFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	f := StoreFile{Path: filepath.Join(dir, "metrics.json"), Generations: 3}
+	for _, v := range []float64{1, 2, 3, 4} {
+		dumpGauge(t, f, v)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	if len(names) != 3 {
+		t.Fatalf("unexpected files (temporary files are left or generations are not rotated): %v", names)
+	}
+	for i, want := range []float64{4, 3, 2} {
+		v, _ := restoredGauge(t, StoreFile{Path: f.generation(i)})
+		if v != want {
+			t.Errorf("generation %d value mismatch: have %f, want %f", i, v, want)
+		}
+	}
+}
+
+func TestRestoreFallback(t *testing.T) {
+	tt := []struct {
+		name     string
+		compress bool
+		corrupt  func(path string) error
+		want     float64
+		wantGen  int
+	}{
+		{name: "valid", want: 2, wantGen: 0},
+		{name: "valid compressed", compress: true, want: 2, wantGen: 0},
+		{name: "checksum mismatch", want: 1, wantGen: 1,
+			corrupt: func(path string) error {
+				data, err := ioutil.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				data[len(data)-2] ^= 0xff
+				return ioutil.WriteFile(path, data, 0644)
+			}},
+		{name: "torn compressed", compress: true, want: 1, wantGen: 1,
+			corrupt: func(path string) error {
+				return os.Truncate(path, 20)
+			}},
+		{name: "newest missing", want: 1, wantGen: 1,
+			corrupt: os.Remove},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			f := StoreFile{Path: filepath.Join(t.TempDir(), "metrics.json"), Generations: 2, Compress: tc.compress}
+			dumpGauge(t, f, 1)
+			dumpGauge(t, f, 2)
+			if tc.corrupt != nil {
+				err := tc.corrupt(f.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			v, path := restoredGauge(t, f)
+			if v != tc.want || path != f.generation(tc.wantGen) {
+				t.Errorf("restored mismatch: have %f from %s, want %f from %s", v, path, tc.want, f.generation(tc.wantGen))
+			}
+		})
+	}
+}
+
+func TestRestoreLegacyFile(t *testing.T) {
+	f := StoreFile{Path: filepath.Join(t.TempDir(), "metrics.json")}
+	err := ioutil.WriteFile(f.Path, []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := restoredGauge(t, f)
+	if v != 1.5 {
+		t.Errorf("value mismatch: have %f, want 1.5", v)
+	}
+}
+
+func TestRestoreNoValidFile(t *testing.T) {
+	f := StoreFile{Path: filepath.Join(t.TempDir(), "metrics.json"), Generations: 2}
+	err := ioutil.WriteFile(f.Path, []byte("{\"version\":2}\n[]"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = restore(context.Background(), f, structs.NewMemoryStorage())
+	if err == nil {
+		t.Error("restore of unsupported version did not fail")
+	}
+}
@@ -0,0 +1,210 @@
+// Package graphite implements Graphite plaintext protocol listener (`path value timestamp` lines over TCP)
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// batchSize is the maximum number of metrics written to storage at once
+const batchSize = 100
+
+// maxLineSize is the maximum length of a line, longer lines are skipped
+const maxLineSize = 4096
+
+type Server struct {
+	listener net.Listener
+	store    structs.Storage
+	// counters are path.Match patterns of metric paths stored as counters
+	counters []string
+	// connections from other networks are closed right after accept
+	trustedSubnet net.IPNet
+
+	connsMx sync.Mutex
+	conns   map[net.Conn]struct{}
+}
+
+// Listen binds TCP socket. Paths matching counters patterns are stored as counters
+// (values are added as deltas), other paths are stored as gauges.
+// Only clients from trustedSubnet are served
+func Listen(address string, counters []string, trustedSubnet net.IPNet, store structs.Storage) (*Server, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{listener: l, store: store, counters: counters, trustedSubnet: trustedSubnet,
+		conns: map[net.Conn]struct{}{}}, nil
+}
+
+// Addr returns the address the listener is bound to
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Start accepts connections until ctx.Done(). On shutdown the listener and open connections are closed,
+// metrics received so far are written to the storage
+func (s *Server) Start(ctx context.Context, wg *sync.WaitGroup) {
+	log.Printf("INFO graphite starting at %s", s.Addr())
+	defer wg.Done()
+
+	var conns sync.WaitGroup
+	go func() {
+		<-ctx.Done()
+		log.Println("INFO graphite received ctx.Done(), closing connections")
+		s.listener.Close()
+		s.connsMx.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.connsMx.Unlock()
+	}()
+
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("ERROR graphite accept failed: %s", err.Error())
+			}
+			break
+		}
+		err = s.checkSubnet(c.RemoteAddr())
+		if err != nil {
+			log.Printf("WARN graphite rejected connection: %s", err.Error())
+			c.Close()
+			continue
+		}
+		s.connsMx.Lock()
+		s.conns[c] = struct{}{}
+		s.connsMx.Unlock()
+		conns.Add(1)
+		go s.serveConn(c, &conns)
+	}
+	conns.Wait()
+	log.Println("INFO graphite stopped")
+}
+
+func (s *Server) serveConn(c net.Conn, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer func() {
+		s.connsMx.Lock()
+		delete(s.conns, c)
+		s.connsMx.Unlock()
+		c.Close()
+	}()
+
+	var batch []structs.Metric
+	r := bufio.NewReaderSize(c, maxLineSize)
+	for {
+		raw, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			log.Printf("WARN graphite skipping line from %s: line is longer than %d bytes", c.RemoteAddr(), maxLineSize)
+			raw = nil
+			err = skipLine(r)
+		}
+		if line := strings.TrimSpace(string(raw)); line != "" {
+			m, parseErr := s.parseLine(line)
+			if parseErr != nil {
+				log.Printf("WARN graphite skipping line from %s: %s", c.RemoteAddr(), parseErr.Error())
+			} else if m != nil {
+				batch = append(batch, *m)
+			}
+		}
+		// writing when batch is full or there is nothing more to read right now
+		if len(batch) >= batchSize || len(batch) > 0 && (r.Buffered() == 0 || err != nil) {
+			s.write(batch)
+			batch = nil
+		}
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("WARN graphite connection %s failed: %s", c.RemoteAddr(), err.Error())
+			}
+			return
+		}
+	}
+}
+
+// checkSubnet checks if client address belongs to trusted subnet
+func (s *Server) checkSubnet(addr net.Addr) error {
+	if s.trustedSubnet.String() == "0.0.0.0/0" {
+		return nil
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("failed to get IP address of %s", addr)
+	}
+	if !s.trustedSubnet.Contains(tcpAddr.IP) {
+		return fmt.Errorf("%s does not belong to trusted network %s", tcpAddr.IP, s.trustedSubnet.String())
+	}
+	return nil
+}
+
+// skipLine discards the rest of the line which did not fit into the reader buffer
+func skipLine(r *bufio.Reader) error {
+	for {
+		_, err := r.ReadSlice('\n')
+		if err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// write stores received metrics. Writes are not cancelled on shutdown: metrics are already accepted
+func (s *Server) write(metrics []structs.Metric) {
+	err := s.store.UpdateMetrics(context.Background(), metrics)
+	if err != nil {
+		log.Printf("ERROR graphite failed to write %d metrics to storage: %s", len(metrics), err.Error())
+	}
+}
+
+func (s *Server) isCounter(metricPath string) bool {
+	for _, p := range s.counters {
+		if ok, _ := path.Match(p, metricPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLine parses `path value [timestamp]` line. Timestamp is validated but not stored.
+// NaN values (sent by collectd for unknown values) are skipped, nil metric is returned for them
+func (s *Server) parseLine(line string) (*structs.Metric, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("%w: line '%s' must have path, value and timestamp",
+			structs.ErrMetricNullAttr, line)
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: line '%s' has bad value: %s",
+			structs.ErrMetricBadAttrValue, line, err.Error())
+	}
+	if len(fields) == 3 {
+		_, err = strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line '%s' has bad timestamp: %s",
+				structs.ErrMetricBadAttrValue, line, err.Error())
+		}
+	}
+	if math.IsNaN(value) {
+		return nil, nil
+	}
+
+	id := fields[0]
+	if s.isCounter(id) {
+		delta := int64(math.Round(value))
+		return &structs.Metric{ID: id, MType: "counter", Delta: &delta}, nil
+	}
+	return &structs.Metric{ID: id, MType: "gauge", Value: &value}, nil
+}
@@ -0,0 +1,153 @@
+package graphite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+func TestParseLine(t *testing.T) {
+	s := &Server{counters: []string{"*.requests"}}
+	tt := []struct {
+		name      string
+		line      string
+		wantType  string
+		wantValue float64
+		wantNil   bool
+		wantErr   error
+	}{
+		{name: "gauge", line: "web01.cpu.load 0.75 1700000000", wantType: "gauge", wantValue: 0.75},
+		{name: "counter by pattern", line: "web01.requests 12 1700000000", wantType: "counter", wantValue: 12},
+		{name: "no timestamp", line: "web01.cpu.load 1", wantType: "gauge", wantValue: 1},
+		{name: "NaN is skipped", line: "web01.cpu.load nan 1700000000", wantNil: true},
+		{name: "no value", line: "web01.cpu.load", wantErr: structs.ErrMetricNullAttr},
+		{name: "bad value", line: "web01.cpu.load high 1700000000", wantErr: structs.ErrMetricBadAttrValue},
+		{name: "bad timestamp", line: "web01.cpu.load 1 now", wantErr: structs.ErrMetricBadAttrValue},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := s.parseLine(tc.line)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if tc.wantNil {
+				if m != nil {
+					t.Errorf("metric must be skipped, have %v", m)
+				}
+				return
+			}
+			if m.MType != tc.wantType {
+				t.Fatalf("type mismatch: have %s, want %s", m.MType, tc.wantType)
+			}
+			var value float64
+			if m.MType == "counter" {
+				value = float64(*m.Delta)
+			} else {
+				value = *m.Value
+			}
+			if value != tc.wantValue {
+				t.Errorf("value mismatch: have %f, want %f", value, tc.wantValue)
+			}
+		})
+	}
+}
+
+var anySubnet = net.IPNet{IP: net.IPv4(0, 0, 0, 0), Mask: net.IPv4Mask(0, 0, 0, 0)}
+
+func TestServer(t *testing.T) {
+	store := structs.NewMemoryStorage()
+	s, err := Listen("127.0.0.1:0", []string{"*.requests"}, anySubnet, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go s.Start(ctx, &wg)
+
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// too long line is skipped, the following lines are read
+	long := "web01.long" + strings.Repeat("0", 2*maxLineSize) + " 1 1700000000\n"
+	_, err = fmt.Fprint(conn, "web01.requests 3 1700000000\n"+long+
+		"web01.requests 4 1700000010\nweb01.load 0.5 1700000000\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// waiting for metrics to be stored
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err = store.GetMetric(context.Background(), structs.Metric{ID: "web01.load", MType: "gauge"})
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// open connection must not block shutdown
+	cancel()
+	wg.Wait()
+
+	m, err := store.GetMetric(context.Background(), structs.Metric{ID: "web01.requests", MType: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *m.Delta != 7 {
+		t.Errorf("counter mismatch: have %d, want 7", *m.Delta)
+	}
+	m, err = store.GetMetric(context.Background(), structs.Metric{ID: "web01.load", MType: "gauge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *m.Value != 0.5 {
+		t.Errorf("gauge mismatch: have %f, want 0.5", *m.Value)
+	}
+}
+
+func TestServerUntrustedSubnet(t *testing.T) {
+	store := structs.NewMemoryStorage()
+	trusted := net.IPNet{IP: net.IPv4(192, 168, 23, 0), Mask: net.IPv4Mask(255, 255, 255, 0)}
+	s, err := Listen("127.0.0.1:0", nil, trusted, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go s.Start(ctx, &wg)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "web01.load 0.5 1700000000\n")
+	// connection is closed by the server without reading
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("connection from untrusted network was not closed")
+	}
+	_, err = store.GetMetric(context.Background(), structs.Metric{ID: "web01.load", MType: "gauge"})
+	if !errors.Is(err, structs.ErrMetricNotFound) {
+		t.Errorf("metric from untrusted network was stored: %v", err)
+	}
+}
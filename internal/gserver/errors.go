@@ -0,0 +1,64 @@
+package gserver
+
+import (
+	"errors"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errInvalidHash = errors.New("invalid hash value")
+
+// errDomain is set in ErrorInfo details of returned errors
+const errDomain = "devops-metrics"
+
+// errCodes maps storage and serializer errors to gRPC codes (same as handlers.GetErrStatusCode for HTTP)
+// and to reasons reported in ErrorInfo details
+var errCodes = []struct {
+	err    error
+	code   codes.Code
+	reason string
+}{
+	{err: structs.ErrMetricNotFound, code: codes.NotFound, reason: "METRIC_NOT_FOUND"},
+	{err: structs.ErrMetricAmbiguous, code: codes.FailedPrecondition, reason: "METRIC_AMBIGUOUS"},
+	{err: structs.ErrMetricBadType, code: codes.Unimplemented, reason: "METRIC_BAD_TYPE"},
+	{err: structs.ErrMetricNullAttr, code: codes.InvalidArgument, reason: "METRIC_NULL_ATTR"},
+	{err: structs.ErrMetricBadAttrValue, code: codes.InvalidArgument, reason: "METRIC_BAD_ATTR_VALUE"},
+	{err: structs.ErrBadResolution, code: codes.InvalidArgument, reason: "BAD_RESOLUTION"},
+	{err: structs.ErrHistoryDisabled, code: codes.Unimplemented, reason: "HISTORY_DISABLED"},
+	{err: structs.ErrStorageUnavailable, code: codes.Unavailable, reason: "STORAGE_UNAVAILABLE"},
+	{err: errInvalidHash, code: codes.InvalidArgument, reason: "INVALID_HASH"},
+	{err: structs.ErrAccessDenied, code: codes.PermissionDenied, reason: "ACCESS_DENIED"},
+}
+
+func GetErrStatusCode(err error) codes.Code {
+	for _, e := range errCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return codes.Internal
+}
+
+func errReason(err error) string {
+	for _, e := range errCodes {
+		if errors.Is(err, e.err) {
+			return e.reason
+		}
+	}
+	return "INTERNAL"
+}
+
+// statusError converts err to gRPC status error with ErrorInfo details describing the metric
+func statusError(err error, id string, mtype string) error {
+	st := status.New(GetErrStatusCode(err), err.Error())
+	info := &errdetails.ErrorInfo{Reason: errReason(err), Domain: errDomain,
+		Metadata: map[string]string{"id": id, "type": mtype}}
+	detailed, detailsErr := st.WithDetails(info)
+	if detailsErr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -3,48 +3,234 @@ package gserver
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
+	"time"
 
+	"github.com/zklevsha/go-musthave-devops/internal/config"
+	"github.com/zklevsha/go-musthave-devops/internal/otlp"
 	"github.com/zklevsha/go-musthave-devops/internal/pb"
 	"github.com/zklevsha/go-musthave-devops/internal/serializer"
 	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	colpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 type server struct {
 	pb.UnimplementedMonitoringServer
 	Storage structs.Storage
+	// key to sign returned metrics with
+	key string
 }
 
 func (s *server) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
 	log.Printf("INFO Received gRPC request: %v, params: %s", in.ProtoReflect().Descriptor().FullName(), in.String())
 	m, err := serializer.DecodeGRPCMetric(in.Metric)
 	if err != nil {
-		response := pb.Response{Message: "", Error: err.Error()}
-		return &pb.UpdateMetricResponse{Response: &response}, nil
+		return nil, statusError(err, in.Metric.GetId(), in.Metric.GetMtype())
 	}
 
-	err = s.Storage.UpdateMetric(m)
+	err = s.Storage.UpdateMetric(structs.AgentContext(ctx), m)
 	if err != nil {
-		response := pb.Response{Message: "", Error: err.Error()}
-		return &pb.UpdateMetricResponse{Response: &response}, nil
+		return nil, statusError(err, m.ID, m.MType)
 	}
 
-	response := pb.Response{Message: "Metric was updated", Error: ""}
+	response := pb.Response{Message: "Metric was updated"}
 	return &pb.UpdateMetricResponse{Response: &response}, nil
 }
 
-func Start(socket string, store structs.Storage) {
-	listener, err := net.Listen("tcp", socket)
+// updateMetrics decodes and saves metrics. Metrics which can not be decoded are returned as per-metric errors,
+// storage failure fails the whole request
+func (s *server) updateMetrics(ctx context.Context, in []*pb.Metric) (*pb.UpdateMetricsResponse, error) {
+	var metrics []structs.Metric
+	var errs []*pb.MetricError
+	for _, pm := range in {
+		m, err := serializer.DecodeGRPCMetric(pm)
+		if err != nil {
+			errs = append(errs, metricError(pm, err))
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+
+	if len(metrics) > 0 {
+		err := s.Storage.UpdateMetrics(structs.AgentContext(ctx), metrics)
+		if err != nil {
+			return nil, statusError(err, "", "")
+		}
+	}
+
+	response := pb.Response{Message: fmt.Sprintf("%d of %d metrics were updated", len(metrics), len(in))}
+	return &pb.UpdateMetricsResponse{Response: &response, Errors: errs}, nil
+}
+
+func metricError(pm *pb.Metric, err error) *pb.MetricError {
+	code := GetErrStatusCode(err)
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		code = st.Code()
+	}
+	return &pb.MetricError{Id: pm.GetId(), Mtype: pm.GetMtype(), Labels: pm.GetLabels(),
+		Error: err.Error(), Code: int32(code)}
+}
+
+func (s *server) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	log.Printf("INFO Received gRPC request: %v, metrics: %d", in.ProtoReflect().Descriptor().FullName(), len(in.Metrics))
+	return s.updateMetrics(ctx, in.Metrics)
+}
+
+// StreamMetrics saves metrics as they arrive and responds with per-metric errors when client closes the stream
+func (s *server) StreamMetrics(stream pb.Monitoring_StreamMetricsServer) error {
+	log.Printf("INFO Received gRPC stream: StreamMetrics")
+	var received int
+	var errs []*pb.MetricError
+	for {
+		in := &pb.UpdateMetricRequest{}
+		// RecvMsg is used instead of Recv to keep the message when interceptor rejects it
+		err := stream.RecvMsg(in)
+		if err == io.EOF {
+			break
+		}
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			received++
+			errs = append(errs, metricError(in.Metric, err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		received++
+		resp, err := s.updateMetrics(stream.Context(), []*pb.Metric{in.Metric})
+		if err != nil {
+			return err
+		}
+		errs = append(errs, resp.Errors...)
+	}
+
+	log.Printf("INFO StreamMetrics received %d metrics", received)
+	response := pb.Response{Message: fmt.Sprintf("%d of %d metrics were updated", received-len(errs), received)}
+	return stream.SendAndClose(&pb.UpdateMetricsResponse{Response: &response, Errors: errs})
+}
+
+func (s *server) GetMetric(ctx context.Context, in *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	log.Printf("INFO Received gRPC request: %v, params: %s", in.ProtoReflect().Descriptor().FullName(), in.String())
+	m, err := s.Storage.GetMetric(ctx, structs.Metric{ID: in.Id, MType: in.Mtype, Labels: labels(in.Labels)})
 	if err != nil {
-		panic(err)
+		return nil, statusError(err, in.Id, in.Mtype)
+	}
+	if s.key != "" {
+		m.SetHash(s.key)
+	}
+	out, err := serializer.EncodeGRPCMetric(m)
+	if err != nil {
+		return nil, statusError(err, in.Id, in.Mtype)
+	}
+	return &pb.GetMetricResponse{Metric: out, Response: &pb.Response{}}, nil
+}
+
+func (s *server) ListMetrics(ctx context.Context, in *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	log.Printf("INFO Received gRPC request: %v", in.ProtoReflect().Descriptor().FullName())
+	metrics, err := s.Storage.GetMetrics(ctx)
+	if err != nil {
+		return nil, statusError(err, "", "")
+	}
+	if s.key != "" {
+		for i := range metrics {
+			metrics[i].SetHash(s.key)
+		}
+	}
+	out, err := serializer.EncodeGRPCMetrics(metrics)
+	if err != nil {
+		return nil, statusError(err, "", "")
+	}
+	return &pb.ListMetricsResponse{Metrics: out, Response: &pb.Response{}}, nil
+}
+
+func (s *server) GetMetricHistory(ctx context.Context, in *pb.GetMetricHistoryRequest) (*pb.GetMetricHistoryResponse, error) {
+	log.Printf("INFO Received gRPC request: %v, params: %s", in.ProtoReflect().Descriptor().FullName(), in.String())
+	m := structs.Metric{ID: in.Id, MType: in.Mtype, Labels: labels(in.Labels)}
+	from := time.Time{}
+	if in.From != nil {
+		from = in.From.AsTime()
+	}
+	to := time.Now()
+	if in.To != nil {
+		to = in.To.AsTime()
+	}
+
+	samples, err := s.Storage.GetMetricHistory(ctx, m, from, to)
+	if err != nil {
+		return nil, statusError(err, in.Id, in.Mtype)
+	}
+
+	return &pb.GetMetricHistoryResponse{Samples: serializer.EncodeGRPCSamples(samples),
+		Response: &pb.Response{}}, nil
+}
+
+func (s *server) DeleteMetric(ctx context.Context, in *pb.DeleteMetricRequest) (*pb.DeleteMetricResponse, error) {
+	log.Printf("INFO Received gRPC request: %v, params: %s", in.ProtoReflect().Descriptor().FullName(), in.String())
+	matchers, err := structs.AdminRequest{Match: in.Match}.Matchers()
+	if err == nil {
+		err = s.Storage.DeleteMetric(ctx, structs.Metric{ID: in.Id, MType: in.Mtype}, matchers)
+	}
+	if err != nil {
+		return nil, statusError(err, in.Id, in.Mtype)
+	}
+	return &pb.DeleteMetricResponse{Response: &pb.Response{Message: "metric was deleted"}}, nil
+}
+
+func (s *server) ResetCounter(ctx context.Context, in *pb.ResetCounterRequest) (*pb.ResetCounterResponse, error) {
+	log.Printf("INFO Received gRPC request: %v, params: %s", in.ProtoReflect().Descriptor().FullName(), in.String())
+	matchers, err := structs.AdminRequest{Match: in.Match}.Matchers()
+	if err == nil {
+		err = s.Storage.ResetCounter(ctx, in.Id, matchers)
+	}
+	if err != nil {
+		return nil, statusError(err, in.Id, "counter")
+	}
+	return &pb.ResetCounterResponse{Response: &pb.Response{Message: "counter was reset"}}, nil
+}
+
+// labels converts request labels, empty map means the series without labels
+func labels(in map[string]string) structs.Labels {
+	if len(in) == 0 {
+		return nil
 	}
+	return structs.Labels(in)
+}
 
-	s := grpc.NewServer()
+func newServer(conf config.ServerConfig, store structs.Storage, tlsConf *tls.Config) *grpc.Server {
+	i := &interceptors{key: conf.Key, trustedSubnet: conf.TrustedSubnet}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(i.unary),
+		grpc.StreamInterceptor(i.stream),
+	}
+	if tlsConf != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	}
+	s := grpc.NewServer(opts...)
 	reflection.Register(s)
-	pb.RegisterMonitoringServer(s, &server{Storage: store})
+	pb.RegisterMonitoringServer(s, &server{Storage: store, key: conf.Key})
+	colpb.RegisterMetricsServiceServer(s, &otlpServer{receiver: otlp.NewReceiver(store)})
+	return s
+}
+
+// Start starts gRPC server at conf.GRPCAddress. If tlsConf is not nil connections are served over TLS
+func Start(conf config.ServerConfig, store structs.Storage, tlsConf *tls.Config) {
+	listener, err := net.Listen("tcp", conf.GRPCAddress)
+	if err != nil {
+		panic(err)
+	}
+
+	s := newServer(conf, store, tlsConf)
 	if err := s.Serve(listener); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
@@ -0,0 +1,291 @@
+package gserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/config"
+	"github.com/zklevsha/go-musthave-devops/internal/pb"
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func gaugeMetric(id string, value float64, key string) *pb.Metric {
+	m := structs.Metric{ID: id, MType: "gauge", Value: &value}
+	if key != "" {
+		m.SetHash(key)
+	}
+	return &pb.Metric{Id: m.ID, Mtype: m.MType, Value: *m.Value, Hash: m.Hash}
+}
+
+func TestUpdateMetrics(t *testing.T) {
+	client := startServer(t, config.ServerConfig{TrustedSubnet: anySubnet})
+	ctx := context.Background()
+
+	resp, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		signedMetric(""),
+		gaugeMetric("Alloc", 1.5, ""),
+		{Id: "Bad", Mtype: "histogram", Labels: map[string]string{"host": "a"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Id != "Bad" {
+		t.Fatalf("per-metric errors mismatch: have %v, want error for Bad", resp.Errors)
+	}
+	if have := codes.Code(resp.Errors[0].Code); have != codes.Unimplemented {
+		t.Errorf("per-metric error code mismatch: have %s, want %s", have, codes.Unimplemented)
+	}
+	if have := resp.Errors[0].Labels["host"]; have != "a" {
+		t.Errorf("per-metric error labels mismatch: have %v, want host=a", resp.Errors[0].Labels)
+	}
+
+	got, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Alloc", Mtype: "gauge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Metric.GetValue() != 1.5 {
+		t.Errorf("Alloc value mismatch: have %f, want 1.5", got.Metric.GetValue())
+	}
+
+	list, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Metrics) != 2 {
+		t.Errorf("metrics count mismatch: have %d, want 2", len(list.Metrics))
+	}
+}
+
+func TestGetMetric(t *testing.T) {
+	key := "secret"
+	client := startServer(t, config.ServerConfig{Key: key, TrustedSubnet: anySubnet})
+	ctx := context.Background()
+	_, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: gaugeMetric("Alloc", 2.5, key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name   string
+		req    *pb.GetMetricRequest
+		want   codes.Code
+		reason string
+	}{
+		{name: "existing metric", req: &pb.GetMetricRequest{Id: "Alloc", Mtype: "gauge"}, want: codes.OK},
+		{name: "unknown metric", req: &pb.GetMetricRequest{Id: "Unknown", Mtype: "gauge"},
+			want: codes.NotFound, reason: "METRIC_NOT_FOUND"},
+		{name: "bad type", req: &pb.GetMetricRequest{Id: "Alloc", Mtype: "histogram"},
+			want: codes.Unimplemented, reason: "METRIC_BAD_TYPE"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := client.GetMetric(ctx, tc.req)
+			st := status.Convert(err)
+			if st.Code() != tc.want {
+				t.Fatalf("status code mismatch: have %s, want %s", st.Code(), tc.want)
+			}
+			if tc.want != codes.OK {
+				checkErrorInfo(t, st, tc.reason, tc.req.Id)
+				return
+			}
+			if want := gaugeMetric("Alloc", 2.5, key); resp.Metric.Hash != want.Hash {
+				t.Errorf("hash mismatch: have %s, want %s", resp.Metric.Hash, want.Hash)
+			}
+		})
+	}
+}
+
+// signedDelete and signedReset return administrative requests signed with key now
+func signedDelete(id, mtype, key string, match ...string) *pb.DeleteMetricRequest {
+	a := structs.AdminRequest{Action: structs.AdminDelete, ID: id, MType: mtype, Match: match,
+		Timestamp: time.Now().Unix()}
+	return &pb.DeleteMetricRequest{Id: id, Mtype: mtype, Match: match, Timestamp: a.Timestamp,
+		Hash: a.CalculateHash(key)}
+}
+
+func signedReset(id, key string, match ...string) *pb.ResetCounterRequest {
+	a := structs.AdminRequest{Action: structs.AdminReset, ID: id, MType: "counter", Match: match,
+		Timestamp: time.Now().Unix()}
+	return &pb.ResetCounterRequest{Id: id, Match: match, Timestamp: a.Timestamp, Hash: a.CalculateHash(key)}
+}
+
+func TestDeleteMetric(t *testing.T) {
+	key := "secret"
+	client := startServer(t, config.ServerConfig{Key: key, TrustedSubnet: anySubnet})
+	ctx := context.Background()
+	metrics := []*pb.Metric{signedMetric(key), gaugeMetric("Alloc", 1.5, key)}
+	for _, host := range []string{"web1", "web2"} {
+		delta := int64(3)
+		m := structs.Metric{ID: "requests", MType: "counter", Delta: &delta, Labels: structs.Labels{"host": host}}
+		m.SetHash(key)
+		metrics = append(metrics, &pb.Metric{Id: m.ID, Mtype: m.MType, Delta: delta, Labels: m.Labels, Hash: m.Hash})
+	}
+	_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: metrics})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.DeleteMetric(ctx, &pb.DeleteMetricRequest{Id: "Alloc", Mtype: "gauge"})
+	if st := status.Convert(err); st.Code() != codes.PermissionDenied {
+		t.Fatalf("unsigned delete status mismatch: have %s, want %s", st.Code(), codes.PermissionDenied)
+	}
+	stale := structs.AdminRequest{Action: structs.AdminDelete, ID: "Alloc", MType: "gauge",
+		Timestamp: time.Now().Add(-time.Hour).Unix()}
+	_, err = client.DeleteMetric(ctx, &pb.DeleteMetricRequest{Id: "Alloc", Mtype: "gauge",
+		Timestamp: stale.Timestamp, Hash: stale.CalculateHash(key)})
+	if st := status.Convert(err); st.Code() != codes.PermissionDenied {
+		t.Fatalf("replayed delete status mismatch: have %s, want %s", st.Code(), codes.PermissionDenied)
+	}
+	_, err = client.DeleteMetric(ctx, signedDelete("Alloc", "gauge", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Alloc", Mtype: "gauge"})
+	if st := status.Convert(err); st.Code() != codes.NotFound {
+		t.Errorf("deleted metric status mismatch: have %s, want %s", st.Code(), codes.NotFound)
+	}
+	_, err = client.DeleteMetric(ctx, signedDelete("Alloc", "gauge", key))
+	st := status.Convert(err)
+	if st.Code() != codes.NotFound {
+		t.Fatalf("second delete status mismatch: have %s, want %s", st.Code(), codes.NotFound)
+	}
+	checkErrorInfo(t, st, "METRIC_NOT_FOUND", "Alloc")
+
+	_, err = client.ResetCounter(ctx, signedReset("PollCount", "other"))
+	if st := status.Convert(err); st.Code() != codes.PermissionDenied {
+		t.Fatalf("reset signed with other key status mismatch: have %s, want %s", st.Code(), codes.PermissionDenied)
+	}
+	_, err = client.ResetCounter(ctx, signedReset("PollCount", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Mtype: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Metric.GetDelta() != 0 {
+		t.Errorf("PollCount value mismatch: have %d, want 0", got.Metric.GetDelta())
+	}
+	_, err = client.ResetCounter(ctx, signedReset("Unknown", key))
+	if st := status.Convert(err); st.Code() != codes.NotFound {
+		t.Errorf("unknown counter reset status mismatch: have %s, want %s", st.Code(), codes.NotFound)
+	}
+
+	_, err = client.ResetCounter(ctx, signedReset("requests", key, "host=web1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.DeleteMetric(ctx, signedDelete("requests", "counter", key, "host=web2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "requests", Mtype: "counter",
+		Labels: map[string]string{"host": "web1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Metric.GetDelta() != 0 {
+		t.Errorf("requests{host=web1} value mismatch: have %d, want 0", got.Metric.GetDelta())
+	}
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "requests", Mtype: "counter",
+		Labels: map[string]string{"host": "web2"}})
+	if st := status.Convert(err); st.Code() != codes.NotFound {
+		t.Errorf("deleted series status mismatch: have %s, want %s", st.Code(), codes.NotFound)
+	}
+}
+
+func TestDeleteMetricNoAuth(t *testing.T) {
+	client := startServer(t, config.ServerConfig{TrustedSubnet: anySubnet})
+	ctx := context.Background()
+	_, err := client.DeleteMetric(ctx, &pb.DeleteMetricRequest{Id: "Alloc", Mtype: "gauge"})
+	st := status.Convert(err)
+	if st.Code() != codes.PermissionDenied {
+		t.Fatalf("delete status mismatch: have %s, want %s", st.Code(), codes.PermissionDenied)
+	}
+	checkErrorInfo(t, st, "ACCESS_DENIED", "Alloc")
+	_, err = client.ResetCounter(ctx, &pb.ResetCounterRequest{Id: "PollCount"})
+	if st := status.Convert(err); st.Code() != codes.PermissionDenied {
+		t.Errorf("reset status mismatch: have %s, want %s", st.Code(), codes.PermissionDenied)
+	}
+}
+
+func checkErrorInfo(t *testing.T, st *status.Status, reason string, id string) {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			if info.Reason != reason || info.Metadata["id"] != id {
+				t.Errorf("ErrorInfo mismatch: have %s/%s, want %s/%s",
+					info.Reason, info.Metadata["id"], reason, id)
+			}
+			return
+		}
+	}
+	t.Errorf("ErrorInfo details are not attached to %v", st.Err())
+}
+
+func TestGetErrStatusCode(t *testing.T) {
+	tt := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{name: "metric not found", err: structs.ErrMetricNotFound, want: codes.NotFound},
+		{name: "bad type", err: structs.ErrMetricBadType, want: codes.Unimplemented},
+		{name: "null attribute", err: structs.ErrMetricNullAttr, want: codes.InvalidArgument},
+		{name: "bad attribute value", err: structs.ErrMetricBadAttrValue, want: codes.InvalidArgument},
+		{name: "storage unavailable",
+			err: fmt.Errorf("%w: failed to acquire connection", structs.ErrStorageUnavailable), want: codes.Unavailable},
+		{name: "unknown error", err: errors.New("boom"), want: codes.Internal},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if have := GetErrStatusCode(tc.err); have != tc.want {
+				t.Errorf("status code mismatch: have %s, want %s", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestStreamMetrics(t *testing.T) {
+	key := "secret"
+	client := startServer(t, config.ServerConfig{Key: key, TrustedSubnet: anySubnet})
+	ctx := context.Background()
+
+	stream, err := client.StreamMetrics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*pb.Metric{
+		signedMetric(key),
+		signedMetric(key),
+		gaugeMetric("Alloc", 1.5, "other"),
+	} {
+		err = stream.Send(&pb.UpdateMetricRequest{Metric: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Id != "Alloc" {
+		t.Fatalf("per-metric errors mismatch: have %v, want error for Alloc", resp.Errors)
+	}
+	if have := codes.Code(resp.Errors[0].Code); have != codes.InvalidArgument {
+		t.Errorf("per-metric error code mismatch: have %s, want %s", have, codes.InvalidArgument)
+	}
+
+	got, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Mtype: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Metric.GetDelta() != 6 {
+		t.Errorf("PollCount mismatch: have %d, want 6", got.Metric.GetDelta())
+	}
+}
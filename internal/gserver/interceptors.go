@@ -0,0 +1,189 @@
+package gserver
+
+import (
+	"context"
+	"net"
+
+	"github.com/zklevsha/go-musthave-devops/internal/pb"
+	"github.com/zklevsha/go-musthave-devops/internal/serializer"
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// realIPKey is a metadata key with client address set by proxies (same as X-Real-IP header)
+const realIPKey = "x-real-ip"
+
+// singleMetricRequest and multiMetricRequest are implemented by requests carrying metrics
+type singleMetricRequest interface {
+	GetMetric() *pb.Metric
+}
+
+type multiMetricRequest interface {
+	GetMetrics() []*pb.Metric
+}
+
+// interceptors enforce the same protections as HTTP handlers:
+// metrics hash verification, trusted subnet check and authorization of administrative requests
+type interceptors struct {
+	key           string
+	trustedSubnet net.IPNet
+}
+
+func (i *interceptors) unary(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	err := i.checkSubnet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = i.checkHash(req)
+	if err != nil {
+		return nil, err
+	}
+	err = i.checkAdmin(req)
+	if err != nil {
+		return nil, err
+	}
+	return handler(structs.ClientContext(ctx), req)
+}
+
+func (i *interceptors) stream(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := i.checkSubnet(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &hashCheckingStream{ServerStream: ss, i: i, ctx: structs.ClientContext(ss.Context())})
+}
+
+// hashCheckingStream verifies hash of every received message
+type hashCheckingStream struct {
+	grpc.ServerStream
+	i   *interceptors
+	ctx context.Context
+}
+
+func (s *hashCheckingStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *hashCheckingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	err = s.i.checkHash(m)
+	if err != nil {
+		return &rejectedError{err: err}
+	}
+	return nil
+}
+
+// rejectedError is returned by hashCheckingStream when received message was rejected,
+// so stream handlers can tell it from transport errors and continue receiving
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectedError) GRPCStatus() *status.Status {
+	return status.Convert(e.err)
+}
+
+// clientIP returns client address from x-real-ip metadata or from peer info
+func clientIP(ctx context.Context) (net.IP, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(realIPKey); len(values) > 0 {
+			ip := net.ParseIP(values[0])
+			if ip == nil {
+				return nil, status.Errorf(codes.PermissionDenied, "%s=%s is not a valid IP", realIPKey, values[0])
+			}
+			return ip, nil
+		}
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil, status.Error(codes.PermissionDenied, "failed to get client address")
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, status.Errorf(codes.PermissionDenied, "client address %s is not a valid IP", host)
+	}
+	return ip, nil
+}
+
+func (i *interceptors) checkSubnet(ctx context.Context) error {
+	if i.trustedSubnet.String() == "0.0.0.0/0" {
+		return nil
+	}
+	ip, err := clientIP(ctx)
+	if err != nil {
+		return err
+	}
+	if !i.trustedSubnet.Contains(ip) {
+		return status.Errorf(codes.PermissionDenied, "%s does not belong to trusted network %s",
+			ip, i.trustedSubnet.String())
+	}
+	return nil
+}
+
+func (i *interceptors) checkHash(req interface{}) error {
+	if i.key == "" {
+		return nil
+	}
+	var metrics []*pb.Metric
+	switch r := req.(type) {
+	case singleMetricRequest:
+		metrics = []*pb.Metric{r.GetMetric()}
+	case multiMetricRequest:
+		metrics = r.GetMetrics()
+	default:
+		return nil
+	}
+	for _, in := range metrics {
+		m, err := serializer.DecodeGRPCMetric(in)
+		if err != nil {
+			return statusError(err, in.GetId(), in.GetMtype())
+		}
+		if m.CalculateHash(i.key) != m.Hash {
+			return statusError(errInvalidHash, m.ID, m.MType)
+		}
+	}
+	return nil
+}
+
+// adminRequest converts requests changing stored series, ok is false for other requests
+func adminRequest(req interface{}) (structs.AdminRequest, bool) {
+	switch r := req.(type) {
+	case *pb.DeleteMetricRequest:
+		return structs.AdminRequest{Action: structs.AdminDelete, ID: r.Id, MType: r.Mtype,
+			Match: r.Match, Timestamp: r.Timestamp, Hash: r.Hash}, true
+	case *pb.ResetCounterRequest:
+		return structs.AdminRequest{Action: structs.AdminReset, ID: r.Id, MType: "counter",
+			Match: r.Match, Timestamp: r.Timestamp, Hash: r.Hash}, true
+	}
+	return structs.AdminRequest{}, false
+}
+
+func (i *interceptors) checkAdmin(req interface{}) error {
+	a, ok := adminRequest(req)
+	if !ok {
+		return nil
+	}
+	err := a.Authorize(i.key, i.trustedSubnet)
+	if err != nil {
+		return statusError(err, a.ID, a.MType)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package gserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/zklevsha/go-musthave-devops/internal/config"
+	"github.com/zklevsha/go-musthave-devops/internal/pb"
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var anySubnet = net.IPNet{IP: net.IPv4(0, 0, 0, 0), Mask: net.IPv4Mask(0, 0, 0, 0)}
+var localSubnet = net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.IPv4Mask(255, 0, 0, 0)}
+var privateSubnet = net.IPNet{IP: net.IPv4(192, 168, 23, 0), Mask: net.IPv4Mask(255, 255, 255, 0)}
+
+// startServer starts gRPC server on a random port and returns connected client
+func startServer(t *testing.T, conf config.ServerConfig) pb.MonitoringClient {
+	return pb.NewMonitoringClient(dial(t, conf))
+}
+
+// dial starts gRPC server on a random port and returns connection to it
+func dial(t *testing.T, conf config.ServerConfig) *grpc.ClientConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(conf, structs.NewMemoryStorage(), nil)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func signedMetric(key string) *pb.Metric {
+	delta := int64(3)
+	m := structs.Metric{ID: "PollCount", MType: "counter", Delta: &delta}
+	m.SetHash(key)
+	return &pb.Metric{Id: m.ID, Mtype: m.MType, Delta: *m.Delta, Hash: m.Hash}
+}
+
+func TestInterceptors(t *testing.T) {
+	tt := []struct {
+		name   string
+		key    string
+		subnet net.IPNet
+		metric *pb.Metric
+		realIP string
+		want   codes.Code
+	}{
+		{name: "no key, any subnet", subnet: anySubnet,
+			metric: signedMetric(""), want: codes.OK},
+		{name: "valid hash", key: "secret", subnet: anySubnet,
+			metric: signedMetric("secret"), want: codes.OK},
+		{name: "invalid hash", key: "secret", subnet: anySubnet,
+			metric: signedMetric("other"), want: codes.InvalidArgument},
+		{name: "missing hash", key: "secret", subnet: anySubnet,
+			metric: &pb.Metric{Id: "PollCount", Mtype: "counter", Delta: 3}, want: codes.InvalidArgument},
+		{name: "peer in trusted subnet", subnet: localSubnet,
+			metric: signedMetric(""), want: codes.OK},
+		{name: "peer outside trusted subnet", subnet: privateSubnet,
+			metric: signedMetric(""), want: codes.PermissionDenied},
+		{name: "x-real-ip in trusted subnet", subnet: privateSubnet, realIP: "192.168.23.5",
+			metric: signedMetric(""), want: codes.OK},
+		{name: "x-real-ip outside trusted subnet", subnet: localSubnet, realIP: "10.1.1.1",
+			metric: signedMetric(""), want: codes.PermissionDenied},
+		{name: "invalid x-real-ip", subnet: localSubnet, realIP: "not-an-ip",
+			metric: signedMetric(""), want: codes.PermissionDenied},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			client := startServer(t, config.ServerConfig{Key: tc.key, TrustedSubnet: tc.subnet})
+			ctx := context.Background()
+			if tc.realIP != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, realIPKey, tc.realIP)
+			}
+			_, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: tc.metric})
+			if have := status.Code(err); have != tc.want {
+				t.Errorf("status code mismatch: have %s, want %s (%v)", have, tc.want, err)
+			}
+		})
+	}
+}
+
+// fakeStream returns queued messages on RecvMsg
+type fakeStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	msgs []proto.Message
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	msg := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	proto.Merge(m.(proto.Message), msg)
+	return nil
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	i := &interceptors{key: "secret", trustedSubnet: anySubnet}
+	stream := &fakeStream{ctx: context.Background(), msgs: []proto.Message{
+		&pb.UpdateMetricRequest{Metric: signedMetric("secret")},
+		&pb.UpdateMetricRequest{Metric: signedMetric("other")},
+	}}
+
+	var codesReceived []codes.Code
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		for n := 0; n < 2; n++ {
+			err := ss.RecvMsg(&pb.UpdateMetricRequest{})
+			codesReceived = append(codesReceived, status.Code(err))
+		}
+		return nil
+	}
+	err := i.stream(nil, stream, &grpc.StreamServerInfo{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []codes.Code{codes.OK, codes.InvalidArgument}
+	for n := range want {
+		if codesReceived[n] != want[n] {
+			t.Errorf("message %d status code mismatch: have %s, want %s", n, codesReceived[n], want[n])
+		}
+	}
+
+	i.trustedSubnet = privateSubnet
+	err = i.stream(nil, stream, &grpc.StreamServerInfo{}, handler)
+	if have := status.Code(err); have != codes.PermissionDenied {
+		t.Errorf("status code mismatch: have %s, want %s", have, codes.PermissionDenied)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	i := &interceptors{trustedSubnet: anySubnet}
+	_, err := i.unary(context.Background(), &pb.GetMetricRequest{}, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			if !structs.IsClientContext(ctx) {
+				t.Error("unary call context is not marked as client request")
+			}
+			return nil, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = i.stream(nil, &fakeStream{ctx: context.Background()}, &grpc.StreamServerInfo{},
+		func(srv interface{}, ss grpc.ServerStream) error {
+			if !structs.IsClientContext(ss.Context()) {
+				t.Error("stream context is not marked as client request")
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,28 @@
+package gserver
+
+import (
+	"context"
+	"log"
+
+	"github.com/zklevsha/go-musthave-devops/internal/otlp"
+	colpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+)
+
+// otlpServer implements OpenTelemetry MetricsService
+type otlpServer struct {
+	colpb.UnimplementedMetricsServiceServer
+	receiver *otlp.Receiver
+}
+
+func (s *otlpServer) Export(ctx context.Context,
+	req *colpb.ExportMetricsServiceRequest) (*colpb.ExportMetricsServiceResponse, error) {
+	resp, err := s.receiver.Export(ctx, req)
+	if err != nil {
+		log.Printf("ERROR failed to export OTLP metrics: %s", err.Error())
+		return nil, statusError(err, "", "")
+	}
+	if ps := resp.GetPartialSuccess(); ps != nil {
+		log.Printf("WARN rejected %d OTLP data points: %s", ps.GetRejectedDataPoints(), ps.GetErrorMessage())
+	}
+	return resp, nil
+}
@@ -0,0 +1,46 @@
+package gserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zklevsha/go-musthave-devops/internal/config"
+	colpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOTLPExport(t *testing.T) {
+	tt := []struct {
+		name     string
+		conf     config.ServerConfig
+		want     codes.Code
+		rejected int64
+	}{
+		{name: "trusted subnet", conf: config.ServerConfig{TrustedSubnet: anySubnet}, want: codes.OK, rejected: 1},
+		{name: "untrusted subnet", conf: config.ServerConfig{TrustedSubnet: privateSubnet},
+			want: codes.PermissionDenied},
+	}
+	req := &colpb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+			{Name: "temperature", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{
+					{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5}}}}}},
+			{Name: "rpc", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+				DataPoints: []*metricspb.SummaryDataPoint{{Count: 1}}}}},
+		}}},
+	}}}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			client := colpb.NewMetricsServiceClient(dial(t, tc.conf))
+			resp, err := client.Export(context.Background(), req)
+			if have := status.Code(err); have != tc.want {
+				t.Fatalf("status code mismatch: have %s, want %s (%v)", have, tc.want, err)
+			}
+			if have := resp.GetPartialSuccess().GetRejectedDataPoints(); have != tc.rejected {
+				t.Errorf("rejected data points mismatch: have %d, want %d", have, tc.rejected)
+			}
+		})
+	}
+}
@@ -13,27 +13,53 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	httpSwagger "github.com/swaggo/http-swagger"
 	_ "github.com/zklevsha/go-musthave-devops/docs"
 	"github.com/zklevsha/go-musthave-devops/internal/archive"
 	"github.com/zklevsha/go-musthave-devops/internal/config"
+	"github.com/zklevsha/go-musthave-devops/internal/hash"
+	"github.com/zklevsha/go-musthave-devops/internal/influx"
+	"github.com/zklevsha/go-musthave-devops/internal/otlp"
+	"github.com/zklevsha/go-musthave-devops/internal/prom"
 	"github.com/zklevsha/go-musthave-devops/internal/rsaencrypt"
 	"github.com/zklevsha/go-musthave-devops/internal/serializer"
 	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	colpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
+// otlpProtobufContentType is content type of binary OTLP/HTTP requests
+const otlpProtobufContentType = "application/x-protobuf"
+
+// bodyHashHeader carries HMAC-SHA256 of the request body (as sent, before decompression) signed with the key.
+// Third-party ingestion endpoints require it when the key is set
+const bodyHashHeader = "HashSHA256"
+
 func GetErrStatusCode(err error) int {
 	switch {
 	case errors.Is(err, structs.ErrMetricNotFound):
 		return http.StatusNotFound
+	case errors.Is(err, structs.ErrMetricAmbiguous):
+		return http.StatusConflict
 	case errors.Is(err, structs.ErrMetricBadType):
 		return http.StatusNotImplemented
 	case errors.Is(err, structs.ErrMetricNullAttr) ||
-		errors.Is(err, structs.ErrMetricBadAttrValue):
+		errors.Is(err, structs.ErrMetricBadAttrValue) ||
+		errors.Is(err, structs.ErrBadResolution):
 		return http.StatusBadRequest
+	case errors.Is(err, structs.ErrHistoryDisabled) ||
+		errors.Is(err, structs.ErrAgentsNotTracked):
+		return http.StatusNotImplemented
+	case errors.Is(err, structs.ErrStorageUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, structs.ErrAccessDenied):
+		return http.StatusForbidden
 
 	default:
 		return http.StatusInternalServerError
@@ -45,6 +71,9 @@ type Handlers struct {
 	key           string
 	privKey       *rsa.PrivateKey
 	trustedSubnet net.IPNet
+	influxMapping influx.Mapping
+	otlpReceiver  *otlp.Receiver
+	agentTimeout  time.Duration
 }
 
 func (h *Handlers) sendResponse(w http.ResponseWriter, r *http.Request, code int,
@@ -113,7 +142,7 @@ func (h *Handlers) UpdateMeticHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.Storage.UpdateMetric(m)
+	err = h.Storage.UpdateMetric(structs.AgentContext(r.Context()), m)
 	if err != nil {
 		e := fmt.Sprintf("failed to update metric %s: %s", m.AsText(), err.Error())
 		h.sendResponse(w, r, GetErrStatusCode(err),
@@ -163,7 +192,7 @@ func (h *Handlers) UpdateMetricJSONHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = h.Storage.UpdateMetric(m)
+	err = h.Storage.UpdateMetric(structs.AgentContext(r.Context()), m)
 	if err != nil {
 		e := fmt.Sprintf("failed to update metric %s: %s", m.ID, err.Error())
 		h.sendResponse(w, r, GetErrStatusCode(err),
@@ -198,8 +227,18 @@ func (h *Handlers) UpdateMeticsBatchHandler(w http.ResponseWriter, r *http.Reque
 		h.sendResponse(w, r, http.StatusBadRequest, &structs.Response{Error: e})
 		return
 	}
+	if h.key != "" {
+		for _, m := range metrics {
+			if m.CalculateHash(h.key) != m.Hash {
+				e := fmt.Sprintf("invalid hash value of %s%s", m.ID, m.Labels.String())
+				log.Printf("WARN %s", e)
+				h.sendResponse(w, r, http.StatusBadRequest, &structs.Response{Error: e})
+				return
+			}
+		}
+	}
 	log.Println("INFO updating metrics batch")
-	err = h.Storage.UpdateMetrics(metrics)
+	err = h.Storage.UpdateMetrics(structs.AgentContext(r.Context()), metrics)
 	if err != nil {
 		e := fmt.Sprintf("failed to update metric batch: %s", err.Error())
 		log.Printf("ERROR %s", e)
@@ -213,13 +252,19 @@ func (h *Handlers) UpdateMeticsBatchHandler(w http.ResponseWriter, r *http.Reque
 
 //GetMetricHandler godoc
 // @Summary  Get metric
-// @Description Retreiving metric value
+// @Description Retreiving metric value. Without matchers the series without labels is returned,
+// @Description or the only series of the metric if there is no such series.
+// @Description Agents label their series with host and instance, so once several agents report the metric
+// @Description the request is answered with 409 and must select the series (e.g. ?match=instance=web1)
 // @Tags metrics
 // @Produce  json
 // @Param  metricType path string true "metric type" enums(counter,gauge)
 // @Param  metricID path string true "metric id"
+// @Param  match query []string false "label matchers (name=value, name!=value, name=~regexp, name!~regexp)" collectionFormat(multi)
 // @Success 200 {object} structs.Metric
+// @Failure 400 {object} structs.Response
 // @Failure 404 {object} structs.Response
+// @Failure 409 {object} structs.Response
 // @Failure 501 {object} structs.Response
 // @Router /value/{metricType}/{metricID} [get]
 func (h *Handlers) GetMetricHandler(w http.ResponseWriter, r *http.Request) {
@@ -229,7 +274,13 @@ func (h *Handlers) GetMetricHandler(w http.ResponseWriter, r *http.Request) {
 		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
 		return
 	}
-	metric, err := h.Storage.GetMetric(m)
+	matchers, err := serializer.DecodeMatchers(r)
+	if err != nil {
+		e := fmt.Sprintf("failed to decode url: %s", err.Error())
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	metric, err := structs.FindSeries(r.Context(), h.Storage, m, matchers)
 	if err != nil {
 		log.Printf(" WARN failed to get metric: %s", err.Error())
 		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: err.Error()})
@@ -239,6 +290,107 @@ func (h *Handlers) GetMetricHandler(w http.ResponseWriter, r *http.Request) {
 	h.sendResponse(w, r, http.StatusOK, &metric)
 }
 
+// DeleteMetricHandler godoc
+// @Summary  Delete metric
+// @Description Deleting series of the metric selected by matchers (all series if none) together with their history.
+// @Description Refused unless key or trusted subnet is configured, requests must be signed when key is set
+// @Tags metrics
+// @Produce  json
+// @Produce text/plain
+// @Param  metricType path string true "metric type" enums(counter,gauge)
+// @Param  metricID path string true "metric id"
+// @Param  match query []string false "label matchers (name=value, name!=value, name=~regexp, name!~regexp)" collectionFormat(multi)
+// @Param  ts query int false "unix time the request was signed at, accepted within 5 minutes"
+// @Param  hash query string false "hmac of delete:metricID:metricType:matchers joined by ';':ts"
+// @Success 200 {object} structs.Response
+// @Failure 400 {object} structs.Response
+// @Failure 403 {object} structs.Response
+// @Failure 404 {object} structs.Response
+// @Failure 501 {object} structs.Response
+// @Router /value/{metricType}/{metricID} [delete]
+func (h *Handlers) DeleteMetricHandler(w http.ResponseWriter, r *http.Request) {
+	m, err := serializer.DecodeURL(r)
+	if err != nil {
+		e := fmt.Sprintf("failed to decode url: %s", err.Error())
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	matchers, ok := h.adminMatchers(w, r, structs.AdminRequest{Action: structs.AdminDelete, ID: m.ID, MType: m.MType})
+	if !ok {
+		return
+	}
+	err = h.Storage.DeleteMetric(r.Context(), m, matchers)
+	if err != nil {
+		e := fmt.Sprintf("failed to delete %s %s: %s", m.MType, m.ID, err.Error())
+		log.Printf("WARN %s", e)
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	log.Printf("INFO %s %s was deleted", m.MType, m.ID)
+	h.sendResponse(w, r, http.StatusOK, &structs.Response{Message: "metric was deleted"})
+}
+
+// ResetCounterHandler godoc
+// @Summary  Reset counter
+// @Description Setting series of the counter selected by matchers (all series if none) to zero.
+// @Description Refused unless key or trusted subnet is configured, requests must be signed when key is set
+// @Tags metrics
+// @Produce  json
+// @Produce text/plain
+// @Param  metricID path string true "counter id"
+// @Param  match query []string false "label matchers (name=value, name!=value, name=~regexp, name!~regexp)" collectionFormat(multi)
+// @Param  ts query int false "unix time the request was signed at, accepted within 5 minutes"
+// @Param  hash query string false "hmac of reset:metricID:counter:matchers joined by ';':ts"
+// @Success 200 {object} structs.Response
+// @Failure 400 {object} structs.Response
+// @Failure 403 {object} structs.Response
+// @Failure 404 {object} structs.Response
+// @Router /reset/{metricID} [post]
+func (h *Handlers) ResetCounterHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["metricID"]
+	matchers, ok := h.adminMatchers(w, r, structs.AdminRequest{Action: structs.AdminReset, ID: id, MType: "counter"})
+	if !ok {
+		return
+	}
+	err := h.Storage.ResetCounter(r.Context(), id, matchers)
+	if err != nil {
+		e := fmt.Sprintf("failed to reset counter %s: %s", id, err.Error())
+		log.Printf("WARN %s", e)
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	log.Printf("INFO counter %s was reset", id)
+	h.sendResponse(w, r, http.StatusOK, &structs.Response{Message: "counter was reset"})
+}
+
+// adminMatchers authorizes administrative request signed by 'hash' query parameter at 'ts'
+// and returns label matchers set by 'match' parameters. Response is already sent if ok is false
+func (h *Handlers) adminMatchers(w http.ResponseWriter, r *http.Request,
+	a structs.AdminRequest) (matchers []structs.LabelMatcher, ok bool) {
+	a.Match = r.URL.Query()["match"]
+	a.Hash = r.URL.Query().Get("hash")
+	var err error
+	if ts := r.URL.Query().Get("ts"); ts != "" {
+		a.Timestamp, err = strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("%w: bad timestamp %s", structs.ErrMetricBadAttrValue, ts)
+		}
+	}
+	if err == nil {
+		err = a.Authorize(h.key, h.trustedSubnet)
+	}
+	if err == nil {
+		matchers, err = a.Matchers()
+	}
+	if err != nil {
+		e := fmt.Sprintf("%s %s %s is rejected: %s", a.Action, a.MType, a.ID, err.Error())
+		log.Printf("WARN %s", e)
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return nil, false
+	}
+	return matchers, true
+}
+
 //GetMetricJSONHandler godoc
 // @Summary Get  metric
 // @Description Retreiving metric value
@@ -260,7 +412,7 @@ func (h *Handlers) GetMetricJSONHandler(w http.ResponseWriter, r *http.Request)
 		h.sendResponse(w, r, http.StatusBadRequest, &structs.Response{Error: e})
 		return
 	}
-	metric, err := h.Storage.GetMetric(m)
+	metric, err := h.Storage.GetMetric(r.Context(), m)
 
 	if err != nil {
 		e := fmt.Sprintf("failed to get metric: %s", err.Error())
@@ -273,6 +425,350 @@ func (h *Handlers) GetMetricJSONHandler(w http.ResponseWriter, r *http.Request)
 
 }
 
+//GetMetricHistoryHandler godoc
+// @Summary  Get metric history
+// @Description Retreiving timestamped metric samples (or their rollups) for the time range
+// @Tags metrics
+// @Produce  json
+// @Produce text/plain
+// @Param  metricType path string true "metric type" enums(counter,gauge)
+// @Param  metricID path string true "metric id"
+// @Param  from query string false "range start (RFC3339 or unix timestamp)"
+// @Param  to query string false "range end (RFC3339 or unix timestamp)"
+// @Param  resolution query string false "rollup resolution" enums(raw,1m,1h)
+// @Param  match query []string false "label matchers (name=value, name!=value, name=~regexp, name!~regexp)" collectionFormat(multi)
+// @Success 200 {object} structs.MetricHistory
+// @Failure 400 {object} structs.Response
+// @Failure 404 {object} structs.Response
+// @Failure 409 {object} structs.Response
+// @Failure 501 {object} structs.Response
+// @Router /history/{metricType}/{metricID} [get]
+func (h *Handlers) GetMetricHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	m, from, to, err := serializer.DecodeHistoryURL(r)
+	if err != nil {
+		e := fmt.Sprintf("failed to decode url: %s", err.Error())
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	resolution, err := serializer.DecodeResolution(r)
+	if err != nil {
+		e := fmt.Sprintf("failed to decode url: %s", err.Error())
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	matchers, err := serializer.DecodeMatchers(r)
+	if err != nil {
+		e := fmt.Sprintf("failed to decode url: %s", err.Error())
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	// without matchers history of the series without labels is returned
+	if len(matchers) > 0 {
+		series, err := structs.FindSeries(r.Context(), h.Storage, m, matchers)
+		if err != nil {
+			e := fmt.Sprintf("failed to get metric history: %s", err.Error())
+			log.Printf("WARN %s", e)
+			h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+			return
+		}
+		m.Labels = series.Labels
+	}
+
+	history := structs.MetricHistory{ID: m.ID, MType: m.MType, Labels: m.Labels}
+	if resolution == 0 {
+		history.Resolution = "raw"
+		history.Samples, err = h.Storage.GetMetricHistory(r.Context(), m, from, to)
+	} else {
+		history.Resolution = resolution.String()
+		history.Rollups, err = h.Storage.GetMetricRollups(r.Context(), m, resolution, from, to)
+	}
+	if err != nil {
+		e := fmt.Sprintf("failed to get metric history: %s", err.Error())
+		log.Printf("WARN %s", e)
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+
+	h.sendResponse(w, r, http.StatusOK, &history)
+}
+
+// RemoteWriteHandler godoc
+// @Summary  Prometheus remote write
+// @Description Storing series pushed by Prometheus (snappy compressed protobuf WriteRequest).
+// @Description Series labels are stored as metric labels, samples are stored as gauges
+// @Tags metrics
+// @Accept application/x-protobuf
+// @Produce  json
+// @Produce text/plain
+// @Param HashSHA256 header string false "HMAC-SHA256 of the body signed with the key (required if the key is set)"
+// @Success 200 {object} structs.Response
+// @Failure 400 {object} structs.Response
+// @Failure 403 {object} structs.Response
+// @Failure 500 {object} structs.Response
+// @Router /api/v1/write [post]
+func (h *Handlers) RemoteWriteHandler(w http.ResponseWriter, r *http.Request) {
+	// RequestCtxBody{} should be set in read body middleware
+	b := r.Context().Value(structs.RequestCtxBody{}).([]byte)
+	metrics, err := prom.DecodeWriteRequest(b)
+	if err != nil {
+		e := fmt.Sprintf("failed to decode remote write request: %s", err.Error())
+		log.Printf("ERROR %s", e)
+		h.sendResponse(w, r, http.StatusBadRequest, &structs.Response{Error: e})
+		return
+	}
+	log.Printf("INFO updating %d samples from remote write", len(metrics))
+	err = h.Storage.UpdateMetrics(r.Context(), metrics)
+	if err != nil {
+		e := fmt.Sprintf("failed to update metrics from remote write: %s", err.Error())
+		log.Printf("ERROR %s", e)
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	h.sendResponse(w, r, http.StatusOK,
+		&structs.Response{Message: fmt.Sprintf("%d samples were written", len(metrics))})
+}
+
+// InfluxWriteHandler godoc
+// @Summary  InfluxDB line protocol write
+// @Description Storing metrics sent in InfluxDB line protocol (Telegraf compatible, gzip bodies are supported).
+// @Description Every field becomes a metric with measurement_field{tags} id. Float and boolean fields are stored as gauges,
+// @Description integer fields are stored as counters if they match -influx-counters patterns, otherwise as gauges
+// @Tags metrics
+// @Accept text/plain
+// @Produce  json
+// @Produce text/plain
+// @Param precision query string false "timestamp precision (ns, us, ms, s, m, h)"
+// @Param HashSHA256 header string false "HMAC-SHA256 of the body signed with the key (required if the key is set)"
+// @Success 204
+// @Failure 400 {object} structs.Response
+// @Failure 403 {object} structs.Response
+// @Failure 500 {object} structs.Response
+// @Router /write [post]
+func (h *Handlers) InfluxWriteHandler(w http.ResponseWriter, r *http.Request) {
+	// RequestCtxBody{} should be set in read body middleware
+	b := r.Context().Value(structs.RequestCtxBody{}).([]byte)
+	metrics, err := influx.Parse(b, r.URL.Query().Get("precision"), h.influxMapping)
+	if err != nil {
+		e := fmt.Sprintf("failed to parse line protocol: %s", err.Error())
+		log.Printf("ERROR %s", e)
+		h.sendResponse(w, r, http.StatusBadRequest, &structs.Response{Error: e})
+		return
+	}
+	log.Printf("INFO updating %d metrics from line protocol", len(metrics))
+	if len(metrics) > 0 {
+		err = h.Storage.UpdateMetrics(r.Context(), metrics)
+		if err != nil {
+			e := fmt.Sprintf("failed to update metrics from line protocol: %s", err.Error())
+			log.Printf("ERROR %s", e)
+			h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+			return
+		}
+	}
+	// InfluxDB replies with empty 204 on success
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// OTLPHandler godoc
+// @Summary  OTLP metrics export
+// @Description Storing metrics sent by OpenTelemetry exporters (OTLP/HTTP, protobuf or JSON encoded).
+// @Description Monotonic sums are stored as counter deltas, gauges and non-monotonic sums as gauges,
+// @Description histograms as <name>_count and <name>_bucket counters and <name>_sum gauge
+// @Tags metrics
+// @Accept application/x-protobuf
+// @Accept json
+// @Produce application/x-protobuf
+// @Produce json
+// @Param HashSHA256 header string false "HMAC-SHA256 of the body signed with the key (required if the key is set)"
+// @Success 200
+// @Failure 400 {object} structs.Response
+// @Failure 403 {object} structs.Response
+// @Failure 415 {object} structs.Response
+// @Failure 500 {object} structs.Response
+// @Router /v1/metrics [post]
+func (h *Handlers) OTLPHandler(w http.ResponseWriter, r *http.Request) {
+	// RequestCtxBody{} should be set in read body middleware
+	b := r.Context().Value(structs.RequestCtxBody{}).([]byte)
+	contentType := r.Header.Get("Content-Type")
+	asJSON := strings.HasPrefix(contentType, "application/json")
+	if !asJSON && !strings.HasPrefix(contentType, otlpProtobufContentType) {
+		e := fmt.Sprintf("unsupported content type %s", contentType)
+		log.Printf("ERROR %s", e)
+		h.sendResponse(w, r, http.StatusUnsupportedMediaType, &structs.Response{Error: e})
+		return
+	}
+
+	var req colpb.ExportMetricsServiceRequest
+	var err error
+	if asJSON {
+		err = protojson.Unmarshal(b, &req)
+	} else {
+		err = proto.Unmarshal(b, &req)
+	}
+	if err != nil {
+		e := fmt.Sprintf("failed to decode OTLP request: %s", err.Error())
+		log.Printf("ERROR %s", e)
+		h.sendResponse(w, r, http.StatusBadRequest, &structs.Response{Error: e})
+		return
+	}
+
+	resp, err := h.otlpReceiver.Export(r.Context(), &req)
+	if err != nil {
+		e := fmt.Sprintf("failed to export OTLP metrics: %s", err.Error())
+		log.Printf("ERROR %s", e)
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	if ps := resp.GetPartialSuccess(); ps != nil {
+		log.Printf("WARN rejected %d OTLP data points: %s", ps.GetRejectedDataPoints(), ps.GetErrorMessage())
+	}
+
+	var body []byte
+	if asJSON {
+		body, err = protojson.Marshal(resp)
+	} else {
+		body, err = proto.Marshal(resp)
+	}
+	if err != nil {
+		e := fmt.Sprintf("failed to encode OTLP response: %s", err.Error())
+		log.Printf("ERROR %s", e)
+		h.sendResponse(w, r, http.StatusInternalServerError, &structs.Response{Error: e})
+		return
+	}
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", otlpProtobufContentType)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// PrometheusHandler godoc
+// @Summary  Prometheus metrics
+// @Description Exposing all metrics in Prometheus text format (or OpenMetrics if client accepts it)
+// @Tags metrics
+// @Produce text/plain
+// @Produce application/openmetrics-text
+// @Param  match query []string false "label matchers (name=value, name!=value, name=~regexp, name!~regexp)" collectionFormat(multi)
+// @Success 200 {string} string
+// @Failure 400 {object} structs.Response
+// @Failure 500 {object} structs.Response
+// @Router /metrics [get]
+func (h *Handlers) PrometheusHandler(w http.ResponseWriter, r *http.Request) {
+	matchers, err := serializer.DecodeMatchers(r)
+	if err != nil {
+		e := fmt.Sprintf("failed to decode url: %s", err.Error())
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	all, err := h.Storage.GetMetrics(r.Context())
+	if err != nil {
+		e := fmt.Sprintf("failed to get metrics: %s", err.Error())
+		log.Printf("ERROR %s", e)
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	metrics := make([]structs.Metric, 0, len(all))
+	for _, m := range all {
+		if structs.MatchLabels(m.Labels, matchers) {
+			metrics = append(metrics, m)
+		}
+	}
+
+	openMetrics := prom.AcceptsOpenMetrics(strings.Join(r.Header["Accept"], ","))
+	body := prom.Encode(metrics, openMetrics)
+	if strings.Contains(strings.Join(r.Header["Accept-Encoding"], ","), "gzip") {
+		body, err = archive.Compress(body)
+		if err != nil {
+			e := fmt.Sprintf("failed to compress response: %s", err.Error())
+			h.sendResponse(w, r, http.StatusInternalServerError, &structs.Response{Error: e})
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+
+	if openMetrics {
+		w.Header().Set("Content-Type", prom.OpenMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", prom.TextContentType)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// ListMetricsHandler godoc
+// @Summary  List metrics
+// @Description Listing metrics page filtered by type and ID prefix/regex.
+// @Description Pass next_cursor of the response as cursor to get the next page
+// @Tags metrics
+// @Produce  json
+// @Produce text/plain
+// @Param  type query string false "metric type (counter, gauge)"
+// @Param  prefix query string false "metric ID prefix"
+// @Param  regex query string false "metric ID regular expression"
+// @Param  sort query string false "sort key (id, type)" default(id)
+// @Param  order query string false "sort order (asc, desc)" default(asc)
+// @Param  limit query int false "page size (max 1000)" default(100)
+// @Param  cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} structs.MetricList
+// @Failure 400 {object} structs.Response
+// @Failure 500 {object} structs.Response
+// @Router /api/metrics [get]
+func (h *Handlers) ListMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := serializer.DecodeListQuery(r)
+	if err != nil {
+		e := fmt.Sprintf("failed to decode url: %s", err.Error())
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	list, err := h.Storage.ListMetrics(r.Context(), q)
+	if err != nil {
+		e := fmt.Sprintf("failed to list metrics: %s", err.Error())
+		h.sendResponse(w, r, GetErrStatusCode(err), &structs.Response{Error: e})
+		return
+	}
+	h.sendResponse(w, r, http.StatusOK, &list)
+}
+
+// AgentsHandler godoc
+// @Summary  List agents
+// @Description Listing agents which have reported metrics (identified by instance label) with their last-seen time.
+// @Description Agents which have not reported for the agent timeout are marked as silent
+// @Tags agents
+// @Produce  json
+// @Produce text/plain
+// @Param  silent query bool false "list only silent agents"
+// @Success 200 {object} structs.AgentList
+// @Failure 400 {object} structs.Response
+// @Failure 501 {object} structs.Response
+// @Router /agents [get]
+func (h *Handlers) AgentsHandler(w http.ResponseWriter, r *http.Request) {
+	lister, ok := h.Storage.(structs.AgentLister)
+	if !ok {
+		h.sendResponse(w, r, GetErrStatusCode(structs.ErrAgentsNotTracked),
+			&structs.Response{Error: structs.ErrAgentsNotTracked.Error()})
+		return
+	}
+	var silentOnly bool
+	if raw := r.URL.Query().Get("silent"); raw != "" {
+		var err error
+		silentOnly, err = strconv.ParseBool(raw)
+		if err != nil {
+			e := fmt.Sprintf("failed to decode url: %s: bad silent value %s", structs.ErrMetricBadAttrValue, raw)
+			h.sendResponse(w, r, http.StatusBadRequest, &structs.Response{Error: e})
+			return
+		}
+	}
+
+	list := structs.AgentList{Agents: []structs.Agent{}}
+	for _, a := range lister.Agents(h.agentTimeout) {
+		if !silentOnly || a.Silent {
+			list.Agents = append(list.Agents, a)
+		}
+	}
+	h.sendResponse(w, r, http.StatusOK, &list)
+}
+
 func (h *Handlers) RootHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	resp := &structs.Response{Message: "<html><body><h1>Server is wokring</h1></body></html>"}
@@ -288,7 +784,7 @@ func (h *Handlers) RootHandler(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} structs.Response
 // @Router /ping [get]
 func (h *Handlers) Ping(w http.ResponseWriter, r *http.Request) {
-	err := h.Storage.Avaliable()
+	err := h.Storage.Avaliable(r.Context())
 	if err != nil {
 		h.sendResponse(w, r, http.StatusInternalServerError,
 			&structs.Response{Error: fmt.Sprintf("DB is down: %s", err.Error())})
@@ -301,6 +797,16 @@ func (h *Handlers) Ping(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) ReadBodyMiddleware(next http.Handler) http.Handler {
+	return h.readBody(next, true)
+}
+
+// plainBodyMiddleware reads body of third-party ingestion endpoints (Prometheus, Telegraf),
+// these clients never encrypt requests
+func (h *Handlers) plainBodyMiddleware(next http.Handler) http.Handler {
+	return h.readBody(next, false)
+}
+
+func (h *Handlers) readBody(next http.Handler, decrypt bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Reading bytes
 		b, err := ioutil.ReadAll(r.Body)
@@ -311,8 +817,9 @@ func (h *Handlers) ReadBodyMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Decrypt
-		if h.privKey != nil {
-			b, err = rsaencrypt.Decrypt(h.privKey, b, []byte(config.RsaLabel))
+		if decrypt && h.privKey != nil {
+			b, err = rsaencrypt.DecryptVersion(h.privKey, r.Header.Get(rsaencrypt.VersionHeader),
+				b, []byte(config.RsaLabel))
 			if err != nil {
 				e := fmt.Sprintf("failed to decrypt body: %s", err.Error())
 				h.sendResponse(w, r, http.StatusBadRequest, &structs.Response{Error: e})
@@ -338,6 +845,39 @@ func (h *Handlers) ReadBodyMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// clientContextMiddleware marks request context, see structs.ClientContext
+func clientContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(structs.ClientContext(r.Context())))
+	})
+}
+
+// bodyHashMiddleware rejects requests of third-party ingestion endpoints without valid body signature
+// when the key is set, as /updates/ rejects unsigned metrics
+func (h *Handlers) bodyHashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			e := fmt.Sprintf("failed to read body: %s", err.Error())
+			h.sendResponse(w, r, http.StatusBadRequest, &structs.Response{Error: e})
+			return
+		}
+		ok, err := hash.Verify(string(b), h.key, r.Header.Get(bodyHashHeader))
+		if err != nil || !ok {
+			e := fmt.Sprintf("invalid %s header value", bodyHashHeader)
+			log.Printf("ERROR %s", e)
+			h.sendResponse(w, r, http.StatusBadRequest, &structs.Response{Error: e})
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (h *Handlers) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if h.trustedSubnet.String() == "0.0.0.0/0" {
@@ -373,7 +913,13 @@ func GetHandler(c config.ServerConfig, store structs.Storage, privKey *rsa.Priva
 	r := mux.NewRouter()
 	h := Handlers{key: c.Key, Storage: store,
 		privKey:       privKey,
-		trustedSubnet: c.TrustedSubnet}
+		trustedSubnet: c.TrustedSubnet,
+		influxMapping: influx.Mapping{Counters: c.InfluxCounters},
+		otlpReceiver:  otlp.NewReceiver(store),
+		agentTimeout:  c.AgentTimeout}
+
+	// storage calls made for requests are bounded by the query timeout
+	r.Use(clientContextMiddleware)
 
 	// root
 	r.HandleFunc("/", h.RootHandler)
@@ -396,6 +942,17 @@ func GetHandler(c config.ServerConfig, store structs.Storage, privKey *rsa.Priva
 	r.HandleFunc("/value/{metricType}/{metricID}",
 		h.GetMetricHandler).Methods("GET")
 
+	// delete metric
+	chain = h.authMiddleware(
+		http.HandlerFunc(h.DeleteMetricHandler))
+	r.Handle("/value/{metricType}/{metricID}",
+		chain).Methods("DELETE")
+
+	// reset counter
+	chain = h.authMiddleware(
+		http.HandlerFunc(h.ResetCounterHandler))
+	r.Handle("/reset/{metricID}", chain).Methods("POST")
+
 	// get metric (body parameter)
 	chain = h.ReadBodyMiddleware(
 		http.HandlerFunc(h.GetMetricJSONHandler))
@@ -403,6 +960,10 @@ func GetHandler(c config.ServerConfig, store structs.Storage, privKey *rsa.Priva
 		Methods("POST").
 		Headers("Content-Type", "application/json")
 
+	// get metric history
+	r.HandleFunc("/history/{metricType}/{metricID}",
+		h.GetMetricHistoryHandler).Methods("GET")
+
 	// update multiple metrics
 	chain = h.authMiddleware(h.ReadBodyMiddleware(
 		http.HandlerFunc(h.UpdateMeticsBatchHandler)))
@@ -412,6 +973,30 @@ func GetHandler(c config.ServerConfig, store structs.Storage, privKey *rsa.Priva
 
 	r.HandleFunc("/ping", h.Ping)
 
+	// agents reporting to the server
+	r.HandleFunc("/agents", h.AgentsHandler).Methods("GET")
+
+	// metrics listing
+	r.HandleFunc("/api/metrics", h.ListMetricsHandler).Methods("GET")
+
+	// Prometheus exposition
+	r.HandleFunc("/metrics", h.PrometheusHandler).Methods("GET")
+
+	// Prometheus remote write
+	chain = h.authMiddleware(h.bodyHashMiddleware(h.plainBodyMiddleware(
+		http.HandlerFunc(h.RemoteWriteHandler))))
+	r.Handle("/api/v1/write", chain).Methods("POST")
+
+	// InfluxDB line protocol
+	chain = h.authMiddleware(h.bodyHashMiddleware(h.plainBodyMiddleware(
+		http.HandlerFunc(h.InfluxWriteHandler))))
+	r.Handle("/write", chain).Methods("POST")
+
+	// OpenTelemetry metrics
+	chain = h.authMiddleware(h.bodyHashMiddleware(h.plainBodyMiddleware(
+		http.HandlerFunc(h.OTLPHandler))))
+	r.Handle("/v1/metrics", chain).Methods("POST")
+
 	// Swagger docs avaialble at /swagger/ endpoint
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
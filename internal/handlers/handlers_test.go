@@ -2,26 +2,83 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/snappy"
+	"github.com/zklevsha/go-musthave-devops/internal/agents"
+	"github.com/zklevsha/go-musthave-devops/internal/archive"
+	"github.com/zklevsha/go-musthave-devops/internal/boltdb"
 	"github.com/zklevsha/go-musthave-devops/internal/config"
+	"github.com/zklevsha/go-musthave-devops/internal/hash"
+	"github.com/zklevsha/go-musthave-devops/internal/prom"
+	"github.com/zklevsha/go-musthave-devops/internal/prompb"
 	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	colpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
+// newStorage and newStorageWithHistory return storage handlers are tested with.
+// TestMain runs tests with every storage backend not requiring external servers
+var newStorage = structs.NewMemoryStorage
+var newStorageWithHistory = structs.NewMemoryStorageWithHistory
+
 var confNoAuth = config.ServerConfig{TrustedSubnet: net.IPNet{IP: net.IPv4(0, 0, 0, 0),
 	Mask: net.IPv4Mask(0, 0, 0, 0)}}
-var routerNoAuth = GetHandler(confNoAuth, structs.NewMemoryStorage(), nil)
+var routerNoAuth = GetHandler(confNoAuth, newStorage(), nil)
 
 var confAuth = config.ServerConfig{TrustedSubnet: net.IPNet{IP: net.IPv4(192, 168, 23, 0),
 	Mask: net.IPv4Mask(255, 255, 255, 0)}}
-var routerAuth = GetHandler(confAuth, structs.NewMemoryStorage(), nil)
+var routerAuth = GetHandler(confAuth, newStorage(), nil)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code != 0 {
+		os.Exit(code)
+	}
+
+	dir, err := os.MkdirTemp("", "handlers")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var opened []*boltdb.BoltStorage
+	openBolt := func(keepHistory bool) structs.Storage {
+		s := &boltdb.BoltStorage{Path: filepath.Join(dir, fmt.Sprintf("%d.db", len(opened))), KeepHistory: keepHistory}
+		if err := s.Init(); err != nil {
+			log.Fatal(err)
+		}
+		opened = append(opened, s)
+		return s
+	}
+	newStorage = func() structs.Storage { return openBolt(false) }
+	newStorageWithHistory = func() structs.Storage { return openBolt(true) }
+	routerNoAuth = GetHandler(confNoAuth, newStorage(), nil)
+	routerAuth = GetHandler(confAuth, newStorage(), nil)
+	log.Printf("running tests with %s storage", config.StorageBolt)
+	code = m.Run()
+
+	for _, s := range opened {
+		s.Close()
+	}
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
 
 // TestUpdateMeticHandler
 func TestUpdateMeticHandler(t *testing.T) {
@@ -159,6 +216,11 @@ func TestGetErrStatusCode(t *testing.T) {
 			err:  structs.ErrMetricNotFound,
 			want: http.StatusNotFound,
 		},
+		{
+			name: "storage unavailable error",
+			err:  fmt.Errorf("%w: failed to acquire connection", structs.ErrStorageUnavailable),
+			want: http.StatusServiceUnavailable,
+		},
 	}
 
 	// запускаем каждый тест
@@ -273,6 +335,16 @@ func TestUpdateMeticJSONHandler(t *testing.T) {
 func TestUpdateMeticsBatchHandler(t *testing.T) {
 	counter := int64(1)
 	gauge := float64(1.5)
+	key := "secret"
+	routerKey := GetHandler(config.ServerConfig{Key: key, TrustedSubnet: confNoAuth.TrustedSubnet}, newStorage(), nil)
+	signed := func(m structs.Metric) structs.Metric {
+		m.SetHash(key)
+		return m
+	}
+	signedResponse := func(r structs.Response) string {
+		r.SetHash(key)
+		return r.AsText()
+	}
 
 	type want struct {
 		response string
@@ -327,6 +399,32 @@ func TestUpdateMeticsBatchHandler(t *testing.T) {
 			},
 			router: routerAuth,
 		},
+
+		{
+			name: "trying to update signed counter and gauge",
+			metrics: []structs.Metric{
+				signed(structs.Metric{MType: "counter", ID: "testCounter", Delta: &counter}),
+				signed(structs.Metric{MType: "gauge", ID: "testGauge", Value: &gauge}),
+			},
+			want: want{
+				code:     200,
+				response: signedResponse(structs.Response{Message: "metrics batch was updated"}),
+			},
+			router: routerKey,
+		},
+
+		{
+			name: "trying to update batch with unsigned gauge",
+			metrics: []structs.Metric{
+				signed(structs.Metric{MType: "counter", ID: "testCounter", Delta: &counter}),
+				{MType: "gauge", ID: "testGauge", Value: &gauge},
+			},
+			want: want{
+				code:     400,
+				response: signedResponse(structs.Response{Error: "invalid hash value of testGauge"}),
+			},
+			router: routerKey,
+		},
 	}
 	for _, tc := range tt {
 		// запускаем каждый тест
@@ -365,7 +463,7 @@ func TestUpdateMeticsBatchHandler(t *testing.T) {
 }
 
 func TestGetMetricHandler(t *testing.T) {
-	storage := structs.NewMemoryStorage()
+	storage := newStorage()
 	counter := int64(1)
 	gauge := float64(1.5)
 
@@ -412,7 +510,7 @@ func TestGetMetricHandler(t *testing.T) {
 			m := tc.metric
 
 			// saving metric in memory storage
-			err := storage.UpdateMetric(m)
+			err := storage.UpdateMetric(context.Background(), m)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -445,8 +543,50 @@ func TestGetMetricHandler(t *testing.T) {
 	}
 }
 
+func TestGetMetricHandlerMatchers(t *testing.T) {
+	storage := newStorage()
+	for i, labels := range []structs.Labels{{"host": "web1"}, {"host": "web2", "env": "prod"}, {"host": "web3"}} {
+		v := float64(i + 1)
+		err := storage.UpdateMetric(context.Background(), structs.Metric{ID: "load", MType: "gauge", Value: &v, Labels: labels})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := GetHandler(config.ServerConfig{}, storage, nil)
+
+	tt := []struct {
+		name  string
+		query string
+		code  int
+		want  string
+	}{
+		{name: "equality matcher", query: "?match=host=web1", code: http.StatusOK, want: "1.000"},
+		{name: "several matchers", query: "?match=host=~web.*&match=env=prod", code: http.StatusOK, want: "2.000"},
+		{name: "several series", query: "?match=host!=web2", code: http.StatusConflict},
+		{name: "no matching series", query: "?match=host=web4", code: http.StatusNotFound},
+		{name: "series without labels", query: "", code: http.StatusConflict},
+		{name: "bad matcher", query: "?match=host", code: http.StatusBadRequest},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/value/gauge/load"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.code {
+				t.Fatalf("Expected status code %d, got %d (%s)", tc.code, rr.Code, rr.Body.String())
+			}
+			if tc.want != "" && rr.Body.String() != tc.want {
+				t.Errorf("Expected body %s, got %s", tc.want, rr.Body.String())
+			}
+		})
+	}
+}
+
 func TestGetMetricJSONHandler(t *testing.T) {
-	storage := structs.NewMemoryStorage()
+	storage := newStorage()
 	counter := int64(1)
 	gauge := float64(1.5)
 
@@ -491,7 +631,7 @@ func TestGetMetricJSONHandler(t *testing.T) {
 			m := tc.metric
 
 			// saving metric in memory storage
-			err := storage.UpdateMetric(m)
+			err := storage.UpdateMetric(context.Background(), m)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -552,7 +692,7 @@ func TestPing(t *testing.T) {
 				t.Fatal(err)
 			}
 			rr := httptest.NewRecorder()
-			router := GetHandler(config.ServerConfig{}, structs.NewMemoryStorage(), nil)
+			router := GetHandler(config.ServerConfig{}, newStorage(), nil)
 			router.ServeHTTP(rr, req)
 			res := rr.Result()
 
@@ -573,6 +713,335 @@ func TestPing(t *testing.T) {
 
 }
 
+// ctxStorage fails calls made with done context, as database storage does
+type ctxStorage struct {
+	structs.Storage
+}
+
+func (s ctxStorage) GetMetric(ctx context.Context, m structs.Metric) (structs.Metric, error) {
+	if err := ctx.Err(); err != nil {
+		return structs.Metric{}, fmt.Errorf("%w: %s", structs.ErrStorageUnavailable, err.Error())
+	}
+	return s.Storage.GetMetric(ctx, m)
+}
+
+func TestRequestContext(t *testing.T) {
+	store := ctxStorage{Storage: newStorage()}
+	v := 1.5
+	err := store.UpdateMetric(context.Background(), structs.Metric{ID: "Alloc", MType: "gauge", Value: &v})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt := []struct {
+		name   string
+		cancel bool
+		code   int
+	}{
+		{name: "active request", code: http.StatusOK},
+		{name: "cancelled request", cancel: true, code: http.StatusServiceUnavailable},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancel {
+				cancel()
+			}
+			req := httptest.NewRequest("GET", "/value/gauge/Alloc", nil).WithContext(ctx)
+			rr := httptest.NewRecorder()
+			GetHandler(config.ServerConfig{}, store, nil).ServeHTTP(rr, req)
+			if rr.Code != tc.code {
+				t.Errorf("Expected status code %d, got %d (%s)", tc.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+// agentsStorage is a memory storage reporting fixed agents list
+type agentsStorage struct {
+	structs.Storage
+	agents []structs.Agent
+}
+
+func (s agentsStorage) Agents(timeout time.Duration) []structs.Agent {
+	return s.agents
+}
+
+func TestAgentsHandler(t *testing.T) {
+	seen := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := agentsStorage{Storage: newStorage(), agents: []structs.Agent{
+		{Instance: "shop", Host: "web2", LastSeen: seen},
+		{Instance: "web1", Host: "web1", LastSeen: seen.Add(-time.Hour), Silent: true},
+	}}
+
+	tt := []struct {
+		name      string
+		store     structs.Storage
+		query     string
+		code      int
+		instances []string
+	}{
+		{name: "all agents", store: store, code: http.StatusOK, instances: []string{"shop", "web1"}},
+		{name: "silent agents", store: store, query: "?silent=true", code: http.StatusOK, instances: []string{"web1"}},
+		{name: "bad silent value", store: store, query: "?silent=maybe", code: http.StatusBadRequest},
+		{name: "agents are not tracked", store: newStorage(), code: http.StatusNotImplemented},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/agents"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept", "application/json")
+			rr := httptest.NewRecorder()
+			GetHandler(config.ServerConfig{AgentTimeout: time.Minute}, tc.store, nil).ServeHTTP(rr, req)
+			if rr.Code != tc.code {
+				t.Fatalf("Expected status code %d, got %d (%s)", tc.code, rr.Code, rr.Body.String())
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			var list structs.AgentList
+			err = json.Unmarshal(rr.Body.Bytes(), &list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var instances []string
+			for _, a := range list.Agents {
+				instances = append(instances, a.Instance)
+			}
+			if strings.Join(instances, ",") != strings.Join(tc.instances, ",") {
+				t.Errorf("agents mismatch: have %v, want %v", instances, tc.instances)
+			}
+		})
+	}
+}
+
+func TestListMetricsHandler(t *testing.T) {
+	store := newStorage()
+	for _, id := range []string{"Alloc", "PollCount", "HeapAlloc"} {
+		v := 1.0
+		store.UpdateMetric(context.Background(), structs.Metric{ID: id, MType: "gauge", Value: &v})
+	}
+	d := int64(1)
+	store.UpdateMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter", Delta: &d})
+
+	tt := []struct {
+		name  string
+		query string
+		code  int
+		ids   []string
+		next  bool
+	}{
+		{name: "all metrics", code: http.StatusOK,
+			ids: []string{"Alloc:gauge", "HeapAlloc:gauge", "PollCount:counter", "PollCount:gauge"}},
+		{name: "by type", query: "?type=counter", code: http.StatusOK, ids: []string{"PollCount:counter"}},
+		{name: "by prefix", query: "?prefix=Poll&sort=type", code: http.StatusOK,
+			ids: []string{"PollCount:counter", "PollCount:gauge"}},
+		{name: "by regex descending", query: "?regex=Alloc$&order=desc", code: http.StatusOK,
+			ids: []string{"HeapAlloc:gauge", "Alloc:gauge"}},
+		// RE2 syntax not supported by database regular expressions
+		{name: "by regex with named group", query: "?regex=" + url.QueryEscape(`^(?P<kind>Heap)?Alloc\z`), code: http.StatusOK,
+			ids: []string{"Alloc:gauge", "HeapAlloc:gauge"}},
+		{name: "first page", query: "?limit=3", code: http.StatusOK,
+			ids: []string{"Alloc:gauge", "HeapAlloc:gauge", "PollCount:counter"}, next: true},
+		{name: "bad type", query: "?type=histogram", code: http.StatusBadRequest},
+		{name: "bad regex", query: "?regex=(", code: http.StatusBadRequest},
+		{name: "bad limit", query: "?limit=0", code: http.StatusBadRequest},
+		{name: "bad cursor", query: "?cursor=!!", code: http.StatusBadRequest},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/api/metrics"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept", "application/json")
+			rr := httptest.NewRecorder()
+			GetHandler(config.ServerConfig{}, store, nil).ServeHTTP(rr, req)
+			if rr.Code != tc.code {
+				t.Fatalf("Expected status code %d, got %d (%s)", tc.code, rr.Code, rr.Body.String())
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			var list structs.MetricList
+			err = json.Unmarshal(rr.Body.Bytes(), &list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, m := range list.Metrics {
+				ids = append(ids, m.ID+":"+m.MType)
+			}
+			if strings.Join(ids, ",") != strings.Join(tc.ids, ",") {
+				t.Errorf("metrics mismatch: have %v, want %v", ids, tc.ids)
+			}
+			if (list.NextCursor != "") != tc.next {
+				t.Errorf("next_cursor mismatch: have %q, want set: %t", list.NextCursor, tc.next)
+			}
+		})
+	}
+}
+
+// contextStorage records whether storage calls are made for a client request
+type contextStorage struct {
+	structs.Storage
+	client bool
+}
+
+func (s *contextStorage) ListMetrics(ctx context.Context, q structs.ListQuery) (structs.MetricList, error) {
+	s.client = structs.IsClientContext(ctx)
+	return s.Storage.ListMetrics(ctx, q)
+}
+
+func TestClientContext(t *testing.T) {
+	store := &contextStorage{Storage: newStorage()}
+	r, err := http.NewRequest("GET", "/api/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	GetHandler(confNoAuth, store, nil).ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if !store.client {
+		t.Error("storage call context is not marked as client request")
+	}
+}
+
+func TestDeleteMetricHandler(t *testing.T) {
+	key := "secret"
+	confKey := config.ServerConfig{Key: key, TrustedSubnet: confNoAuth.TrustedSubnet}
+	confTrusted := config.ServerConfig{TrustedSubnet: net.IPNet{IP: net.IPv4(10, 0, 0, 0),
+		Mask: net.IPv4Mask(255, 0, 0, 0)}}
+	deleteAlloc := structs.AdminRequest{Action: structs.AdminDelete, ID: "Alloc", MType: "gauge"}
+	deleteLoad := structs.AdminRequest{Action: structs.AdminDelete, ID: "load", MType: "gauge"}
+	resetPollCount := structs.AdminRequest{Action: structs.AdminReset, ID: "PollCount", MType: "counter"}
+
+	// series of the metric left after the request, id{labels}:value
+	tt := []struct {
+		name    string
+		method  string
+		url     string
+		request structs.AdminRequest
+		signKey string
+		conf    config.ServerConfig
+		code    int
+		want    []string
+	}{
+		{name: "delete gauge", method: "DELETE", url: "/value/gauge/Alloc", request: deleteAlloc,
+			signKey: key, conf: confKey, code: http.StatusOK, want: []string{}},
+		{name: "delete gauge from trusted network", method: "DELETE", url: "/value/gauge/Alloc",
+			request: deleteAlloc, conf: confTrusted, code: http.StatusOK, want: []string{}},
+		{name: "delete selected series", method: "DELETE", url: "/value/gauge/load",
+			request: structs.AdminRequest{Action: structs.AdminDelete, ID: "load", MType: "gauge",
+				Match: []string{"host=web1"}},
+			signKey: key, conf: confKey, code: http.StatusOK, want: []string{`load{host="web2"}:2.000`}},
+		{name: "delete all series", method: "DELETE", url: "/value/gauge/load", request: deleteLoad,
+			signKey: key, conf: confKey, code: http.StatusOK, want: []string{}},
+		{name: "delete with bad matcher", method: "DELETE", url: "/value/gauge/load",
+			request: structs.AdminRequest{Action: structs.AdminDelete, ID: "load", MType: "gauge",
+				Match: []string{"host"}},
+			signKey: key, conf: confKey, code: http.StatusBadRequest},
+		{name: "delete unknown metric", method: "DELETE", url: "/value/gauge/Unknown",
+			request: structs.AdminRequest{Action: structs.AdminDelete, ID: "Unknown", MType: "gauge"},
+			signKey: key, conf: confKey, code: http.StatusNotFound},
+		{name: "delete bad type", method: "DELETE", url: "/value/histogram/Alloc",
+			request: structs.AdminRequest{Action: structs.AdminDelete, ID: "Alloc", MType: "histogram"},
+			signKey: key, conf: confKey, code: http.StatusNotImplemented},
+		{name: "delete unsigned", method: "DELETE", url: "/value/gauge/Alloc", request: deleteAlloc,
+			conf: confKey, code: http.StatusForbidden},
+		{name: "delete signed with other key", method: "DELETE", url: "/value/gauge/Alloc", request: deleteAlloc,
+			signKey: "other", conf: confKey, code: http.StatusForbidden},
+		{name: "delete signed long ago", method: "DELETE", url: "/value/gauge/Alloc",
+			request: structs.AdminRequest{Action: structs.AdminDelete, ID: "Alloc", MType: "gauge",
+				Timestamp: time.Now().Add(-time.Hour).Unix()},
+			signKey: key, conf: confKey, code: http.StatusForbidden},
+		{name: "delete signed for other matchers", method: "DELETE", url: "/value/gauge/load?match=host%3Dweb2",
+			request: structs.AdminRequest{Action: structs.AdminDelete, ID: "load", MType: "gauge",
+				Match: []string{"host=web1"}},
+			signKey: key, conf: confKey, code: http.StatusForbidden},
+		{name: "delete without key and trusted network", method: "DELETE", url: "/value/gauge/Alloc",
+			request: deleteAlloc, conf: confNoAuth, code: http.StatusForbidden},
+		{name: "delete from untrusted network", method: "DELETE", url: "/value/gauge/Alloc", request: deleteAlloc,
+			conf: confAuth, code: http.StatusForbidden},
+		{name: "reset counter", method: "POST", url: "/reset/PollCount", request: resetPollCount,
+			signKey: key, conf: confKey, code: http.StatusOK,
+			want: []string{`PollCount{host="web1"}:0`, `PollCount{host="web2"}:0`}},
+		{name: "reset selected series", method: "POST", url: "/reset/PollCount",
+			request: structs.AdminRequest{Action: structs.AdminReset, ID: "PollCount", MType: "counter",
+				Match: []string{"host=~web2|web3"}},
+			conf: confTrusted, code: http.StatusOK,
+			want: []string{`PollCount{host="web1"}:3`, `PollCount{host="web2"}:0`}},
+		{name: "reset unknown counter", method: "POST", url: "/reset/Unknown",
+			request: structs.AdminRequest{Action: structs.AdminReset, ID: "Unknown", MType: "counter"},
+			signKey: key, conf: confKey, code: http.StatusNotFound},
+		{name: "reset unsigned", method: "POST", url: "/reset/PollCount", request: resetPollCount,
+			conf: confKey, code: http.StatusForbidden},
+		{name: "reset without key and trusted network", method: "POST", url: "/reset/PollCount",
+			request: resetPollCount, conf: confNoAuth, code: http.StatusForbidden},
+		{name: "reset from untrusted network", method: "POST", url: "/reset/PollCount", request: resetPollCount,
+			conf: confAuth, code: http.StatusForbidden},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newStorage()
+			v1, v2 := 1.0, 2.0
+			d := int64(3)
+			store.UpdateMetrics(context.Background(), []structs.Metric{
+				{ID: "Alloc", MType: "gauge", Value: &v1},
+				{ID: "load", MType: "gauge", Value: &v1, Labels: structs.Labels{"host": "web1"}},
+				{ID: "load", MType: "gauge", Value: &v2, Labels: structs.Labels{"host": "web2"}},
+				{ID: "PollCount", MType: "counter", Delta: &d, Labels: structs.Labels{"host": "web1"}},
+				{ID: "PollCount", MType: "counter", Delta: &d, Labels: structs.Labels{"host": "web2"}}})
+
+			if tc.request.Timestamp == 0 {
+				tc.request.Timestamp = time.Now().Unix()
+			}
+			q := url.Values{"ts": []string{strconv.FormatInt(tc.request.Timestamp, 10)}}
+			if tc.signKey != "" {
+				q.Set("hash", tc.request.CalculateHash(tc.signKey))
+			}
+			u := tc.url
+			if !strings.Contains(u, "?") {
+				q["match"] = tc.request.Match
+				u += "?" + q.Encode()
+			} else {
+				u += "&" + q.Encode()
+			}
+			req, err := http.NewRequest(tc.method, u, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Real-IP", "10.0.0.1")
+			rr := httptest.NewRecorder()
+			GetHandler(tc.conf, store, nil).ServeHTTP(rr, req)
+			if rr.Code != tc.code {
+				t.Fatalf("Expected status code %d, got %d (%s)", tc.code, rr.Code, rr.Body.String())
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			metrics, err := store.GetMetrics(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := []string{}
+			for _, m := range metrics {
+				if m.ID == tc.request.ID {
+					have = append(have, m.ID+m.Labels.String()+":"+m.AsText())
+				}
+			}
+			sort.Strings(have)
+			if strings.Join(have, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("series mismatch: have %v, want %v", have, tc.want)
+			}
+		})
+	}
+}
+
 func TestRootHandler(t *testing.T) {
 
 	type want struct {
@@ -598,7 +1067,7 @@ func TestRootHandler(t *testing.T) {
 				t.Fatal(err)
 			}
 			rr := httptest.NewRecorder()
-			router := GetHandler(config.ServerConfig{}, structs.NewMemoryStorage(), nil)
+			router := GetHandler(config.ServerConfig{}, newStorage(), nil)
 			router.ServeHTTP(rr, req)
 			res := rr.Result()
 
@@ -622,7 +1091,423 @@ func TestRootHandler(t *testing.T) {
 func TestGetHandler(t *testing.T) {
 	name := "testing GetHandler"
 	t.Run(name, func(t *testing.T) {
-		GetHandler(config.ServerConfig{}, structs.NewMemoryStorage(), nil)
+		GetHandler(config.ServerConfig{}, newStorage(), nil)
 	})
 
 }
+
+func TestGetMetricHistoryHandler(t *testing.T) {
+	storage := newStorageWithHistory()
+	counter := int64(2)
+	gauge := float64(1.5)
+	metrics := []structs.Metric{
+		{MType: "counter", ID: "testCounter", Delta: &counter},
+		{MType: "counter", ID: "testCounter", Delta: &counter},
+		{MType: "gauge", ID: "testGauge", Value: &gauge},
+	}
+	err := storage.UpdateMetrics(context.Background(), metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		samples []string
+		code    int
+	}
+
+	tt := []struct {
+		name   string
+		path   string
+		router http.Handler
+		want   want
+	}{
+		{
+			name:   "counter history",
+			path:   "/history/counter/testCounter",
+			router: GetHandler(config.ServerConfig{}, storage, nil),
+			want:   want{code: 200, samples: []string{"2", "4"}},
+		},
+		{
+			name:   "gauge history",
+			path:   "/history/gauge/testGauge?from=0",
+			router: GetHandler(config.ServerConfig{}, storage, nil),
+			want:   want{code: 200, samples: []string{"1.500"}},
+		},
+		{
+			name:   "empty range",
+			path:   "/history/gauge/testGauge?to=2000-01-01T00:00:00Z",
+			router: GetHandler(config.ServerConfig{}, storage, nil),
+			want:   want{code: 200, samples: []string{}},
+		},
+		{
+			name:   "unknown metric",
+			path:   "/history/gauge/unknown",
+			router: GetHandler(config.ServerConfig{}, storage, nil),
+			want:   want{code: 404},
+		},
+		{
+			name:   "bad time range",
+			path:   "/history/gauge/testGauge?from=yesterday",
+			router: GetHandler(config.ServerConfig{}, storage, nil),
+			want:   want{code: 400},
+		},
+		{
+			name:   "bad resolution",
+			path:   "/history/gauge/testGauge?resolution=5s",
+			router: GetHandler(config.ServerConfig{}, storage, nil),
+			want:   want{code: 400},
+		},
+		{
+			name:   "no rollups yet",
+			path:   "/history/gauge/testGauge?resolution=1m",
+			router: GetHandler(config.ServerConfig{}, storage, nil),
+			want:   want{code: 200, samples: []string{}},
+		},
+		{
+			name:   "history is disabled",
+			path:   "/history/gauge/testGauge",
+			router: GetHandler(config.ServerConfig{}, newStorage(), nil),
+			want:   want{code: 501},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tc.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept", "application/json")
+			rr := httptest.NewRecorder()
+			tc.router.ServeHTTP(rr, req)
+			res := rr.Result()
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.want.code {
+				t.Fatalf("Expected status code %d, got %d", tc.want.code, rr.Code)
+			}
+			if tc.want.code != http.StatusOK {
+				return
+			}
+
+			var history structs.MetricHistory
+			err = json.NewDecoder(res.Body).Decode(&history)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history.Samples) != len(tc.want.samples) {
+				t.Fatalf("Expected %d samples, got %d", len(tc.want.samples), len(history.Samples))
+			}
+			for i, s := range history.Samples {
+				var have string
+				if s.Delta != nil {
+					have = fmt.Sprintf("%d", *s.Delta)
+				} else {
+					have = fmt.Sprintf("%.3f", *s.Value)
+				}
+				if have != tc.want.samples[i] {
+					t.Errorf("Sample %d mismatch: have %s, want %s", i, have, tc.want.samples[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	store := newStorage()
+	delta := int64(3)
+	value := float64(1.5)
+	err := store.UpdateMetrics(context.Background(), []structs.Metric{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := GetHandler(confNoAuth, store, nil)
+
+	tt := []struct {
+		name        string
+		accept      string
+		contentType string
+		contains    []string
+	}{
+		{
+			name:        "text format",
+			contentType: "text/plain; version=0.0.4; charset=utf-8",
+			contains:    []string{"# TYPE PollCount_total counter\nPollCount_total 3\n", "Alloc 1.5\n"},
+		},
+		{
+			name:        "OpenMetrics format",
+			accept:      "application/openmetrics-text; version=1.0.0,text/plain;q=0.5",
+			contentType: "application/openmetrics-text; version=1.0.0; charset=utf-8",
+			contains:    []string{"# TYPE PollCount counter\nPollCount_total 3\n", "# EOF\n"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/metrics", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+			}
+			if have := rr.Header().Get("Content-Type"); have != tc.contentType {
+				t.Errorf("Content-Type mismatch: have %s, want %s", have, tc.contentType)
+			}
+			for _, s := range tc.contains {
+				if !strings.Contains(rr.Body.String(), s) {
+					t.Errorf("Body '%s' does not contain %s", rr.Body.String(), s)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteWriteHandler(t *testing.T) {
+	req := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+		Labels:  []*prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+		Samples: []*prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}}
+	b, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "secret"
+	routerKey := GetHandler(config.ServerConfig{Key: key, TrustedSubnet: confNoAuth.TrustedSubnet}, newStorage(), nil)
+	body := snappy.Encode(nil, b)
+
+	tt := []struct {
+		name   string
+		body   []byte
+		hash   string
+		router http.Handler
+		want   int
+	}{
+		{name: "valid request", body: body, router: routerNoAuth, want: http.StatusOK},
+		{name: "invalid body", body: []byte("not snappy"), router: routerNoAuth, want: http.StatusBadRequest},
+		{name: "untrusted subnet", body: body, router: routerAuth, want: http.StatusForbidden},
+		{name: "signed request", body: body, hash: hash.Sign(key, string(body)), router: routerKey, want: http.StatusOK},
+		{name: "missing signature", body: body, router: routerKey, want: http.StatusBadRequest},
+		{name: "invalid signature", body: body, hash: hash.Sign("wrong", string(body)), router: routerKey,
+			want: http.StatusBadRequest},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := http.NewRequest("POST", "/api/v1/write", bytes.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.hash != "" {
+				r.Header.Set(bodyHashHeader, tc.hash)
+			}
+			r.Header.Set("Content-Encoding", "snappy")
+			r.Header.Set("Content-Type", "application/x-protobuf")
+			r.Header.Set(prom.RemoteWriteVersionHeader, "0.1.0")
+			rr := httptest.NewRecorder()
+			tc.router.ServeHTTP(rr, r)
+			if rr.Code != tc.want {
+				t.Errorf("Expected status code %d, got %d (%s)", tc.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAgentsRegistration(t *testing.T) {
+	tracker := agents.NewTracker(newStorage())
+	router := GetHandler(confNoAuth, tracker, nil)
+	send := func(path string, body []byte, headers map[string]string) {
+		r, err := http.NewRequest("POST", path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s status code mismatch: have %d, want %d (%s)", path, rr.Code, http.StatusOK, rr.Body.String())
+		}
+	}
+
+	v := 1.5
+	body, err := json.Marshal([]structs.Metric{{ID: "Alloc", MType: "gauge", Value: &v,
+		Labels: structs.Labels{"host": "web1", "instance": "web1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send("/updates/", body, map[string]string{"Content-Type": "application/json"})
+
+	// remote_write series carry instance label too, but they are not sent by agents
+	b, err := proto.Marshal(&prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+		Labels:  []*prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "node:9100"}},
+		Samples: []*prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send("/api/v1/write", snappy.Encode(nil, b), map[string]string{"Content-Encoding": "snappy",
+		"Content-Type": "application/x-protobuf", prom.RemoteWriteVersionHeader: "0.1.0"})
+
+	have := tracker.Agents(time.Minute)
+	if len(have) != 1 || have[0].Instance != "web1" {
+		t.Errorf("agents mismatch: have %v, want web1 only", have)
+	}
+}
+
+func TestInfluxWriteHandler(t *testing.T) {
+	conf := confNoAuth
+	conf.InfluxCounters = []string{"http_requests"}
+	store := newStorage()
+	router := GetHandler(conf, store, nil)
+
+	gzipped, err := archive.Compress([]byte("http,code=200 requests=5i,latency=0.25\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "secret"
+	confKey := conf
+	confKey.Key = key
+	routerKey := GetHandler(confKey, store, nil)
+	line := []byte("http,code=200 requests=5i,latency=0.25 1000")
+
+	tt := []struct {
+		name     string
+		body     []byte
+		encoding string
+		hash     string
+		router   http.Handler
+		want     int
+	}{
+		{name: "plain body", body: line, router: router, want: http.StatusNoContent},
+		{name: "gzip body", body: gzipped, encoding: "gzip", router: router, want: http.StatusNoContent},
+		{name: "invalid line", body: []byte("http,code=200"), router: router, want: http.StatusBadRequest},
+		{name: "signed gzip body", body: gzipped, encoding: "gzip", hash: hash.Sign(key, string(gzipped)),
+			router: routerKey, want: http.StatusNoContent},
+		{name: "missing signature", body: line, router: routerKey, want: http.StatusBadRequest},
+		{name: "invalid signature", body: line, hash: hash.Sign(key, "other body"), router: routerKey,
+			want: http.StatusBadRequest},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := http.NewRequest("POST", "/write?precision=ns", bytes.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.encoding != "" {
+				r.Header.Set("Content-Encoding", tc.encoding)
+			}
+			if tc.hash != "" {
+				r.Header.Set(bodyHashHeader, tc.hash)
+			}
+			rr := httptest.NewRecorder()
+			tc.router.ServeHTTP(rr, r)
+			if rr.Code != tc.want {
+				t.Errorf("Expected status code %d, got %d (%s)", tc.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	m, err := store.GetMetric(context.Background(), structs.Metric{ID: "http_requests", MType: "counter",
+		Labels: structs.Labels{"code": "200"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *m.Delta != 15 {
+		t.Errorf("counter mismatch: have %d, want 15", *m.Delta)
+	}
+	m, err = store.GetMetric(context.Background(), structs.Metric{ID: "http_latency", MType: "gauge",
+		Labels: structs.Labels{"code": "200"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *m.Value != 0.25 {
+		t.Errorf("gauge mismatch: have %f, want 0.25", *m.Value)
+	}
+}
+
+func TestOTLPHandler(t *testing.T) {
+	store := newStorage()
+	router := GetHandler(confNoAuth, store, nil)
+
+	req := &colpb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+			{Name: "requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				IsMonotonic:            true,
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				DataPoints: []*metricspb.NumberDataPoint{
+					{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 2}}}}}},
+		}}},
+	}}}
+	protoBody, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonBody, err := protojson.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "secret"
+	routerKey := GetHandler(config.ServerConfig{Key: key, TrustedSubnet: confNoAuth.TrustedSubnet}, store, nil)
+
+	tt := []struct {
+		name        string
+		body        []byte
+		contentType string
+		hash        string
+		router      http.Handler
+		want        int
+	}{
+		{name: "protobuf", body: protoBody, contentType: "application/x-protobuf", router: router, want: http.StatusOK},
+		{name: "json", body: jsonBody, contentType: "application/json", router: router, want: http.StatusOK},
+		{name: "invalid body", body: []byte("{"), contentType: "application/json", router: router,
+			want: http.StatusBadRequest},
+		{name: "unsupported content type", body: jsonBody, contentType: "text/plain", router: router,
+			want: http.StatusUnsupportedMediaType},
+		{name: "signed", body: protoBody, contentType: "application/x-protobuf", hash: hash.Sign(key, string(protoBody)),
+			router: routerKey, want: http.StatusOK},
+		{name: "missing signature", body: protoBody, contentType: "application/x-protobuf", router: routerKey,
+			want: http.StatusBadRequest},
+		{name: "invalid signature", body: jsonBody, contentType: "application/json", hash: hash.Sign(key, string(protoBody)),
+			router: routerKey, want: http.StatusBadRequest},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := http.NewRequest("POST", "/v1/metrics", bytes.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Content-Type", tc.contentType)
+			if tc.hash != "" {
+				r.Header.Set(bodyHashHeader, tc.hash)
+			}
+			rr := httptest.NewRecorder()
+			tc.router.ServeHTTP(rr, r)
+			if rr.Code != tc.want {
+				t.Errorf("Expected status code %d, got %d (%s)", tc.want, rr.Code, rr.Body.String())
+			}
+			if tc.want == http.StatusOK && rr.Header().Get("Content-Type") != tc.contentType {
+				t.Errorf("Content-Type mismatch: have %s, want %s", rr.Header().Get("Content-Type"), tc.contentType)
+			}
+		})
+	}
+
+	m, err := store.GetMetric(context.Background(), structs.Metric{ID: "requests", MType: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *m.Delta != 6 {
+		t.Errorf("counter mismatch: have %d, want 6", *m.Delta)
+	}
+}
@@ -0,0 +1,250 @@
+// Package influx implements InfluxDB line protocol support
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// precisions maps `precision` request parameter (v1 and v2 API forms) to timestamp unit
+var precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// Mapping decides which metric type integer fields are stored as.
+// Counters are glob patterns (path.Match syntax) matched against measurement_field name,
+// matched fields are added to counters as deltas, other integer fields are stored as gauges
+type Mapping struct {
+	Counters []string
+}
+
+func (m Mapping) isCounter(name string) bool {
+	for _, p := range m.Counters {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// point is a metric with line timestamp
+type point struct {
+	metric    structs.Metric
+	timestamp int64
+}
+
+// Parse converts line protocol body to metrics.
+// Each field becomes a metric with measurement_field id, tags become metric labels.
+// Float fields are stored as gauges, booleans as 0/1 gauges, string fields are skipped.
+// Metrics are returned in timestamp order, lines without timestamp get the receive time
+func Parse(body []byte, precision string, mapping Mapping) ([]structs.Metric, error) {
+	unit, ok := precisions[precision]
+	if !ok {
+		return []structs.Metric{}, fmt.Errorf("%w: unsupported precision %s",
+			structs.ErrMetricBadAttrValue, precision)
+	}
+	now := time.Now().UnixNano()
+
+	var points []point
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		linePoints, err := parseLine(line, unit, now, mapping)
+		if err != nil {
+			return []structs.Metric{}, fmt.Errorf("line %d: %w", n, err)
+		}
+		points = append(points, linePoints...)
+	}
+	if err := scanner.Err(); err != nil {
+		return []structs.Metric{}, fmt.Errorf("%w: failed to read body: %s",
+			structs.ErrMetricBadAttrValue, err.Error())
+	}
+
+	sort.SliceStable(points, func(i, j int) bool { return points[i].timestamp < points[j].timestamp })
+	metrics := make([]structs.Metric, 0, len(points))
+	for _, p := range points {
+		metrics = append(metrics, p.metric)
+	}
+	return metrics, nil
+}
+
+// scan reads token until one of unescaped stop characters and returns it with the stop position.
+// Backslash escapes commas, equal signs and spaces
+func scan(s string, i int, stops string) (string, int) {
+	var b strings.Builder
+	for ; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) && strings.IndexByte(", =", s[i+1]) >= 0 {
+			b.WriteByte(s[i+1])
+			i++
+			continue
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			break
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), i
+}
+
+func badLine(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", structs.ErrMetricBadAttrValue, fmt.Sprintf(format, a...))
+}
+
+func parseLine(line string, unit time.Duration, now int64, mapping Mapping) ([]point, error) {
+	measurement, i := scan(line, 0, ", ")
+	if measurement == "" {
+		return nil, fmt.Errorf("%w: line has no measurement", structs.ErrMetricNullAttr)
+	}
+
+	// tag set
+	var tags structs.Labels
+	for i < len(line) && line[i] == ',' {
+		var key, value string
+		key, i = scan(line, i+1, "=, ")
+		if i >= len(line) || line[i] != '=' || key == "" {
+			return nil, badLine("bad tag in measurement %s", measurement)
+		}
+		value, i = scan(line, i+1, ", ")
+		if value == "" {
+			return nil, badLine("tag %s has no value", key)
+		}
+		if tags == nil {
+			tags = structs.Labels{}
+		}
+		tags[key] = value
+	}
+	if i >= len(line) || line[i] != ' ' {
+		return nil, fmt.Errorf("%w: measurement %s has no fields", structs.ErrMetricNullAttr, measurement)
+	}
+
+	// field set
+	var metrics []structs.Metric
+	for {
+		var key, raw string
+		var quoted bool
+		key, i = scan(line, i+1, "= ")
+		if i >= len(line) || line[i] != '=' || key == "" {
+			return nil, badLine("bad field in measurement %s", measurement)
+		}
+		raw, i, quoted = scanValue(line, i+1)
+		if raw == "" && !quoted {
+			return nil, badLine("field %s has no value", key)
+		}
+		if !quoted {
+			m, err := fieldMetric(measurement+"_"+key, raw, mapping)
+			if err != nil {
+				return nil, err
+			}
+			m.Labels = tags
+			metrics = append(metrics, m)
+		}
+		if i >= len(line) || line[i] != ',' {
+			break
+		}
+	}
+
+	// timestamp
+	timestamp := now
+	if rest := strings.TrimSpace(line[i:]); rest != "" {
+		ts, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return nil, badLine("bad timestamp %s", rest)
+		}
+		if ts > math.MaxInt64/int64(unit) || ts < math.MinInt64/int64(unit) {
+			return nil, badLine("timestamp %s is out of range", rest)
+		}
+		timestamp = ts * int64(unit)
+	}
+
+	points := make([]point, 0, len(metrics))
+	for _, m := range metrics {
+		points = append(points, point{metric: m, timestamp: timestamp})
+	}
+	return points, nil
+}
+
+// scanValue reads field value. String values are returned with quoted set
+func scanValue(s string, i int) (string, int, bool) {
+	if i >= len(s) || s[i] != '"' {
+		v, next := scan(s, i, ", ")
+		return v, next, false
+	}
+	var b strings.Builder
+	for i++; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+			b.WriteByte(s[i+1])
+			i++
+			continue
+		}
+		if s[i] == '"' {
+			return b.String(), i + 1, true
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), i, true
+}
+
+func fieldMetric(name, raw string, mapping Mapping) (structs.Metric, error) {
+	last := raw[len(raw)-1]
+	if last == 'i' || last == 'u' {
+		var delta int64
+		var err error
+		if last == 'i' {
+			delta, err = strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		} else {
+			var u uint64
+			u, err = strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+			if err == nil && u > math.MaxInt64 {
+				err = fmt.Errorf("value is out of range")
+			}
+			delta = int64(u)
+		}
+		if err != nil {
+			return structs.Metric{}, badLine("field %s has bad integer value %s: %s", name, raw, err.Error())
+		}
+		if mapping.isCounter(name) {
+			return structs.Metric{ID: name, MType: "counter", Delta: &delta}, nil
+		}
+		value := float64(delta)
+		return structs.Metric{ID: name, MType: "gauge", Value: &value}, nil
+	}
+
+	var value float64
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		value = 1
+	case "f", "F", "false", "False", "FALSE":
+		value = 0
+	default:
+		var err error
+		value, err = strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return structs.Metric{}, badLine("field %s has bad value %s", name, raw)
+		}
+	}
+	return structs.Metric{ID: name, MType: "gauge", Value: &value}, nil
+}
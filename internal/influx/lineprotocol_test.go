@@ -0,0 +1,76 @@
+package influx
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// value returns metric value as float for comparison
+func value(m structs.Metric) float64 {
+	if m.MType == "counter" {
+		return float64(*m.Delta)
+	}
+	return *m.Value
+}
+
+func TestParse(t *testing.T) {
+	mapping := Mapping{Counters: []string{"net_bytes_*"}}
+	// series is metric id with labels in canonical form
+	type want struct {
+		series string
+		mtype  string
+		value  float64
+	}
+	tt := []struct {
+		name      string
+		body      string
+		precision string
+		want      []want
+		wantErr   error
+	}{
+		{name: "float field", body: "cpu usage_idle=97.5",
+			want: []want{{"cpu_usage_idle", "gauge", 97.5}}},
+		{name: "tags become labels", body: "cpu,host=a,cpu=cpu0 usage_idle=1 1000",
+			want: []want{{`cpu_usage_idle{cpu="cpu0",host="a"}`, "gauge", 1}}},
+		{name: "integer mapping", body: "net,iface=eth0 bytes_recv=10i,packets_recv=2i,err_in=1u",
+			want: []want{{`net_bytes_recv{iface="eth0"}`, "counter", 10},
+				{`net_packets_recv{iface="eth0"}`, "gauge", 2},
+				{`net_err_in{iface="eth0"}`, "gauge", 1}}},
+		{name: "booleans and strings", body: `system up=true,down=F,name="web, 1",load=0.5`,
+			want: []want{{"system_up", "gauge", 1}, {"system_down", "gauge", 0}, {"system_load", "gauge", 0.5}}},
+		{name: "escaped characters", body: `disk\ io,path=/mnt/my\ disk reads\=total=3`,
+			want: []want{{`disk io_reads=total{path="/mnt/my disk"}`, "gauge", 3}}},
+		{name: "sorted by timestamp", precision: "s",
+			body: "# comment\nmem used=2 20\n\nmem used=1 10\n",
+			want: []want{{"mem_used", "gauge", 1}, {"mem_used", "gauge", 2}}},
+		{name: "no fields", body: "cpu,host=a", wantErr: structs.ErrMetricNullAttr},
+		{name: "bad field", body: "cpu usage", wantErr: structs.ErrMetricBadAttrValue},
+		{name: "bad integer", body: "cpu usage=1.5i", wantErr: structs.ErrMetricBadAttrValue},
+		{name: "bad timestamp", body: "cpu usage=1 now", wantErr: structs.ErrMetricBadAttrValue},
+		{name: "timestamp overflow", body: "cpu usage=1 9223372036854775", precision: "s",
+			wantErr: structs.ErrMetricBadAttrValue},
+		{name: "negative timestamp overflow", body: "cpu usage=1 -153722867281", precision: "m",
+			wantErr: structs.ErrMetricBadAttrValue},
+		{name: "bad precision", body: "cpu usage=1", precision: "d", wantErr: structs.ErrMetricBadAttrValue},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics, err := Parse([]byte(tc.body), tc.precision, mapping)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tc.wantErr)
+			}
+			if len(metrics) != len(tc.want) {
+				t.Fatalf("metrics count mismatch: have %d, want %d (%v)", len(metrics), len(tc.want), metrics)
+			}
+			for i, w := range tc.want {
+				m := metrics[i]
+				if m.ID+m.Labels.String() != w.series || m.MType != w.mtype || value(m) != w.value {
+					t.Errorf("metric %d mismatch: have %s%s/%s/%f, want %s/%s/%f",
+						i, m.ID, m.Labels, m.MType, value(m), w.series, w.mtype, w.value)
+				}
+			}
+		})
+	}
+}
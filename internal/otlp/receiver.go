@@ -0,0 +1,416 @@
+// Package otlp implements OpenTelemetry metrics receiver: OTLP data points are translated
+// to counters and gauges and stored in structs.Storage
+package otlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	colpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// serviceNameKey is the only resource attribute added to metric labels
+const serviceNameKey = "service.name"
+
+// staleAfter is how long cumulative state of a series is kept after its last data point
+const staleAfter = time.Hour
+
+const (
+	cumulative = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	noValue    = uint32(metricspb.DataPointFlags_FLAG_NO_RECORDED_VALUE)
+)
+
+// cumulativeState is the last data point of a cumulative series
+type cumulativeState struct {
+	start uint64
+	value float64
+	seen  time.Time
+}
+
+// cumulativePoint is a data point of cumulative counter at position index of the batch metrics
+type cumulativePoint struct {
+	index int
+	key   string
+	state *cumulativeState
+}
+
+// seriesLock serializes exports updating the same series, refs is the number of exports holding or waiting for it
+type seriesLock struct {
+	mx   sync.Mutex
+	refs int
+}
+
+// Receiver converts OTLP metrics. Cumulative sums are converted to counter deltas
+// using the previous data point of the series, so the same receiver must serve all exports
+// of a series. Exports updating the same series are serialized
+type Receiver struct {
+	store       structs.Storage
+	started     uint64
+	mx          sync.Mutex
+	series      map[string]*cumulativeState
+	locks       map[string]*seriesLock
+	lastCleanup time.Time
+}
+
+func NewReceiver(store structs.Storage) *Receiver {
+	now := time.Now()
+	return &Receiver{store: store, started: uint64(now.UnixNano()),
+		series: map[string]*cumulativeState{}, locks: map[string]*seriesLock{}, lastCleanup: now}
+}
+
+// batch collects converted metrics and rejected data points of a single export.
+// Values depending on the previous state of the series are calculated once the series are locked
+type batch struct {
+	metrics  []structs.Metric
+	rejected int64
+	errors   []string
+	// relative holds positions of gauges updated by deltas (by series key), so repeated deltas add up.
+	// Stored values are added to the deltas by resolve
+	relative map[string]int
+	// cumulative holds data points of cumulative counters in order, deltas are calculated by resolve
+	cumulative []cumulativePoint
+	// series holds cumulative state (by series key) to be saved once metrics are stored
+	series map[string]*cumulativeState
+}
+
+func (b *batch) reject(count int, format string, a ...interface{}) {
+	b.rejected += int64(count)
+	b.errors = append(b.errors, fmt.Sprintf(format, a...))
+}
+
+// Export stores metrics from the request. Unsupported data points (exponential histograms, summaries)
+// are reported in response partial success, storage errors fail the whole request
+func (r *Receiver) Export(ctx context.Context,
+	req *colpb.ExportMetricsServiceRequest) (*colpb.ExportMetricsServiceResponse, error) {
+	r.cleanup()
+	b := &batch{relative: map[string]int{}, series: map[string]*cumulativeState{}}
+	for _, rm := range req.GetResourceMetrics() {
+		resource := map[string]string{}
+		for _, kv := range rm.GetResource().GetAttributes() {
+			if kv.GetKey() == serviceNameKey {
+				resource[kv.GetKey()] = anyValue(kv.GetValue())
+			}
+		}
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				r.convert(m, resource, b)
+			}
+		}
+	}
+
+	// series are locked from reading their previous state until the new state is saved
+	unlock := r.lockSeries(b.lockKeys())
+	defer unlock()
+	metrics := r.resolve(ctx, b)
+	if len(metrics) > 0 {
+		err := r.store.UpdateMetrics(ctx, metrics)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.mx.Lock()
+	for key, state := range b.series {
+		r.series[key] = state
+	}
+	r.mx.Unlock()
+
+	resp := &colpb.ExportMetricsServiceResponse{}
+	if b.rejected > 0 {
+		resp.PartialSuccess = &colpb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: b.rejected, ErrorMessage: strings.Join(b.errors, "; ")}
+	}
+	return resp, nil
+}
+
+func (r *Receiver) convert(m *metricspb.Metric, resource map[string]string, b *batch) {
+	name := m.GetName()
+	if name == "" {
+		b.reject(dataPointsCount(m), "metric has no name")
+		return
+	}
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, p := range data.Gauge.GetDataPoints() {
+			if p.GetFlags()&noValue != 0 {
+				continue
+			}
+			b.metrics = append(b.metrics, gauge(name, seriesLabels(resource, p.GetAttributes(), ""), numberValue(p)))
+		}
+	case *metricspb.Metric_Sum:
+		temporality := data.Sum.GetAggregationTemporality()
+		for _, p := range data.Sum.GetDataPoints() {
+			if p.GetFlags()&noValue != 0 {
+				continue
+			}
+			labels := seriesLabels(resource, p.GetAttributes(), "")
+			switch {
+			case data.Sum.GetIsMonotonic():
+				b.metrics = append(b.metrics,
+					r.counter(name, labels, temporality, p.GetStartTimeUnixNano(), numberValue(p), b))
+			case temporality == cumulative:
+				b.metrics = append(b.metrics, gauge(name, labels, numberValue(p)))
+			default:
+				r.addToGauge(name, labels, numberValue(p), b)
+			}
+		}
+	case *metricspb.Metric_Histogram:
+		temporality := data.Histogram.GetAggregationTemporality()
+		for _, p := range data.Histogram.GetDataPoints() {
+			if p.GetFlags()&noValue != 0 {
+				continue
+			}
+			r.histogram(name, resource, temporality, p, b)
+		}
+	default:
+		b.reject(dataPointsCount(m), "metric %s has unsupported type", name)
+	}
+}
+
+// histogram stores data point as <name>_count and cumulative <name>_bucket{le="..."} counters
+// and <name>_sum gauge
+func (r *Receiver) histogram(name string, resource map[string]string,
+	temporality metricspb.AggregationTemporality, p *metricspb.HistogramDataPoint, b *batch) {
+	bounds, counts := p.GetExplicitBounds(), p.GetBucketCounts()
+	if len(counts) != 0 && len(counts) != len(bounds)+1 {
+		b.reject(1, "histogram %s has %d buckets for %d bounds", name, len(counts), len(bounds))
+		return
+	}
+	start := p.GetStartTimeUnixNano()
+	attrs := p.GetAttributes()
+
+	labels := seriesLabels(resource, attrs, "")
+	b.metrics = append(b.metrics,
+		r.counter(name+"_count", labels, temporality, start, float64(p.GetCount()), b))
+	var total uint64
+	for i, c := range counts {
+		total += c
+		le := "+Inf"
+		if i < len(bounds) {
+			le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		}
+		b.metrics = append(b.metrics,
+			r.counter(name+"_bucket", seriesLabels(resource, attrs, le), temporality, start, float64(total), b))
+	}
+	if temporality == cumulative {
+		b.metrics = append(b.metrics, gauge(name+"_sum", labels, p.GetSum()))
+	} else {
+		r.addToGauge(name+"_sum", labels, p.GetSum(), b)
+	}
+}
+
+// counter converts monotonic value to counter delta. Delta of cumulative value is calculated by resolve
+func (r *Receiver) counter(name string, labels structs.Labels, temporality metricspb.AggregationTemporality,
+	start uint64, value float64, b *batch) structs.Metric {
+	if temporality != cumulative {
+		return counter(name, labels, int64(math.Round(value)))
+	}
+	b.cumulative = append(b.cumulative, cumulativePoint{index: len(b.metrics), key: structs.SeriesKey(name, labels),
+		state: &cumulativeState{start: start, value: value, seen: time.Now()}})
+	return counter(name, labels, 0)
+}
+
+// addToGauge adds delta of non-monotonic sum to the gauge, the stored value is added by resolve
+func (r *Receiver) addToGauge(name string, labels structs.Labels, delta float64, b *batch) {
+	key := structs.SeriesKey(name, labels)
+	if i, ok := b.relative[key]; ok {
+		*b.metrics[i].Value += delta
+		return
+	}
+	b.relative[key] = len(b.metrics)
+	b.metrics = append(b.metrics, gauge(name, labels, delta))
+}
+
+// lockKeys returns keys of the series whose previous state is used by the batch
+func (b *batch) lockKeys() []string {
+	var keys []string
+	for _, p := range b.cumulative {
+		keys = append(keys, "counter:"+p.key)
+	}
+	for key := range b.relative {
+		keys = append(keys, "gauge:"+key)
+	}
+	return keys
+}
+
+// lockSeries locks the series and returns function unlocking them.
+// Series are locked in keys order, so exports locking the same series can't deadlock
+func (r *Receiver) lockSeries(keys []string) func() {
+	sort.Strings(keys)
+	var locked []string
+	var locks []*seriesLock
+	r.mx.Lock()
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		l, ok := r.locks[key]
+		if !ok {
+			l = &seriesLock{}
+			r.locks[key] = l
+		}
+		l.refs++
+		locked = append(locked, key)
+		locks = append(locks, l)
+	}
+	r.mx.Unlock()
+
+	for _, l := range locks {
+		l.mx.Lock()
+	}
+	return func() {
+		r.mx.Lock()
+		defer r.mx.Unlock()
+		for i, l := range locks {
+			l.mx.Unlock()
+			l.refs--
+			if l.refs == 0 {
+				delete(r.locks, locked[i])
+			}
+		}
+	}
+}
+
+// resolve calculates values depending on the previous state of the locked series and returns metrics to store.
+// Cumulative values are subtracted from the previous data point of the series. The first data point of a series
+// that started before the receiver only sets the baseline. Stored gauge values are added to the deltas
+func (r *Receiver) resolve(ctx context.Context, b *batch) []structs.Metric {
+	for _, p := range b.cumulative {
+		prev, ok := b.series[p.key]
+		if !ok {
+			r.mx.Lock()
+			prev, ok = r.series[p.key]
+			r.mx.Unlock()
+		}
+		b.series[p.key] = p.state
+		var delta int64
+		switch {
+		case !ok && p.state.start != 0 && p.state.start >= r.started:
+			delta = int64(math.Round(p.state.value))
+		case !ok:
+		case p.state.start != prev.start || p.state.value < prev.value:
+			// series was reset
+			delta = int64(math.Round(p.state.value))
+		default:
+			delta = int64(math.Round(p.state.value)) - int64(math.Round(prev.value))
+		}
+		b.metrics[p.index].Delta = &delta
+	}
+
+	failed := map[int]bool{}
+	for _, i := range b.relative {
+		m := b.metrics[i]
+		current, err := r.store.GetMetric(ctx, structs.Metric{ID: m.ID, MType: "gauge", Labels: m.Labels})
+		switch {
+		case err == nil:
+			*m.Value += *current.Value
+		case errors.Is(err, structs.ErrMetricNotFound):
+		default:
+			b.reject(1, "failed to get gauge %s%s: %s", m.ID, m.Labels, err.Error())
+			failed[i] = true
+		}
+	}
+	if len(failed) == 0 {
+		return b.metrics
+	}
+	metrics := make([]structs.Metric, 0, len(b.metrics)-len(failed))
+	for i, m := range b.metrics {
+		if !failed[i] {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// cleanup forgets cumulative series that were not updated for staleAfter
+func (r *Receiver) cleanup() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if time.Since(r.lastCleanup) < staleAfter {
+		return
+	}
+	for key, s := range r.series {
+		if time.Since(s.seen) > staleAfter {
+			delete(r.series, key)
+		}
+	}
+	r.lastCleanup = time.Now()
+	log.Printf("INFO otlp tracking %d cumulative series", len(r.series))
+}
+
+func counter(name string, labels structs.Labels, delta int64) structs.Metric {
+	return structs.Metric{ID: name, MType: "counter", Delta: &delta, Labels: labels}
+}
+
+func gauge(name string, labels structs.Labels, value float64) structs.Metric {
+	return structs.Metric{ID: name, MType: "gauge", Value: &value, Labels: labels}
+}
+
+func numberValue(p *metricspb.NumberDataPoint) float64 {
+	if v, ok := p.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return p.GetAsDouble()
+}
+
+func dataPointsCount(m *metricspb.Metric) int {
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		return len(data.Gauge.GetDataPoints())
+	case *metricspb.Metric_Sum:
+		return len(data.Sum.GetDataPoints())
+	case *metricspb.Metric_Histogram:
+		return len(data.Histogram.GetDataPoints())
+	case *metricspb.Metric_ExponentialHistogram:
+		return len(data.ExponentialHistogram.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		return len(data.Summary.GetDataPoints())
+	}
+	return 0
+}
+
+func anyValue(v *commonpb.AnyValue) string {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(value.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(value.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(value.DoubleValue, 'g', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// seriesLabels converts resource and data point attributes to metric labels,
+// le is added for histogram buckets
+func seriesLabels(resource map[string]string, attrs []*commonpb.KeyValue, le string) structs.Labels {
+	labels := structs.Labels{}
+	for k, v := range resource {
+		labels[k] = v
+	}
+	for _, kv := range attrs {
+		labels[kv.GetKey()] = anyValue(kv.GetValue())
+	}
+	if le != "" {
+		labels["le"] = le
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
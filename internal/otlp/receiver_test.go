@@ -0,0 +1,204 @@
+package otlp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	colpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+const delta = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+
+func request(metrics ...*metricspb.Metric) *colpb.ExportMetricsServiceRequest {
+	return &colpb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			stringAttr(serviceNameKey, "shop"), stringAttr("host.name", "web-1")}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func sum(name string, monotonic bool, temporality metricspb.AggregationTemporality,
+	start uint64, value int64) *metricspb.Metric {
+	return &metricspb.Metric{Name: name, Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+		IsMonotonic: monotonic, AggregationTemporality: temporality,
+		DataPoints: []*metricspb.NumberDataPoint{{StartTimeUnixNano: start,
+			Attributes: []*commonpb.KeyValue{stringAttr("method", "GET")},
+			Value:      &metricspb.NumberDataPoint_AsInt{AsInt: value}}},
+	}}}
+}
+
+func export(t *testing.T, r *Receiver, metrics ...*metricspb.Metric) *colpb.ExportMetricsServiceResponse {
+	resp, err := r.Export(context.Background(), request(metrics...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// getSeries returns stored metric by series name: id with labels in canonical form
+func getSeries(t *testing.T, store structs.Storage, series string, mtype string) structs.Metric {
+	metrics, err := store.GetMetrics(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range metrics {
+		if m.MType == mtype && m.ID+m.Labels.String() == series {
+			return m
+		}
+	}
+	t.Fatalf("%s %s was not found", mtype, series)
+	return structs.Metric{}
+}
+
+func getCounter(t *testing.T, store structs.Storage, series string) int64 {
+	return *getSeries(t, store, series, "counter").Delta
+}
+
+func getGauge(t *testing.T, store structs.Storage, series string) float64 {
+	return *getSeries(t, store, series, "gauge").Value
+}
+
+func TestCumulativeSum(t *testing.T) {
+	store := structs.NewMemoryStorage()
+	r := NewReceiver(store)
+	id := `requests{method="GET",service.name="shop"}`
+
+	tt := []struct {
+		name  string
+		start uint64
+		value int64
+		want  int64
+	}{
+		{name: "series started before receiver sets baseline", start: 1, value: 100, want: 0},
+		{name: "increase is added", start: 1, value: 130, want: 30},
+		{name: "reset adds new value", start: 2, value: 5, want: 35},
+		{name: "decrease is treated as reset", start: 2, value: 3, want: 38},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			export(t, r, sum("requests", true, cumulative, tc.start, tc.value))
+			if have := getCounter(t, store, id); have != tc.want {
+				t.Errorf("counter mismatch: have %d, want %d", have, tc.want)
+			}
+		})
+	}
+
+	t.Run("series started after receiver", func(t *testing.T) {
+		export(t, r, sum("errors", true, cumulative, r.started+1, 7))
+		if have := getCounter(t, store, `errors{method="GET",service.name="shop"}`); have != 7 {
+			t.Errorf("counter mismatch: have %d, want 7", have)
+		}
+	})
+}
+
+// slowStorage widens the window between reading the previous state of a series and saving the new one
+type slowStorage struct {
+	structs.Storage
+}
+
+func (s slowStorage) UpdateMetrics(ctx context.Context, metrics []structs.Metric) error {
+	time.Sleep(10 * time.Millisecond)
+	return s.Storage.UpdateMetrics(ctx, metrics)
+}
+
+func TestConcurrentExports(t *testing.T) {
+	store := structs.NewMemoryStorage()
+	r := NewReceiver(slowStorage{store})
+	export(t, r, sum("requests", true, cumulative, 1, 10))
+
+	// every export repeats the same cumulative value, so the increase must be counted once
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Export(context.Background(),
+				request(sum("requests", true, cumulative, 1, 20), sum("queue", false, delta, 0, 1)))
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if have := getCounter(t, store, `requests{method="GET",service.name="shop"}`); have != 10 {
+		t.Errorf("requests mismatch: have %d, want 10", have)
+	}
+	if have := getGauge(t, store, `queue{method="GET",service.name="shop"}`); have != 50 {
+		t.Errorf("queue mismatch: have %f, want 50", have)
+	}
+	if len(r.locks) != 0 {
+		t.Errorf("series locks are left: %d", len(r.locks))
+	}
+}
+
+func TestDeltaSumAndGauge(t *testing.T) {
+	store := structs.NewMemoryStorage()
+	r := NewReceiver(store)
+
+	export(t, r, sum("requests", true, delta, 0, 4), sum("queue", false, delta, 0, 3))
+	export(t, r, sum("requests", true, delta, 0, 6), sum("queue", false, delta, 0, -1),
+		sum("sessions", false, cumulative, 0, 12),
+		&metricspb.Metric{Name: "temperature", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{
+				{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5}},
+				{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 99},
+					Flags: uint32(metricspb.DataPointFlags_FLAG_NO_RECORDED_VALUE)},
+			}}}})
+
+	if have := getCounter(t, store, `requests{method="GET",service.name="shop"}`); have != 10 {
+		t.Errorf("requests mismatch: have %d, want 10", have)
+	}
+	if have := getGauge(t, store, `queue{method="GET",service.name="shop"}`); have != 2 {
+		t.Errorf("queue mismatch: have %f, want 2", have)
+	}
+	if have := getGauge(t, store, `sessions{method="GET",service.name="shop"}`); have != 12 {
+		t.Errorf("sessions mismatch: have %f, want 12", have)
+	}
+	if have := getGauge(t, store, `temperature{service.name="shop"}`); have != 21.5 {
+		t.Errorf("temperature mismatch: have %f, want 21.5", have)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	store := structs.NewMemoryStorage()
+	r := NewReceiver(store)
+	latencySum := 1.5
+	histogram := &metricspb.Metric{Name: "latency", Data: &metricspb.Metric_Histogram{
+		Histogram: &metricspb.Histogram{AggregationTemporality: delta,
+			DataPoints: []*metricspb.HistogramDataPoint{{
+				Count: 6, Sum: &latencySum, ExplicitBounds: []float64{0.1, 0.5}, BucketCounts: []uint64{3, 2, 1}}}}}}
+	summary := &metricspb.Metric{Name: "rpc", Data: &metricspb.Metric_Summary{
+		Summary: &metricspb.Summary{DataPoints: []*metricspb.SummaryDataPoint{{Count: 1}}}}}
+
+	resp := export(t, r, histogram, summary)
+	if have := resp.GetPartialSuccess().GetRejectedDataPoints(); have != 1 {
+		t.Errorf("rejected data points mismatch: have %d, want 1", have)
+	}
+
+	counters := map[string]int64{
+		`latency_count{service.name="shop"}`:            6,
+		`latency_bucket{le="0.1",service.name="shop"}`:  3,
+		`latency_bucket{le="0.5",service.name="shop"}`:  5,
+		`latency_bucket{le="+Inf",service.name="shop"}`: 6,
+	}
+	for id, want := range counters {
+		if have := getCounter(t, store, id); have != want {
+			t.Errorf("%s mismatch: have %d, want %d", id, have, want)
+		}
+	}
+	if have := getGauge(t, store, `latency_sum{service.name="shop"}`); have != 1.5 {
+		t.Errorf("latency_sum mismatch: have %f, want 1.5", have)
+	}
+}
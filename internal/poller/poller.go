@@ -85,7 +85,30 @@ func getCPUMetrics() ([]structs.Metric, error) {
 	return metrics, err
 }
 
-func Poll(ctx context.Context, wg *sync.WaitGroup, pollInterval time.Duration) {
+// Identity returns labels attached to every metric of the agent: static labels,
+// host and instance (hostname if not set). Host and instance labels take precedence over static ones
+func Identity(hostname string, instance string, static map[string]string) structs.Labels {
+	labels := structs.Labels{}
+	for k, v := range static {
+		labels[k] = v
+	}
+	if instance == "" {
+		instance = hostname
+	}
+	if hostname != "" {
+		labels[structs.LabelHost] = hostname
+	}
+	if instance != "" {
+		labels[structs.LabelInstance] = instance
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// Poll collects metrics every pollInterval and saves them to the agent storage with labels attached
+func Poll(ctx context.Context, wg *sync.WaitGroup, pollInterval time.Duration, labels structs.Labels) {
 	defer wg.Done()
 	ticker := time.NewTicker(pollInterval)
 	for {
@@ -119,7 +142,11 @@ func Poll(ctx context.Context, wg *sync.WaitGroup, pollInterval time.Duration) {
 				metrics = append(metrics, cpuMetrics...)
 			}
 
-			err = storage.Agent.UpdateMetrics(metrics)
+			for i := range metrics {
+				metrics[i].Labels = labels
+			}
+
+			err = storage.Agent.UpdateMetrics(ctx, metrics)
 			if err != nil {
 				log.Printf("ERROR poller failed to poll metrics: %s", err.Error())
 			}
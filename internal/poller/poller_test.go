@@ -5,6 +5,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
 )
 
 func BenchmarkGetRtmMetrics(b *testing.B) {
@@ -69,7 +71,32 @@ func TestPoll(t *testing.T) {
 
 	name := "testing Pool"
 	t.Run(name, func(t *testing.T) {
-		Poll(ctxTimeout, &wg, time.Second)
+		Poll(ctxTimeout, &wg, time.Second, structs.Labels{"instance": "test"})
 	})
 
 }
+
+func TestIdentity(t *testing.T) {
+	tt := []struct {
+		name     string
+		hostname string
+		instance string
+		static   map[string]string
+		want     structs.Labels
+	}{
+		{name: "hostname only", hostname: "web1",
+			want: structs.Labels{"host": "web1", "instance": "web1"}},
+		{name: "instance and static labels", hostname: "web1", instance: "shop",
+			static: map[string]string{"env": "prod", "host": "static"},
+			want:   structs.Labels{"host": "web1", "instance": "shop", "env": "prod"}},
+		{name: "no identity"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			have := Identity(tc.hostname, tc.instance, tc.static)
+			if !have.Equal(tc.want) {
+				t.Errorf("labels mismatch: have %s, want %s", have, tc.want)
+			}
+		})
+	}
+}
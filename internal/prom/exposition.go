@@ -0,0 +1,180 @@
+// Package prom implements Prometheus formats support
+package prom
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// Content types of the exposition formats
+const TextContentType = "text/plain; version=0.0.4; charset=utf-8"
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+const counterSuffix = "_total"
+
+// SanitizeName converts metric id to a valid Prometheus metric name ([a-zA-Z_:][a-zA-Z0-9_:]*)
+func SanitizeName(id string) string {
+	if id == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// SanitizeLabelName converts label name to a valid Prometheus label name ([a-zA-Z_][a-zA-Z0-9_]*)
+func SanitizeLabelName(name string) string {
+	return strings.ReplaceAll(SanitizeName(name), ":", "_")
+}
+
+func escapeLabelValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+// formatLabels renders labels as {name1="value1",...} sorted by sanitized name
+func formatLabels(labels structs.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, SanitizeLabelName(k), escapeLabelValue(v)))
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ","))
+}
+
+// series is a single sample line of the family
+type series struct {
+	labels string
+	value  string
+}
+
+// family is a single metric family of the exposition: all series of the metric
+type family struct {
+	// name is metric family name (without _total for counters)
+	name   string
+	mtype  string
+	id     string
+	series []series
+}
+
+func families(metrics []structs.Metric) []family {
+	byID := map[string]*family{}
+	for _, m := range metrics {
+		var value string
+		switch m.MType {
+		case "counter":
+			if m.Delta == nil {
+				continue
+			}
+			value = strconv.FormatInt(*m.Delta, 10)
+		case "gauge":
+			if m.Value == nil {
+				continue
+			}
+			value = formatFloat(*m.Value)
+		default:
+			continue
+		}
+		key := m.MType + ":" + m.ID
+		f, ok := byID[key]
+		if !ok {
+			f = &family{name: SanitizeName(m.ID), mtype: m.MType, id: m.ID}
+			if m.MType == "counter" {
+				f.name = strings.TrimSuffix(f.name, counterSuffix)
+			}
+			byID[key] = f
+		}
+		f.series = append(f.series, series{labels: formatLabels(m.Labels), value: value})
+	}
+
+	out := make([]family, 0, len(byID))
+	for _, f := range byID {
+		sort.Slice(f.series, func(i, j int) bool { return f.series[i].labels < f.series[j].labels })
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].name != out[j].name {
+			return out[i].name < out[j].name
+		}
+		if out[i].mtype != out[j].mtype {
+			return out[i].mtype < out[j].mtype
+		}
+		return out[i].id < out[j].id
+	})
+	return out
+}
+
+// Encode renders metrics in Prometheus text exposition format
+// or in OpenMetrics text format if openMetrics is set.
+// Counters are exposed with _total suffix. Metrics whose names clash after sanitization are skipped
+func Encode(metrics []structs.Metric, openMetrics bool) []byte {
+	var buf bytes.Buffer
+	seen := map[string]string{}
+	for _, f := range families(metrics) {
+		sample := f.name
+		if f.mtype == "counter" {
+			sample += counterSuffix
+		}
+		// counter family "foo" owns "foo" and "foo_total" names
+		if prev, ok := seen[f.name]; ok {
+			log.Printf("WARN metric %s clashes with %s after name sanitization and will be skipped", f.id, prev)
+			continue
+		}
+		if prev, ok := seen[sample]; ok {
+			log.Printf("WARN metric %s clashes with %s after name sanitization and will be skipped", f.id, prev)
+			continue
+		}
+		seen[f.name], seen[sample] = f.id, f.id
+
+		// text format describes counters by the sample name, OpenMetrics by the family name
+		described := sample
+		if openMetrics {
+			described = f.name
+		}
+		fmt.Fprintf(&buf, "# HELP %s %s\n", described, escapeHelp(fmt.Sprintf("%s %s", f.mtype, f.id)))
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", described, f.mtype)
+		for _, s := range f.series {
+			fmt.Fprintf(&buf, "%s%s %s\n", sample, s.labels, s.value)
+		}
+	}
+	if openMetrics {
+		buf.WriteString("# EOF\n")
+	}
+	return buf.Bytes()
+}
+
+// AcceptsOpenMetrics checks if client prefers OpenMetrics format (by Accept header value)
+func AcceptsOpenMetrics(accept string) bool {
+	return strings.Contains(accept, "application/openmetrics-text")
+}
@@ -0,0 +1,105 @@
+package prom
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+func counter(id string, delta int64) structs.Metric {
+	return structs.Metric{ID: id, MType: "counter", Delta: &delta}
+}
+
+func gauge(id string, value float64) structs.Metric {
+	return structs.Metric{ID: id, MType: "gauge", Value: &value}
+}
+
+func withLabels(m structs.Metric, labels structs.Labels) structs.Metric {
+	m.Labels = labels
+	return m
+}
+
+func TestSanitizeName(t *testing.T) {
+	tt := []struct {
+		id   string
+		want string
+	}{
+		{id: "Alloc", want: "Alloc"},
+		{id: "http.requests-count", want: "http_requests_count"},
+		{id: "1st", want: "_1st"},
+		{id: "ns:metric_1", want: "ns:metric_1"},
+		{id: "температура", want: "___________"},
+		{id: "", want: "_"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.id, func(t *testing.T) {
+			if have := SanitizeName(tc.id); have != tc.want {
+				t.Errorf("name mismatch: have %s, want %s", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tt := []struct {
+		name        string
+		metrics     []structs.Metric
+		openMetrics bool
+		want        string
+	}{
+		{
+			name:    "text format",
+			metrics: []structs.Metric{gauge("Alloc", 1.5), counter("PollCount", 3)},
+			want: "# HELP Alloc gauge Alloc\n# TYPE Alloc gauge\nAlloc 1.5\n" +
+				"# HELP PollCount_total counter PollCount\n# TYPE PollCount_total counter\nPollCount_total 3\n",
+		},
+		{
+			name:        "OpenMetrics format",
+			metrics:     []structs.Metric{counter("PollCount", 3), gauge("Alloc", 1.5)},
+			openMetrics: true,
+			want: "# HELP Alloc gauge Alloc\n# TYPE Alloc gauge\nAlloc 1.5\n" +
+				"# HELP PollCount counter PollCount\n# TYPE PollCount counter\nPollCount_total 3\n# EOF\n",
+		},
+		{
+			name:    "counter with _total suffix",
+			metrics: []structs.Metric{counter("requests_total", 7)},
+			want: "# HELP requests_total counter requests_total\n# TYPE requests_total counter\n" +
+				"requests_total 7\n",
+		},
+		{
+			name:    "special values",
+			metrics: []structs.Metric{gauge("inf", math.Inf(1)), gauge("nan", math.NaN())},
+			want: "# HELP inf gauge inf\n# TYPE inf gauge\ninf +Inf\n" +
+				"# HELP nan gauge nan\n# TYPE nan gauge\nnan NaN\n",
+		},
+		{
+			name:    "names clash after sanitization",
+			metrics: []structs.Metric{gauge("a.b", 1), gauge("a_b", 2)},
+			want:    "# HELP a_b gauge a.b\n# TYPE a_b gauge\na_b 1\n",
+		},
+		{
+			name:    "gauge clashes with counter family",
+			metrics: []structs.Metric{counter("jobs", 1), gauge("jobs_total", 2)},
+			want:    "# HELP jobs_total counter jobs\n# TYPE jobs_total counter\njobs_total 1\n",
+		},
+		{
+			name: "series with labels",
+			metrics: []structs.Metric{
+				withLabels(gauge("load", 2), structs.Labels{"host": "web2"}),
+				withLabels(gauge("load", 1), structs.Labels{"host": "web1", "service.name": `say "hi"`}),
+				gauge("load", 0),
+			},
+			want: "# HELP load gauge load\n# TYPE load gauge\nload 0\n" +
+				"load{host=\"web1\",service_name=\"say \\\"hi\\\"\"} 1\nload{host=\"web2\"} 2\n",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			have := string(Encode(tc.metrics, tc.openMetrics))
+			if have != tc.want {
+				t.Errorf("exposition mismatch:\nhave:\n%s\nwant:\n%s", have, tc.want)
+			}
+		})
+	}
+}
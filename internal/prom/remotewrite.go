@@ -0,0 +1,76 @@
+package prom
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/golang/snappy"
+	"github.com/zklevsha/go-musthave-devops/internal/prompb"
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"google.golang.org/protobuf/proto"
+)
+
+// RemoteWriteVersionHeader is set by Prometheus in remote write requests
+const RemoteWriteVersionHeader = "X-Prometheus-Remote-Write-Version"
+
+const nameLabel = "__name__"
+
+// staleNaN is the value Prometheus writes to mark series as stale
+const staleNaN uint64 = 0x7ff0000000000002
+
+// SeriesLabels splits series labels into metric name (__name__ label) and the rest of labels
+func SeriesLabels(labels []*prompb.Label) (string, structs.Labels, error) {
+	var name string
+	var rest structs.Labels
+	for _, l := range labels {
+		if l.Name == nameLabel {
+			name = l.Value
+			continue
+		}
+		if rest == nil {
+			rest = structs.Labels{}
+		}
+		rest[l.Name] = l.Value
+	}
+	if name == "" {
+		return "", nil, fmt.Errorf("%w: series has no %s label", structs.ErrMetricNullAttr, nameLabel)
+	}
+	return name, rest, nil
+}
+
+// DecodeWriteRequest decodes snappy compressed remote write request.
+// Prometheus sends absolute values for every series (counters included),
+// so each sample is stored as a gauge. Samples of a series are returned in timestamp order,
+// stale markers are skipped
+func DecodeWriteRequest(body []byte) ([]structs.Metric, error) {
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		return []structs.Metric{}, fmt.Errorf("%w: failed to decompress snappy body: %s",
+			structs.ErrMetricBadAttrValue, err.Error())
+	}
+	var req prompb.WriteRequest
+	err = proto.Unmarshal(b, &req)
+	if err != nil {
+		return []structs.Metric{}, fmt.Errorf("%w: failed to decode write request: %s",
+			structs.ErrMetricBadAttrValue, err.Error())
+	}
+
+	var metrics = []structs.Metric{}
+	for _, ts := range req.Timeseries {
+		id, labels, err := SeriesLabels(ts.Labels)
+		if err != nil {
+			return []structs.Metric{}, err
+		}
+		samples := ts.Samples
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+		for _, s := range samples {
+			if math.Float64bits(s.Value) == staleNaN {
+				continue
+			}
+			value := s.Value
+			metrics = append(metrics, structs.Metric{ID: id, MType: "gauge", Value: &value, Labels: labels})
+		}
+	}
+	return metrics, nil
+}
@@ -0,0 +1,98 @@
+package prom
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/zklevsha/go-musthave-devops/internal/prompb"
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"google.golang.org/protobuf/proto"
+)
+
+func encodeWriteRequest(t *testing.T, req *prompb.WriteRequest) []byte {
+	b, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snappy.Encode(nil, b)
+}
+
+func TestSeriesLabels(t *testing.T) {
+	tt := []struct {
+		name       string
+		labels     []*prompb.Label
+		wantName   string
+		wantLabels structs.Labels
+		wantErr    error
+	}{
+		{name: "name only", labels: []*prompb.Label{{Name: "__name__", Value: "up"}}, wantName: "up"},
+		{name: "with labels",
+			labels: []*prompb.Label{{Name: "job", Value: "node"}, {Name: "__name__", Value: "up"},
+				{Name: "instance", Value: "host:9100"}},
+			wantName: "up", wantLabels: structs.Labels{"instance": "host:9100", "job": "node"}},
+		{name: "no name", labels: []*prompb.Label{{Name: "job", Value: "node"}},
+			wantErr: structs.ErrMetricNullAttr},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			name, labels, err := SeriesLabels(tc.labels)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tc.wantErr)
+			}
+			if name != tc.wantName {
+				t.Errorf("name mismatch: have %s, want %s", name, tc.wantName)
+			}
+			if !labels.Equal(tc.wantLabels) {
+				t.Errorf("labels mismatch: have %s, want %s", labels, tc.wantLabels)
+			}
+		})
+	}
+}
+
+func TestDecodeWriteRequest(t *testing.T) {
+	body := encodeWriteRequest(t, &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{
+		{
+			Labels: []*prompb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "code", Value: "200"}},
+			Samples: []*prompb.Sample{
+				{Value: 7, Timestamp: 2000},
+				{Value: 5, Timestamp: 1000},
+				{Value: math.Float64frombits(staleNaN), Timestamp: 3000},
+			},
+		},
+	}})
+
+	metrics, err := DecodeWriteRequest(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{5, 7}
+	if len(metrics) != len(want) {
+		t.Fatalf("metrics count mismatch: have %d, want %d", len(metrics), len(want))
+	}
+	for i, m := range metrics {
+		if m.ID != "requests_total" || m.MType != "gauge" || m.Labels["code"] != "200" {
+			t.Errorf("metric %d mismatch: have %s%s/%s", i, m.ID, m.Labels, m.MType)
+		}
+		if *m.Value != want[i] {
+			t.Errorf("metric %d value mismatch: have %f, want %f", i, *m.Value, want[i])
+		}
+	}
+
+	tt := []struct {
+		name string
+		body []byte
+	}{
+		{name: "not snappy", body: []byte("plain text")},
+		{name: "not protobuf", body: snappy.Encode(nil, []byte{0xff, 0xff})},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeWriteRequest(tc.body)
+			if !errors.Is(err, structs.ErrMetricBadAttrValue) {
+				t.Errorf("error mismatch: have %v, want %v", err, structs.ErrMetricBadAttrValue)
+			}
+		})
+	}
+}
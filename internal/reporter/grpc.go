@@ -0,0 +1,165 @@
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/config"
+	"github.com/zklevsha/go-musthave-devops/internal/pb"
+	"github.com/zklevsha/go-musthave-devops/internal/serializer"
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+type grpcReporter struct {
+	conf  config.AgentConfig
+	retry retryPolicy
+	creds credentials.TransportCredentials
+	// metrics are sent one by one until then, set when server does not support UpdateMetrics RPC
+	batchUnsupportedUntil time.Time
+}
+
+func (r *grpcReporter) send(ctx context.Context, metrics []structs.Metric) []structs.Metric {
+	// init gRPC client
+	conn, err := grpc.Dial(r.conf.GRPCAddress, grpc.WithTransportCredentials(r.creds))
+	if err != nil {
+		log.Printf("CRITICAL failed to connect to gRCP server: %v", err)
+		return metrics
+	}
+	defer conn.Close()
+	client := pb.NewMonitoringClient(conn)
+
+	if time.Now().Before(r.batchUnsupportedUntil) {
+		return r.sendSingle(ctx, client, metrics)
+	}
+
+	var unsent []structs.Metric
+	batchSize := r.conf.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(metrics)
+	}
+	for start := 0; start < len(metrics); start += batchSize {
+		end := start + batchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		batch := metrics[start:end]
+		failed, err := r.sendBatch(ctx, client, batch)
+		if status.Code(err) == codes.Unimplemented {
+			log.Printf("WARN gRPC server does not support batch updates (%s), "+
+				"falling back to single metric updates for %s", err.Error(), batchProbeInterval)
+			r.batchUnsupportedUntil = time.Now().Add(batchProbeInterval)
+			return append(unsent, r.sendSingle(ctx, client, metrics[start:])...)
+		}
+		if err != nil {
+			log.Printf("ERROR failed to send metrics batch (%d metrics) to gRPC server: %s", len(batch), err.Error())
+			unsent = append(unsent, batch...)
+			continue
+		}
+		if !r.batchUnsupportedUntil.IsZero() {
+			log.Println("INFO gRPC server supports batch updates now")
+			r.batchUnsupportedUntil = time.Time{}
+		}
+		unsent = append(unsent, failed...)
+		log.Printf("INFO metrics batch (%d metrics) was sent, %d metrics were rejected", len(batch), len(failed))
+	}
+	return unsent
+}
+
+// encode signs metric (if key is set) and converts it to protobuf
+func (r *grpcReporter) encode(m structs.Metric) (*pb.Metric, error) {
+	if r.conf.Key != "" {
+		m.SetHash(r.conf.Key)
+	}
+	return serializer.EncodeGRPCMetric(m)
+}
+
+// sendBatch sends metrics with UpdateMetrics RPC and returns metrics which were not encoded
+// or were rejected by the server
+func (r *grpcReporter) sendBatch(ctx context.Context, client pb.MonitoringClient,
+	metrics []structs.Metric) ([]structs.Metric, error) {
+	req := &pb.UpdateMetricsRequest{}
+	var unsent, encoded []structs.Metric
+	for _, m := range metrics {
+		inM, err := r.encode(m)
+		if err != nil {
+			log.Printf("ERROR failed to encode metric %s to gRPC: %s", m.ID, err.Error())
+			unsent = append(unsent, m)
+			continue
+		}
+		req.Metrics = append(req.Metrics, inM)
+		encoded = append(encoded, m)
+	}
+
+	var resp *pb.UpdateMetricsResponse
+	err := r.retry.do(ctx, func() error {
+		var err error
+		resp, err = client.UpdateMetrics(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(unsent, rejected(encoded, resp.Errors)...), nil
+}
+
+// rejectedKey identifies series in the server response
+func rejectedKey(mtype, id string, labels structs.Labels) string {
+	return fmt.Sprintf("%s:%s", mtype, structs.SeriesKey(id, labels))
+}
+
+// rejected returns metrics which were rejected by the server with retryable errors.
+// Metrics rejected permanently (bad type, bad value, ...) are dropped
+func rejected(metrics []structs.Metric, errs []*pb.MetricError) []structs.Metric {
+	if len(errs) == 0 {
+		return nil
+	}
+	failed := map[string]*pb.MetricError{}
+	for _, e := range errs {
+		failed[rejectedKey(e.Mtype, e.Id, e.Labels)] = e
+	}
+	var out []structs.Metric
+	for _, m := range metrics {
+		e, ok := failed[rejectedKey(m.MType, m.ID, m.Labels)]
+		if !ok {
+			continue
+		}
+		code := codes.Code(e.Code)
+		if !isRetryable(status.Error(code, e.Error)) {
+			log.Printf("ERROR gRPC server rejected metric %s (%s): %s. Metric will be dropped", m.ID, code, e.Error)
+			continue
+		}
+		log.Printf("ERROR gRPC server rejected metric %s (%s): %s", m.ID, code, e.Error)
+		out = append(out, m)
+	}
+	return out
+}
+
+func (r *grpcReporter) sendSingle(ctx context.Context, client pb.MonitoringClient,
+	metrics []structs.Metric) []structs.Metric {
+	var unsent []structs.Metric
+	for _, m := range metrics {
+		inM, err := r.encode(m)
+		if err != nil {
+			log.Printf("ERROR failed to encode metric %s to gRPC: %s", m.ID, err.Error())
+			unsent = append(unsent, m)
+			continue
+		}
+		err = r.retry.do(ctx, func() error {
+			_, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: inM})
+			return err
+		})
+		if err != nil {
+			log.Printf("ERROR failed to send metric %s to gRPC server: %s", m.ID, err.Error())
+			unsent = append(unsent, m)
+			continue
+		}
+		log.Printf("INFO metric %s was sent", m.ID)
+	}
+	return unsent
+}
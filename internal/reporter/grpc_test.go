@@ -0,0 +1,155 @@
+package reporter
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/zklevsha/go-musthave-devops/internal/config"
+	"github.com/zklevsha/go-musthave-devops/internal/pb"
+	"github.com/zklevsha/go-musthave-devops/internal/storage"
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// fakeMonitoring records received metrics and rejects series (see structs.SeriesKey) from reject with given code
+type fakeMonitoring struct {
+	pb.UnimplementedMonitoringServer
+	oldServer bool
+	reject    map[string]codes.Code
+	batches   int
+	single    int
+}
+
+func (s *fakeMonitoring) UpdateMetric(ctx context.Context, in *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
+	s.single++
+	return &pb.UpdateMetricResponse{Response: &pb.Response{}}, nil
+}
+
+func (s *fakeMonitoring) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if s.oldServer {
+		return s.UnimplementedMonitoringServer.UpdateMetrics(ctx, in)
+	}
+	s.batches++
+	resp := &pb.UpdateMetricsResponse{Response: &pb.Response{}}
+	for _, m := range in.Metrics {
+		if code, ok := s.reject[structs.SeriesKey(m.Id, m.Labels)]; ok {
+			resp.Errors = append(resp.Errors, &pb.MetricError{Id: m.Id, Mtype: m.Mtype, Labels: m.Labels,
+				Error: "rejected", Code: int32(code)})
+		}
+	}
+	return resp, nil
+}
+
+func TestGRPCReporter(t *testing.T) {
+	pollCount := structs.SeriesKey("PollCount", nil)
+	hostLabels := structs.Labels{"host": "a"}
+	tt := []struct {
+		name      string
+		batchSize int
+		oldServer bool
+		labeled   bool
+		reject    map[string]codes.Code
+		batches   int
+		single    int
+		agentPoll int64
+		// PollCount{host="a"} left in agent storage, checked if labeled
+		agentLabeledPoll int64
+	}{
+		{name: "one batch", batchSize: 100, batches: 1},
+		{name: "split into batches", batchSize: 2, batches: 2},
+		{name: "temporary rejected counter is given back", batchSize: 100, batches: 1,
+			reject: map[string]codes.Code{pollCount: codes.Unavailable}, agentPoll: 3},
+		{name: "permanently rejected counter is dropped", batchSize: 100, batches: 1,
+			reject: map[string]codes.Code{pollCount: codes.InvalidArgument}, agentPoll: 0},
+		{name: "rejected series is matched by labels", batchSize: 100, batches: 1, labeled: true,
+			reject:    map[string]codes.Code{structs.SeriesKey("PollCount", hostLabels): codes.Unavailable},
+			agentPoll: 0, agentLabeledPoll: 4},
+		{name: "old server without UpdateMetrics", batchSize: 100, oldServer: true, single: 3},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			storage.Agent = structs.NewMemoryStorage()
+			setAgentMetrics(t)
+			if tc.labeled {
+				delta := int64(4)
+				err := storage.Agent.UpdateMetric(context.Background(),
+					structs.Metric{ID: "PollCount", MType: "counter", Delta: &delta, Labels: hostLabels})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fake := &fakeMonitoring{oldServer: tc.oldServer, reject: tc.reject}
+			s := grpc.NewServer()
+			pb.RegisterMonitoringServer(s, fake)
+			go s.Serve(listener)
+			defer s.Stop()
+
+			r := &grpcReporter{conf: config.AgentConfig{GRPCAddress: listener.Addr().String(),
+				BatchSize: tc.batchSize}, creds: insecure.NewCredentials()}
+			report(context.Background(), r, nil)
+
+			if fake.batches != tc.batches {
+				t.Errorf("batches count mismatch: have %d, want %d", fake.batches, tc.batches)
+			}
+			if fake.single != tc.single {
+				t.Errorf("single updates count mismatch: have %d, want %d", fake.single, tc.single)
+			}
+			if have := !r.batchUnsupportedUntil.IsZero(); have != tc.oldServer {
+				t.Errorf("batchUnsupported mismatch: have %t, want %t", have, tc.oldServer)
+			}
+			if have := getPollCount(t, storage.Agent); have != tc.agentPoll {
+				t.Errorf("agent PollCount mismatch: have %d, want %d", have, tc.agentPoll)
+			}
+			if !tc.labeled {
+				return
+			}
+			m, err := storage.Agent.GetMetric(context.Background(),
+				structs.Metric{ID: "PollCount", MType: "counter", Labels: hostLabels})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *m.Delta != tc.agentLabeledPoll {
+				t.Errorf("agent PollCount%s mismatch: have %d, want %d", hostLabels, *m.Delta, tc.agentLabeledPoll)
+			}
+		})
+	}
+}
+
+func TestGRPCSendBatchEncodeFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeMonitoring{}
+	s := grpc.NewServer()
+	pb.RegisterMonitoringServer(s, fake)
+	go s.Serve(listener)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := &grpcReporter{conf: config.AgentConfig{GRPCAddress: listener.Addr().String()}}
+	delta := int64(1)
+	metrics := []structs.Metric{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Latency", MType: "histogram"},
+	}
+	unsent, err := r.sendBatch(context.Background(), pb.NewMonitoringClient(conn), metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unsent) != 1 || unsent[0].ID != "Latency" {
+		t.Errorf("unsent mismatch: have %v, want Latency only", unsent)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"context"
 	"crypto/rsa"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -13,17 +15,43 @@ import (
 	"time"
 
 	"github.com/zklevsha/go-musthave-devops/internal/archive"
+	"github.com/zklevsha/go-musthave-devops/internal/certs"
 	"github.com/zklevsha/go-musthave-devops/internal/config"
-	"github.com/zklevsha/go-musthave-devops/internal/pb"
 	"github.com/zklevsha/go-musthave-devops/internal/rsaencrypt"
 	"github.com/zklevsha/go-musthave-devops/internal/serializer"
+	"github.com/zklevsha/go-musthave-devops/internal/spool"
 	"github.com/zklevsha/go-musthave-devops/internal/storage"
-	"google.golang.org/grpc"
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-func sendREST(url string, body []byte, pubKey *rsa.PublicKey) error {
-	client := &http.Client{}
+// statusError is returned when server responded with non 200 status code
+type statusError struct {
+	Code   int
+	Status string
+	URL    string
+	Body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("bad StatusCode: %s (URL: %s, Response Body: %s)", e.Status, e.URL, e.Body)
+}
+
+// batchProbeInterval is how long metrics are sent one by one after the server rejected /updates/.
+// Then batch updates are tried again, the server may have been upgraded meanwhile
+const batchProbeInterval = 5 * time.Minute
+
+// batchNotSupported checks if error means that server has no /updates/ endpoint
+func batchNotSupported(err error) bool {
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	return statusErr.Code == http.StatusNotFound || statusErr.Code == http.StatusMethodNotAllowed
+}
+
+func sendREST(ctx context.Context, client *http.Client, url string, body []byte, pubKey *rsa.PublicKey) error {
 	var b []byte
 	var err error
 
@@ -35,24 +63,28 @@ func sendREST(url string, body []byte, pubKey *rsa.PublicKey) error {
 
 	// Encrypt
 	if pubKey != nil {
-		b, err = rsaencrypt.Encrypt(pubKey, b, []byte(config.RsaLabel))
+		b, err = rsaencrypt.EncryptHybrid(pubKey, b, []byte(config.RsaLabel))
 		if err != nil {
 			return fmt.Errorf("ERROR failed to ecnrypt metrics: %s", err.Error())
 		}
 	}
 
 	// Send
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(b))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(b))
 	if err != nil {
 		return fmt.Errorf("failed to create http.NewRequest : %s", err.Error())
 	}
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Content-Encoding", "gzip")
+	if pubKey != nil {
+		req.Header.Add(rsaencrypt.VersionHeader, rsaencrypt.Version2)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("an error occured %v", err)
+		return fmt.Errorf("an error occured %w", err)
 
 	}
+	defer resp.Body.Close()
 
 	// Check response
 	if resp.StatusCode != 200 {
@@ -60,93 +92,236 @@ func sendREST(url string, body []byte, pubKey *rsa.PublicKey) error {
 		if err != nil {
 			log.Println(err.Error())
 		}
-		return fmt.Errorf("bad StatusCode: %s (URL: %s, Response Body: %s)",
-			resp.Status, url, string(respBody))
+		return &statusError{Code: resp.StatusCode, Status: resp.Status,
+			URL: url, Body: string(respBody)}
 	}
-	defer resp.Body.Close()
 	return nil
 }
 
-func reportMetricsREST(conf config.AgentConfig, pubKey *rsa.PublicKey) {
-	url := fmt.Sprintf("http://%s/update/", conf.ServerAddress)
-	metircs, err := storage.Agent.GetMetrics()
+// sender sends metrics to the server and returns metrics which were not accepted and may be sent again.
+// Metrics rejected by the server permanently are dropped, so their counter deltas are not given back
+type sender interface {
+	send(ctx context.Context, metrics []structs.Metric) []structs.Metric
+}
+
+// takeSnapshot returns agent metrics and subtracts counter deltas from the agent storage.
+// From now on the snapshot owns these deltas: they are either accepted by the server,
+// spooled or given back to the agent storage
+func takeSnapshot(ctx context.Context) ([]structs.Metric, error) {
+	metrics, err := storage.Agent.GetMetrics(ctx)
 	if err != nil {
-		log.Printf("ERROR failed to get metrics: %s", err.Error())
-		return
+		return []structs.Metric{}, err
+	}
+	var taken []structs.Metric
+	for _, m := range metrics {
+		if m.MType == "counter" {
+			delta := -*m.Delta
+			taken = append(taken, structs.Metric{ID: m.ID, MType: m.MType, Delta: &delta, Labels: m.Labels})
+		}
+	}
+	err = storage.Agent.UpdateMetrics(ctx, taken)
+	if err != nil {
+		return []structs.Metric{}, fmt.Errorf("failed to take counters: %s", err.Error())
 	}
-	for _, m := range metircs {
-		body, err := serializer.EncodyBodyMetric(m, conf.Key)
+	return metrics, nil
+}
+
+// giveBack returns unsent counter deltas to the agent storage.
+// Unsent gauges are dropped as the storage already has values which are at least as fresh.
+// Deltas are given back even if the report was cancelled, so they are not bound to its context
+func giveBack(metrics []structs.Metric) {
+	for _, m := range metrics {
+		if m.MType != "counter" {
+			continue
+		}
+		err := storage.Agent.UpdateMetric(context.Background(), m)
 		if err != nil {
-			log.Printf("ERROR failed to encode metrics: %s", err.Error())
+			log.Printf("ERROR: failed to give back counter %s: %s", m.ID, err.Error())
+		}
+	}
+}
+
+type restReporter struct {
+	conf   config.AgentConfig
+	pubKey *rsa.PublicKey
+	retry  retryPolicy
+	client *http.Client
+	// http or https
+	scheme string
+	// metrics are sent one by one until then, set when server does not support /updates/ endpoint
+	batchUnsupportedUntil time.Time
+}
+
+// newRestReporter creates REST reporter. If tlsConf is not nil metrics are sent via https
+func newRestReporter(conf config.AgentConfig, pubKey *rsa.PublicKey, retry retryPolicy,
+	tlsConf *tls.Config) *restReporter {
+	r := &restReporter{conf: conf, pubKey: pubKey, retry: retry,
+		client: &http.Client{}, scheme: "http"}
+	if tlsConf != nil {
+		r.client.Transport = &http.Transport{TLSClientConfig: tlsConf}
+		r.scheme = "https"
+	}
+	return r
+}
+
+func (r *restReporter) send(ctx context.Context, metrics []structs.Metric) []structs.Metric {
+	if time.Now().Before(r.batchUnsupportedUntil) {
+		return r.sendSingle(ctx, metrics)
+	}
+
+	var unsent []structs.Metric
+	batchSize := r.conf.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(metrics)
+	}
+	for start := 0; start < len(metrics); start += batchSize {
+		end := start + batchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		batch := metrics[start:end]
+		err := r.sendBatch(ctx, batch)
+		if batchNotSupported(err) {
+			log.Printf("WARN server does not support batch updates (%s), "+
+				"falling back to single metric updates for %s", err.Error(), batchProbeInterval)
+			r.batchUnsupportedUntil = time.Now().Add(batchProbeInterval)
+			return append(unsent, r.sendSingle(ctx, metrics[start:])...)
+		}
+		if isRejected(err) {
+			log.Printf("ERROR server rejected metrics batch (%d metrics): %s. Metrics will be dropped",
+				len(batch), err.Error())
 			continue
 		}
-		err = sendREST(url, body, pubKey)
 		if err != nil {
-			log.Printf("ERROR failed to send metric %s: %s", m.ID, err.Error())
+			log.Printf("ERROR failed to send metrics batch (%d metrics): %s", len(batch), err.Error())
+			unsent = append(unsent, batch...)
 			continue
 		}
-		log.Printf("INFO %s was sent", m.ID)
-		if m.MType == "counter" {
-			err := storage.Agent.ResetCounter(m.ID)
-			if err != nil {
-				log.Printf("ERROR: failed to reset counter %s: %s", m.ID, err.Error())
-			}
+		if !r.batchUnsupportedUntil.IsZero() {
+			log.Println("INFO server supports batch updates now")
+			r.batchUnsupportedUntil = time.Time{}
 		}
+		log.Printf("INFO metrics batch (%d metrics) was sent", len(batch))
 	}
+	return unsent
 }
 
-func reportMetricsGRPC(ctx context.Context, conf config.AgentConfig) {
-	// get Metrics
-	metircs, err := storage.Agent.GetMetrics()
-	if err != nil {
-		log.Printf("ERROR failed to get metrics: %s", err.Error())
-	}
-
-	// init gRPC client
-	conn, err := grpc.Dial(conf.GRPCAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func (r *restReporter) sendBatch(ctx context.Context, metrics []structs.Metric) error {
+	url := fmt.Sprintf("%s://%s/updates/", r.scheme, r.conf.ServerAddress)
+	body, err := serializer.EncodeBodyMetrics(metrics, r.conf.Key)
 	if err != nil {
-		log.Printf("CRITICAL failed to connect to gRCP server: %v", err)
-		return
+		return fmt.Errorf("failed to encode metrics: %s", err.Error())
 	}
-	defer conn.Close()
-	client := pb.NewMonitoringClient(conn)
+	return r.retry.do(ctx, func() error {
+		return sendREST(ctx, r.client, url, body, r.pubKey)
+	})
+}
 
-	for _, m := range metircs {
-		inM, err := serializer.EncodeGRPCMetric(m)
+func (r *restReporter) sendSingle(ctx context.Context, metrics []structs.Metric) []structs.Metric {
+	var unsent []structs.Metric
+	url := fmt.Sprintf("%s://%s/update/", r.scheme, r.conf.ServerAddress)
+	for _, m := range metrics {
+		body, err := serializer.EncodyBodyMetric(m, r.conf.Key)
 		if err != nil {
-			log.Printf("ERROR failed to encode metric %s to gRPC: %s", m.ID, err.Error())
+			log.Printf("ERROR failed to encode metrics: %s", err.Error())
 			continue
 		}
-		resp, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: inM})
-		if err != nil {
-			log.Printf("ERROR failed to send metric %s to gRPC server: %s", m.ID, err.Error())
+		err = r.retry.do(ctx, func() error {
+			return sendREST(ctx, r.client, url, body, r.pubKey)
+		})
+		if isRejected(err) {
+			log.Printf("ERROR server rejected metric %s: %s. Metric will be dropped", m.ID, err.Error())
 			continue
 		}
-
-		if resp.Response.Error != "" {
-			log.Printf("ERROR failed to send metric %s to gRPC server: %s",
-				m.ID, resp.Response.Error)
+		if err != nil {
+			log.Printf("ERROR failed to send metric %s: %s", m.ID, err.Error())
+			unsent = append(unsent, m)
 			continue
 		}
-		log.Printf("INFO metric %s was sent", m.ID)
+		log.Printf("INFO %s was sent", m.ID)
+	}
+	return unsent
+}
+
+// report sends spooled snapshots and then the current one.
+// Unsent metrics are spooled (if spool is configured) or given back to the agent storage
+func report(ctx context.Context, s sender, sp *spool.Spool) {
+	metrics, err := takeSnapshot(ctx)
+	if err != nil {
+		log.Printf("ERROR failed to get metrics: %s", err.Error())
+		return
+	}
+
+	if sp == nil {
+		giveBack(s.send(ctx, metrics))
+		return
 	}
 
+	replayed, err := sp.Replay(func(spooled []structs.Metric) []structs.Metric {
+		return s.send(ctx, spooled)
+	})
+	if err != nil {
+		log.Printf("ERROR failed to replay spool: %s", err.Error())
+	}
+	unsent := metrics
+	// current snapshot must not overtake the spooled ones
+	if replayed {
+		unsent = s.send(ctx, metrics)
+	}
+	if len(unsent) == 0 {
+		return
+	}
+	err = sp.Put(unsent)
+	if err != nil {
+		log.Printf("ERROR failed to spool %d metrics: %s", len(unsent), err.Error())
+		giveBack(unsent)
+		return
+	}
+	log.Printf("INFO %d unsent metrics were spooled", len(unsent))
 }
 
 func Report(ctx context.Context, wg *sync.WaitGroup, conf config.AgentConfig, pubKey *rsa.PublicKey) {
 	defer wg.Done()
 	ticker := time.NewTicker(conf.ReportInterval)
+	retry := newRetryPolicy(conf)
+
+	var tlsConf *tls.Config
+	if conf.UseTLS() {
+		var err error
+		tlsConf, err = certs.ClientTLSConfig(conf.TLSCert, conf.TLSKey, conf.TLSCA)
+		if err != nil {
+			log.Fatalf("CRITICAL failed to load TLS configuration: %s", err.Error())
+		}
+	}
+
+	var s sender
+	if conf.GRPCAddress == "" {
+		s = newRestReporter(conf, pubKey, retry, tlsConf)
+	} else {
+		creds := insecure.NewCredentials()
+		if tlsConf != nil {
+			creds = credentials.NewTLS(tlsConf)
+		}
+		s = &grpcReporter{conf: conf, retry: retry, creds: creds}
+	}
+
+	var sp *spool.Spool
+	if conf.SpoolDir != "" {
+		var err error
+		sp, err = spool.New(conf.SpoolDir, conf.SpoolMaxSize, conf.SpoolMaxAge)
+		if err != nil {
+			log.Printf("ERROR %s. Unsent metrics will not be spooled", err.Error())
+			sp = nil
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("INFO report received ctx.Done(), returning")
 			return
 		case <-ticker.C:
-			if conf.GRPCAddress == "" {
-				reportMetricsREST(conf, pubKey)
-			} else {
-				reportMetricsGRPC(ctx, conf)
-			}
+			report(ctx, s, sp)
 		}
 	}
 }
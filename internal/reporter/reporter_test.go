@@ -0,0 +1,373 @@
+package reporter
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/certs"
+	"github.com/zklevsha/go-musthave-devops/internal/config"
+	"github.com/zklevsha/go-musthave-devops/internal/handlers"
+	"github.com/zklevsha/go-musthave-devops/internal/spool"
+	"github.com/zklevsha/go-musthave-devops/internal/storage"
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+var serverConf = config.ServerConfig{TrustedSubnet: net.IPNet{IP: net.IPv4(0, 0, 0, 0),
+	Mask: net.IPv4Mask(0, 0, 0, 0)}}
+
+// setAgentMetrics fills agent storage with test metrics
+func setAgentMetrics(t *testing.T) {
+	delta := int64(3)
+	value := float64(1.5)
+	err := storage.Agent.UpdateMetrics(context.Background(), []structs.Metric{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "HeapAlloc", MType: "gauge", Value: &value},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getPollCount(t *testing.T, s structs.Storage) int64 {
+	m, err := s.GetMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter"})
+	if err != nil {
+		t.Fatalf("failed to get PollCount: %s", err.Error())
+	}
+	return *m.Delta
+}
+
+func TestRestReporter(t *testing.T) {
+	type want struct {
+		requests         map[string]int
+		serverPollCount  int64
+		agentPollCount   int64
+		batchUnsupported bool
+	}
+
+	tt := []struct {
+		name      string
+		batchSize int
+		oldServer bool
+		broken    bool
+		rejecting bool
+		encrypted bool
+		want      want
+	}{
+		{
+			name:      "all metrics in one batch",
+			batchSize: 100,
+			want: want{requests: map[string]int{"/updates/": 1},
+				serverPollCount: 3, agentPollCount: 0},
+		},
+		{
+			name:      "metrics split into batches",
+			batchSize: 2,
+			want: want{requests: map[string]int{"/updates/": 2},
+				serverPollCount: 3, agentPollCount: 0},
+		},
+		{
+			name:      "old server without /updates/",
+			batchSize: 100,
+			oldServer: true,
+			want: want{requests: map[string]int{"/updates/": 1, "/update/": 3},
+				serverPollCount: 3, agentPollCount: 0, batchUnsupported: true},
+		},
+		{
+			name:      "encrypted batch",
+			batchSize: 100,
+			encrypted: true,
+			want: want{requests: map[string]int{"/updates/": 1},
+				serverPollCount: 3, agentPollCount: 0},
+		},
+		{
+			name:      "server error",
+			batchSize: 100,
+			broken:    true,
+			want: want{requests: map[string]int{"/updates/": 1},
+				agentPollCount: 3},
+		},
+		{
+			name:      "batch rejected by server",
+			batchSize: 100,
+			rejecting: true,
+			want: want{requests: map[string]int{"/updates/": 1},
+				agentPollCount: 0},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			storage.Agent = structs.NewMemoryStorage()
+			setAgentMetrics(t)
+
+			var privKey *rsa.PrivateKey
+			var pubKey *rsa.PublicKey
+			if tc.encrypted {
+				var err error
+				privKey, err = rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pubKey = &privKey.PublicKey
+			}
+
+			serverStorage := structs.NewMemoryStorage()
+			handler := handlers.GetHandler(serverConf, serverStorage, privKey)
+			requests := map[string]int{}
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests[r.URL.Path]++
+				if tc.broken {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				if tc.rejecting {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if tc.oldServer && r.URL.Path == "/updates/" {
+					http.NotFound(w, r)
+					return
+				}
+				handler.ServeHTTP(w, r)
+			}))
+			defer ts.Close()
+
+			rest := newRestReporter(config.AgentConfig{
+				ServerAddress: strings.TrimPrefix(ts.URL, "http://"),
+				BatchSize:     tc.batchSize}, pubKey, retryPolicy{}, nil)
+			report(context.Background(), rest, nil)
+
+			for path, count := range tc.want.requests {
+				if requests[path] != count {
+					t.Errorf("%s requests count mismatch: have %d, want %d", path, requests[path], count)
+				}
+			}
+			if have := !rest.batchUnsupportedUntil.IsZero(); have != tc.want.batchUnsupported {
+				t.Errorf("batchUnsupported mismatch: have %t, want %t", have, tc.want.batchUnsupported)
+			}
+			if have := getPollCount(t, storage.Agent); have != tc.want.agentPollCount {
+				t.Errorf("agent PollCount mismatch: have %d, want %d", have, tc.want.agentPollCount)
+			}
+			if tc.want.serverPollCount == 0 {
+				return
+			}
+			if have := getPollCount(t, serverStorage); have != tc.want.serverPollCount {
+				t.Errorf("server PollCount mismatch: have %d, want %d", have, tc.want.serverPollCount)
+			}
+		})
+	}
+}
+
+func TestRestReporterBatchProbe(t *testing.T) {
+	storage.Agent = structs.NewMemoryStorage()
+	serverStorage := structs.NewMemoryStorage()
+	handler := handlers.GetHandler(serverConf, serverStorage, nil)
+	upgraded := false
+	requests := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if !upgraded && r.URL.Path == "/updates/" {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	rest := newRestReporter(config.AgentConfig{
+		ServerAddress: strings.TrimPrefix(ts.URL, "http://"),
+		BatchSize:     100}, nil, retryPolicy{}, nil)
+
+	// old server: metrics are sent one by one, /updates/ is not probed until the interval passes
+	for i := 0; i < 2; i++ {
+		setAgentMetrics(t)
+		report(context.Background(), rest, nil)
+	}
+	if requests["/updates/"] != 1 || requests["/update/"] != 6 {
+		t.Fatalf("requests mismatch: have %v, want 1 /updates/ and 6 /update/", requests)
+	}
+
+	// server was upgraded and the interval passed: batch updates are used again
+	upgraded = true
+	rest.batchUnsupportedUntil = time.Now()
+	setAgentMetrics(t)
+	report(context.Background(), rest, nil)
+	if requests["/updates/"] != 2 || requests["/update/"] != 6 {
+		t.Errorf("requests mismatch: have %v, want 2 /updates/ and 6 /update/", requests)
+	}
+	if !rest.batchUnsupportedUntil.IsZero() {
+		t.Errorf("batch updates are still disabled until %s", rest.batchUnsupportedUntil)
+	}
+	if have := getPollCount(t, serverStorage); have != 9 {
+		t.Errorf("server PollCount mismatch: have %d, want 9", have)
+	}
+}
+
+func TestReportSpool(t *testing.T) {
+	storage.Agent = structs.NewMemoryStorage()
+	setAgentMetrics(t)
+
+	serverStorage := structs.NewMemoryStorage()
+	handler := handlers.GetHandler(serverConf, serverStorage, nil)
+	down := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	sp, err := spool.New(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest := newRestReporter(config.AgentConfig{
+		ServerAddress: strings.TrimPrefix(ts.URL, "http://"),
+		BatchSize:     100}, nil, retryPolicy{}, nil)
+
+	// server is down: snapshot is spooled and counters are moved into the spool
+	report(context.Background(), rest, sp)
+	if have := getPollCount(t, storage.Agent); have != 0 {
+		t.Errorf("agent PollCount mismatch: have %d, want 0", have)
+	}
+	names, err := sp.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("spooled snapshots count mismatch: have %d, want 1", len(names))
+	}
+
+	// server is up: spooled snapshot is replayed before the current one
+	delta := int64(2)
+	err = storage.Agent.UpdateMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter", Delta: &delta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	down = false
+	report(context.Background(), rest, sp)
+	if have := getPollCount(t, serverStorage); have != 5 {
+		t.Errorf("server PollCount mismatch: have %d, want 5", have)
+	}
+	names, err = sp.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Errorf("spool must be empty after replay, have %d snapshots", len(names))
+	}
+}
+
+func TestReportSpoolRejected(t *testing.T) {
+	storage.Agent = structs.NewMemoryStorage()
+	setAgentMetrics(t)
+
+	serverStorage := structs.NewMemoryStorage()
+	handler := handlers.GetHandler(serverConf, serverStorage, nil)
+	down, rejecting := true, false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if rejecting {
+			// only the spooled snapshot is rejected
+			rejecting = false
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	sp, err := spool.New(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest := newRestReporter(config.AgentConfig{
+		ServerAddress: strings.TrimPrefix(ts.URL, "http://"),
+		BatchSize:     100}, nil, retryPolicy{}, nil)
+	report(context.Background(), rest, sp)
+
+	// rejected snapshot is dropped and does not block the current one
+	delta := int64(2)
+	err = storage.Agent.UpdateMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter", Delta: &delta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	down, rejecting = false, true
+	report(context.Background(), rest, sp)
+	if have := getPollCount(t, serverStorage); have != 2 {
+		t.Errorf("server PollCount mismatch: have %d, want 2", have)
+	}
+	if have := getPollCount(t, storage.Agent); have != 0 {
+		t.Errorf("agent PollCount mismatch: have %d, want 0", have)
+	}
+	names, err := sp.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Errorf("rejected snapshot must be removed from spool, have %d snapshots", len(names))
+	}
+}
+
+func TestRestReporterMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	err := certs.Generate(dir, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := func(name string) string { return path.Join(dir, name) }
+	serverTLS, err := certs.ServerTLSConfig(file(certs.ServerCertFile), file(certs.ServerKeyFile), file(certs.CAFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		sent     bool
+	}{
+		{name: "agent with certificate", certFile: file(certs.AgentCertFile),
+			keyFile: file(certs.AgentKeyFile), sent: true},
+		{name: "agent without certificate", sent: false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			storage.Agent = structs.NewMemoryStorage()
+			setAgentMetrics(t)
+
+			serverStorage := structs.NewMemoryStorage()
+			ts := httptest.NewUnstartedServer(handlers.GetHandler(serverConf, serverStorage, nil))
+			ts.TLS = serverTLS
+			ts.StartTLS()
+			defer ts.Close()
+
+			clientTLS, err := certs.ClientTLSConfig(tc.certFile, tc.keyFile, file(certs.CAFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rest := newRestReporter(config.AgentConfig{
+				ServerAddress: strings.TrimPrefix(ts.URL, "https://"),
+				BatchSize:     100}, nil, retryPolicy{}, clientTLS)
+			report(context.Background(), rest, nil)
+
+			_, err = serverStorage.GetMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter"})
+			if sent := err == nil; sent != tc.sent {
+				t.Errorf("metrics sent mismatch: have %t, want %t", sent, tc.sent)
+			}
+		})
+	}
+}
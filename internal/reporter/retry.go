@@ -0,0 +1,121 @@
+package reporter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+func newRetryPolicy(conf config.AgentConfig) retryPolicy {
+	return retryPolicy{attempts: conf.RetryAttempts,
+		backoff: conf.RetryBackoff, maxBackoff: conf.RetryMaxBackoff}
+}
+
+// isRetryable checks if request may succeed if sent again:
+// network errors, 5xx responses and gRPC Unavailable are retryable,
+// everything else (bad hash, untrusted subnet, TLS verification, bad URL etc.) is permanent
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= http.StatusInternalServerError
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return isNetworkError(urlErr.Err)
+	}
+
+	if s, ok := status.FromError(err); ok {
+		return s.Code() == codes.Unavailable
+	}
+	return false
+}
+
+// isRejected checks if server rejected request permanently (bad hash, untrusted subnet, bad metric etc.),
+// so metrics are dropped instead of being sent again. Throttled and timed out requests are not rejected
+func isRejected(err error) bool {
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	return statusErr.Code >= http.StatusBadRequest && statusErr.Code < http.StatusInternalServerError &&
+		statusErr.Code != http.StatusRequestTimeout && statusErr.Code != http.StatusTooManyRequests
+}
+
+// isNetworkError checks if the request failed on the way to the server: connection was refused or reset,
+// timed out or was closed by the server. Certificate and TLS errors are not retried as they persist
+func isNetworkError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var tlsRecord tls.RecordHeaderError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalidCert) ||
+		errors.As(err, &hostname) || errors.As(err, &tlsRecord) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// delay returns backoff before the next attempt:
+// exponentially growing value with random jitter (from delay/2 to delay). Zero maxBackoff means no cap
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && (p.maxBackoff <= 0 || d < p.maxBackoff) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if p.maxBackoff > 0 && d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// do calls f until it succeeds, returns permanent error or attempts are exhausted
+func (p retryPolicy) do(ctx context.Context, f func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil || !isRetryable(err) || attempt >= p.attempts {
+			return err
+		}
+		d := p.delay(attempt)
+		log.Printf("WARN attempt %d/%d failed: %s. Retrying in %s", attempt, p.attempts, err.Error(), d)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(d):
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package reporter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tt := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "connection refused",
+			err:  &url.Error{Op: "Post", URL: "http://localhost", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}},
+			want: true},
+		{name: "connection reset",
+			err:  &url.Error{Op: "Post", URL: "http://localhost", Err: syscall.ECONNRESET},
+			want: true},
+		{name: "connection closed by server",
+			err:  &url.Error{Op: "Post", URL: "http://localhost", Err: io.EOF},
+			want: true},
+		{name: "timeout",
+			err:  &url.Error{Op: "Post", URL: "http://localhost", Err: context.DeadlineExceeded},
+			want: true},
+		{name: "unknown certificate authority",
+			err:  &url.Error{Op: "Post", URL: "https://localhost", Err: x509.UnknownAuthorityError{}},
+			want: false},
+		{name: "certificate hostname mismatch",
+			err:  &url.Error{Op: "Post", URL: "https://localhost", Err: x509.HostnameError{Host: "localhost"}},
+			want: false},
+		{name: "http request to https server",
+			err:  &url.Error{Op: "Post", URL: "https://localhost", Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}},
+			want: false},
+		{name: "unsupported protocol scheme",
+			err:  &url.Error{Op: "Post", URL: "localhost:8080", Err: errors.New(`unsupported protocol scheme ""`)},
+			want: false},
+		{name: "bad url",
+			err:  &url.Error{Op: "parse", URL: "http://local host", Err: errors.New("invalid character \" \" in host name")},
+			want: false},
+		{name: "internal server error", err: &statusError{Code: 500}, want: true},
+		{name: "service unavailable", err: &statusError{Code: 503}, want: true},
+		{name: "bad hash", err: &statusError{Code: 400}, want: false},
+		{name: "untrusted subnet", err: &statusError{Code: 403}, want: false},
+		{name: "gRPC Unavailable", err: status.Error(codes.Unavailable, "down"), want: true},
+		{name: "gRPC InvalidArgument", err: status.Error(codes.InvalidArgument, "bad hash"), want: false},
+		{name: "gRPC PermissionDenied", err: status.Error(codes.PermissionDenied, "denied"), want: false},
+		{name: "context canceled", err: context.Canceled, want: false},
+		{name: "other error", err: errors.New("failed to encode metric"), want: false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if have := isRetryable(tc.err); have != tc.want {
+				t.Errorf("isRetryable(%v) mismatch: have %t, want %t", tc.err, have, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsRejected(t *testing.T) {
+	tt := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "bad hash", err: &statusError{Code: 400}, want: true},
+		{name: "untrusted subnet", err: &statusError{Code: 403}, want: true},
+		{name: "request timeout", err: &statusError{Code: 408}, want: false},
+		{name: "too many requests", err: &statusError{Code: 429}, want: false},
+		{name: "internal server error", err: &statusError{Code: 500}, want: false},
+		{name: "connection refused",
+			err:  &url.Error{Op: "Post", URL: "http://localhost", Err: syscall.ECONNREFUSED},
+			want: false},
+		{name: "unknown certificate authority",
+			err:  &url.Error{Op: "Post", URL: "https://localhost", Err: x509.UnknownAuthorityError{}},
+			want: false},
+		{name: "context canceled", err: context.Canceled, want: false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if have := isRejected(tc.err); have != tc.want {
+				t.Errorf("isRejected(%v) mismatch: have %t, want %t", tc.err, have, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := retryPolicy{attempts: 10, backoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	tt := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: 100 * time.Millisecond},
+		{attempt: 2, max: 200 * time.Millisecond},
+		{attempt: 3, max: 400 * time.Millisecond},
+		{attempt: 8, max: time.Second},
+	}
+	for _, tc := range tt {
+		t.Run(tc.max.String(), func(t *testing.T) {
+			d := p.delay(tc.attempt)
+			if d < tc.max/2 || d > tc.max {
+				t.Errorf("delay for attempt %d is out of range: have %s, want [%s, %s]",
+					tc.attempt, d, tc.max/2, tc.max)
+			}
+		})
+	}
+
+	// zero maxBackoff means no cap
+	p = retryPolicy{attempts: 100, backoff: 100 * time.Millisecond}
+	tt = []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 5, max: 1600 * time.Millisecond},
+		{attempt: 12, max: 204800 * time.Millisecond},
+	}
+	for _, tc := range tt {
+		t.Run("no cap "+tc.max.String(), func(t *testing.T) {
+			d := p.delay(tc.attempt)
+			if d < tc.max/2 || d > tc.max {
+				t.Errorf("delay for attempt %d is out of range: have %s, want [%s, %s]",
+					tc.attempt, d, tc.max/2, tc.max)
+			}
+		})
+	}
+	if d := p.delay(100); d <= 0 {
+		t.Errorf("delay for attempt 100 overflowed: %s", d)
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	p := retryPolicy{attempts: 3, backoff: time.Millisecond, maxBackoff: 5 * time.Millisecond}
+	tt := []struct {
+		name  string
+		errs  []error
+		calls int
+		fails bool
+	}{
+		{name: "success", errs: []error{nil}, calls: 1},
+		{name: "success after retry",
+			errs: []error{&statusError{Code: 502}, nil}, calls: 2},
+		{name: "permanent error",
+			errs: []error{&statusError{Code: 400}}, calls: 1, fails: true},
+		{name: "attempts exhausted",
+			errs:  []error{&statusError{Code: 500}, &statusError{Code: 500}, &statusError{Code: 500}},
+			calls: 3, fails: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			err := p.do(context.Background(), func() error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			})
+			if calls != tc.calls {
+				t.Errorf("calls count mismatch: have %d, want %d", calls, tc.calls)
+			}
+			if (err != nil) != tc.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
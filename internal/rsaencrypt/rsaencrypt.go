@@ -1,17 +1,35 @@
 package rsaencrypt
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 )
 
+// VersionHeader holds version of the scheme request body was encrypted with.
+// Requests without the header are treated as Version1
+const VersionHeader = "X-Encryption-Version"
+
+// Version1 is raw RSA-OAEP (Encrypt/Decrypt), message size is limited by the key size
+const Version1 = "1"
+
+// Version2 is envelope encryption (EncryptHybrid/DecryptHybrid)
+const Version2 = "2"
+
+var ErrUnsupportedVersion = errors.New("unsupported encryption version")
+
+const aesKeySize = 32
+
 func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
 	// reading file
 	bytes, err := ioutil.ReadFile(path)
@@ -52,6 +70,91 @@ func Decrypt(private *rsa.PrivateKey, message []byte, label []byte) ([]byte, err
 	return ciphertext, err
 }
 
+// EncryptHybrid encrypts message with a random AES-256-GCM key, the key itself is encrypted with RSA-OAEP.
+// Result layout: wrapped key length (2 bytes, big endian) | wrapped key | nonce | sealed message.
+// label is used both as OAEP label and as GCM additional data
+func EncryptHybrid(public *rsa.PublicKey, message []byte, label []byte) ([]byte, error) {
+	key := make([]byte, aesKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate AES key: %s", err.Error())
+	}
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, public, key, label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt AES key: %s", err.Error())
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %s", err.Error())
+	}
+
+	out := make([]byte, 2, 2+len(wrappedKey)+len(nonce)+len(message)+gcm.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(wrappedKey)))
+	out = append(out, wrappedKey...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, message, label), nil
+}
+
+func DecryptHybrid(private *rsa.PrivateKey, message []byte, label []byte) ([]byte, error) {
+	if len(message) < 2 {
+		return nil, errors.New("message is too short")
+	}
+	keyLen := int(binary.BigEndian.Uint16(message))
+	message = message[2:]
+	if len(message) < keyLen {
+		return nil, errors.New("message is too short")
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, private, message[:keyLen], label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt AES key: %s", err.Error())
+	}
+	message = message[keyLen:]
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(message) < gcm.NonceSize() {
+		return nil, errors.New("message is too short")
+	}
+	nonce, sealed := message[:gcm.NonceSize()], message[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %s", err.Error())
+	}
+	return plain, nil
+}
+
+// DecryptVersion decrypts message with the scheme of the given version (see VersionHeader)
+func DecryptVersion(private *rsa.PrivateKey, version string, message []byte, label []byte) ([]byte, error) {
+	switch version {
+	case "", Version1:
+		return Decrypt(private, message, label)
+	case Version2:
+		return DecryptHybrid(private, message, label)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersion, version)
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %s", err.Error())
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %s", err.Error())
+	}
+	return gcm, nil
+}
+
 func Generate(outDir string) error {
 	// generate key
 	privatekey, err := rsa.GenerateKey(rand.Reader, 4096)
@@ -1,6 +1,10 @@
 package rsaencrypt
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
 	"log"
 	"os"
 	"testing"
@@ -69,3 +73,129 @@ func TestRSA(t *testing.T) {
 	})
 
 }
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		t.Fatalf("Cant generate random bytes: %s", err.Error())
+	}
+	return b
+}
+
+func TestHybrid(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		t.Fatalf("Cant generate key: %s", err.Error())
+	}
+	label := []byte("testHybrid")
+
+	tt := []struct {
+		name string
+		size int
+	}{
+		{name: "empty message", size: 0},
+		{name: "small message", size: 100},
+		{name: "message larger than RSA limit", size: 4096},
+		{name: "large message", size: 4 * 1024 * 1024},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			message := randomBytes(t, tc.size)
+			enc, err := EncryptHybrid(&privateKey.PublicKey, message, label)
+			if err != nil {
+				t.Fatalf("Failed to encrypt message: %s", err.Error())
+			}
+			dec, err := DecryptVersion(privateKey, Version2, enc, label)
+			if err != nil {
+				t.Fatalf("Failed to decrypt message: %s", err.Error())
+			}
+			if !bytes.Equal(dec, message) {
+				t.Errorf("Decrypted message does not match the original")
+			}
+		})
+	}
+
+	t.Run("tampered message", func(t *testing.T) {
+		enc, err := EncryptHybrid(&privateKey.PublicKey, randomBytes(t, 1024), label)
+		if err != nil {
+			t.Fatalf("Failed to encrypt message: %s", err.Error())
+		}
+		enc[len(enc)-1] ^= 0xff
+		_, err = DecryptHybrid(privateKey, enc, label)
+		if err == nil {
+			t.Errorf("Tampered message was decrypted")
+		}
+	})
+
+	t.Run("wrong label", func(t *testing.T) {
+		enc, err := EncryptHybrid(&privateKey.PublicKey, randomBytes(t, 1024), label)
+		if err != nil {
+			t.Fatalf("Failed to encrypt message: %s", err.Error())
+		}
+		_, err = DecryptHybrid(privateKey, enc, []byte("wrong"))
+		if err == nil {
+			t.Errorf("Message was decrypted with wrong label")
+		}
+	})
+
+	t.Run("truncated message", func(t *testing.T) {
+		enc, err := EncryptHybrid(&privateKey.PublicKey, randomBytes(t, 1024), label)
+		if err != nil {
+			t.Fatalf("Failed to encrypt message: %s", err.Error())
+		}
+		for _, n := range []int{0, 1, 100, 530} {
+			_, err = DecryptHybrid(privateKey, enc[:n], label)
+			if err == nil {
+				t.Errorf("Message truncated to %d bytes was decrypted", n)
+			}
+		}
+	})
+}
+
+func TestDecryptVersion(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Cant generate key: %s", err.Error())
+	}
+	label := []byte("testDecryptVersion")
+	message := []byte("this is very secret string")
+
+	v1, err := Encrypt(&privateKey.PublicKey, message, label)
+	if err != nil {
+		t.Fatalf("Failed to encrypt message: %s", err.Error())
+	}
+	v2, err := EncryptHybrid(&privateKey.PublicKey, message, label)
+	if err != nil {
+		t.Fatalf("Failed to encrypt message: %s", err.Error())
+	}
+
+	tt := []struct {
+		name    string
+		version string
+		message []byte
+		wantErr error
+	}{
+		{name: "no version", version: "", message: v1},
+		{name: "version 1", version: Version1, message: v1},
+		{name: "version 2", version: Version2, message: v2},
+		{name: "unknown version", version: "3", message: v2, wantErr: ErrUnsupportedVersion},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dec, err := DecryptVersion(privateKey, tc.version, tc.message, label)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Error mismatch: have %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to decrypt message: %s", err.Error())
+			}
+			if !bytes.Equal(dec, message) {
+				t.Errorf("Decrypted message does not match the original: have: %s, want: %s", dec, message)
+			}
+		})
+	}
+}
@@ -2,16 +2,20 @@
 package serializer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/zklevsha/go-musthave-devops/internal/archive"
 	"github.com/zklevsha/go-musthave-devops/internal/pb"
 	"github.com/zklevsha/go-musthave-devops/internal/structs"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func DecodeBody(body io.Reader) (structs.Metric, error) {
@@ -79,10 +83,123 @@ func DecodeURL(r *http.Request) (structs.Metric, error) {
 	}
 }
 
+// parseTime parses time passed as RFC3339 string or as unix timestamp (seconds)
+func parseTime(value string) (time.Time, error) {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(i, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// DecodeHistoryURL returns metric and time range requested at /history/ endpoint.
+// If 'from' is not set, range starts at the beginning of the history.
+// If 'to' is not set, range ends now.
+func DecodeHistoryURL(r *http.Request) (structs.Metric, time.Time, time.Time, error) {
+	v := mux.Vars(r)
+	m := structs.Metric{ID: v["metricID"], MType: v["metricType"]}
+	if m.MType != "counter" && m.MType != "gauge" {
+		return structs.Metric{}, time.Time{}, time.Time{}, structs.ErrMetricBadType
+	}
+
+	from := time.Time{}
+	to := time.Now()
+	var err error
+	q := r.URL.Query()
+	if q.Get("from") != "" {
+		from, err = parseTime(q.Get("from"))
+		if err != nil {
+			return structs.Metric{}, time.Time{}, time.Time{}, structs.ErrMetricBadAttrValue
+		}
+	}
+	if q.Get("to") != "" {
+		to, err = parseTime(q.Get("to"))
+		if err != nil {
+			return structs.Metric{}, time.Time{}, time.Time{}, structs.ErrMetricBadAttrValue
+		}
+	}
+	return m, from, to, nil
+}
+
+// DecodeMatchers returns label matchers set by repeated 'match' query parameter
+// (e.g. ?match=host=web1&match=env!~dev|test)
+func DecodeMatchers(r *http.Request) ([]structs.LabelMatcher, error) {
+	var matchers []structs.LabelMatcher
+	for _, raw := range r.URL.Query()["match"] {
+		m, err := structs.ParseLabelMatcher(raw)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// DecodeListQuery returns metrics listing query set by /api/metrics query parameters
+// (type, prefix, regex, sort, order, cursor, limit)
+func DecodeListQuery(r *http.Request) (structs.ListQuery, error) {
+	v := r.URL.Query()
+	q := structs.ListQuery{MType: v.Get("type"), Prefix: v.Get("prefix"), Cursor: v.Get("cursor")}
+	if q.MType != "" && q.MType != "counter" && q.MType != "gauge" {
+		return structs.ListQuery{}, fmt.Errorf("%w: bad type %s", structs.ErrMetricBadAttrValue, q.MType)
+	}
+	if raw := v.Get("regex"); raw != "" {
+		re, err := regexp.Compile(raw)
+		if err != nil {
+			return structs.ListQuery{}, fmt.Errorf("%w: bad regex %s: %s", structs.ErrMetricBadAttrValue, raw, err.Error())
+		}
+		q.Regexp = re
+	}
+	switch raw := v.Get("sort"); raw {
+	case "", structs.SortByID:
+		q.SortBy = structs.SortByID
+	case structs.SortByType:
+		q.SortBy = structs.SortByType
+	default:
+		return structs.ListQuery{}, fmt.Errorf("%w: bad sort %s", structs.ErrMetricBadAttrValue, raw)
+	}
+	switch raw := v.Get("order"); raw {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return structs.ListQuery{}, fmt.Errorf("%w: bad order %s", structs.ErrMetricBadAttrValue, raw)
+	}
+	q.Limit = structs.ListLimitDefault
+	if raw := v.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > structs.ListLimitMax {
+			return structs.ListQuery{}, fmt.Errorf("%w: bad limit %s (must be 1..%d)",
+				structs.ErrMetricBadAttrValue, raw, structs.ListLimitMax)
+		}
+		q.Limit = limit
+	}
+	if q.Cursor != "" {
+		if _, err := structs.DecodeListCursor(q.Cursor); err != nil {
+			return structs.ListQuery{}, err
+		}
+	}
+	return q, nil
+}
+
+// DecodeResolution returns rollup resolution requested at /history/ endpoint.
+// Zero resolution means raw samples
+func DecodeResolution(r *http.Request) (time.Duration, error) {
+	switch r.URL.Query().Get("resolution") {
+	case "", "raw":
+		return 0, nil
+	case "1m":
+		return structs.RollupMinute, nil
+	case "1h":
+		return structs.RollupHour, nil
+	default:
+		return 0, structs.ErrBadResolution
+	}
+}
+
 func EncodeBodyMetrics(metrics []structs.Metric, key string) ([]byte, error) {
 	if key != "" {
-		for _, m := range metrics {
-			m.SetHash(key)
+		for i := range metrics {
+			metrics[i].SetHash(key)
 		}
 	}
 	return json.Marshal(metrics)
@@ -140,8 +257,8 @@ func EncodeServerResponse(resp structs.ServerResponse, compress bool, asText boo
 	return compressed, nil
 }
 
-func EncodeMetrics(store structs.Storage) ([]byte, error) {
-	metrics, err := store.GetMetrics()
+func EncodeMetrics(ctx context.Context, store structs.Storage) ([]byte, error) {
+	metrics, err := store.GetMetrics(ctx)
 	if err != nil {
 		e := fmt.Errorf("failed to get metrics: %s", err.Error())
 		return []byte{}, e
@@ -155,7 +272,7 @@ func EncodeMetrics(store structs.Storage) ([]byte, error) {
 
 func DecodeGRPCMetric(in *pb.Metric) (structs.Metric, error) {
 	if in == nil {
-		return structs.Metric{}, fmt.Errorf("*pb.Metric is nil")
+		return structs.Metric{}, fmt.Errorf("%w: *pb.Metric is nil", structs.ErrMetricNullAttr)
 	}
 
 	m := structs.Metric{
@@ -163,13 +280,16 @@ func DecodeGRPCMetric(in *pb.Metric) (structs.Metric, error) {
 		MType: in.Mtype,
 		Hash:  in.Hash,
 	}
+	if len(in.Labels) > 0 {
+		m.Labels = structs.Labels(in.Labels)
+	}
 
 	if m.MType == "gauge" {
 		m.Value = &in.Value
 	} else if m.MType == "counter" {
 		m.Delta = &in.Delta
 	} else {
-		return structs.Metric{}, fmt.Errorf("metric type %s is not supported", m.MType)
+		return structs.Metric{}, fmt.Errorf("%w: %s", structs.ErrMetricBadType, m.MType)
 	}
 
 	return m, nil
@@ -178,9 +298,10 @@ func DecodeGRPCMetric(in *pb.Metric) (structs.Metric, error) {
 
 func EncodeGRPCMetric(m structs.Metric) (*pb.Metric, error) {
 	p := pb.Metric{
-		Id:    m.ID,
-		Mtype: m.MType,
-		Hash:  m.Hash,
+		Id:     m.ID,
+		Mtype:  m.MType,
+		Hash:   m.Hash,
+		Labels: m.Labels,
 	}
 
 	if m.MType == "gauge" {
@@ -193,3 +314,30 @@ func EncodeGRPCMetric(m structs.Metric) (*pb.Metric, error) {
 
 	return &p, nil
 }
+
+func EncodeGRPCMetrics(metrics []structs.Metric) ([]*pb.Metric, error) {
+	var out = []*pb.Metric{}
+	for _, m := range metrics {
+		p, err := EncodeGRPCMetric(m)
+		if err != nil {
+			return []*pb.Metric{}, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func EncodeGRPCSamples(samples []structs.Sample) []*pb.Sample {
+	var out = []*pb.Sample{}
+	for _, s := range samples {
+		p := pb.Sample{Timestamp: timestamppb.New(s.Timestamp)}
+		if s.Delta != nil {
+			p.Delta = *s.Delta
+		}
+		if s.Value != nil {
+			p.Value = *s.Value
+		}
+		out = append(out, &p)
+	}
+	return out
+}
@@ -0,0 +1,352 @@
+// Package spool implements on-disk queue of metric snapshots the agent failed to send
+package spool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+const fileExt = ".json"
+const tmpExt = ".tmp"
+
+// seqFile keeps the last snapshot sequence number, so numbering survives restarts of the agent
+// even when the spool is empty
+const seqFile = "seq"
+
+// Spool keeps snapshots as separate json files named seq-created.json, where seq is
+// a zero padded sequence number and created is creation time in nanoseconds.
+// Lexical order of the file names is the order snapshots should be replayed in, regardless of clock changes.
+// Spool is bounded by total size of the files (maxSize bytes) and by age of the snapshots (maxAge),
+// zero value means no limit
+type Spool struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	mx      sync.Mutex
+	// seq is the number of the last snapshot
+	seq uint64
+}
+
+func New(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool dir %s: %s", dir, err.Error())
+	}
+	// removing leftovers of interrupted writes
+	tmpFiles, err := filepath.Glob(filepath.Join(dir, "*"+tmpExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list spool dir %s: %s", dir, err.Error())
+	}
+	for _, f := range tmpFiles {
+		os.Remove(f)
+	}
+	s := &Spool{dir: dir, maxSize: maxSize, maxAge: maxAge}
+	s.seq, err = s.lastSeq()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// lastSeq returns the greatest of the saved sequence number and numbers of spooled snapshots
+// (the number is saved after the snapshot is written, so it may lag behind after crash)
+func (s *Spool) lastSeq() (uint64, error) {
+	var last uint64
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, seqFile))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to read spool sequence number: %s", err.Error())
+	}
+	if err == nil {
+		last, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			log.Printf("WARN spool sequence number %s is invalid: %s", string(b), err.Error())
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+fileExt))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list spool dir %s: %s", s.dir, err.Error())
+	}
+	for _, f := range files {
+		seq, _, err := parseName(filepath.Base(f))
+		if err == nil && seq > last {
+			last = seq
+		}
+	}
+	return last, nil
+}
+
+// parseName returns snapshot sequence number and creation time encoded in the file name.
+// Snapshots spooled before sequence numbers were introduced are named after creation time only
+func parseName(name string) (uint64, time.Time, error) {
+	parts := strings.SplitN(strings.TrimSuffix(name, fileExt), "-", 2)
+	seq, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	ns := int64(seq)
+	if len(parts) == 2 {
+		ns, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+	return seq, time.Unix(0, ns), nil
+}
+
+// saveSeq persists the last snapshot sequence number
+func (s *Spool) saveSeq() error {
+	path := filepath.Join(s.dir, seqFile)
+	err := ioutil.WriteFile(path+tmpExt, []byte(strconv.FormatUint(s.seq, 10)), 0644)
+	if err == nil {
+		err = os.Rename(path+tmpExt, path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save spool sequence number: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *Spool) write(name string, metrics []structs.Metric) error {
+	b, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("failed to encode metrics: %s", err.Error())
+	}
+	tmp := filepath.Join(s.dir, name+tmpExt)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", tmp, err.Error())
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %s", tmp, err.Error())
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+// Put saves snapshot to the spool and enforces spool limits
+func (s *Spool) Put(metrics []structs.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.seq++
+	err := s.write(fmt.Sprintf("%020d-%d%s", s.seq, time.Now().UnixNano(), fileExt), metrics)
+	if err != nil {
+		return err
+	}
+	err = s.saveSeq()
+	if err != nil {
+		return err
+	}
+	return s.enforceLimits()
+}
+
+// enforceLimits evicts expired snapshots and the oldest ones while spool exceeds maxSize.
+// Counter deltas of an evicted snapshot are merged into the next one, so counter totals are not lost.
+// Gauges of an expired snapshot are dropped, gauges of a snapshot evicted by size are kept
+// unless the next snapshot has newer values
+func (s *Spool) enforceLimits() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list spool dir %s: %s", s.dir, err.Error())
+	}
+	type snapshot struct {
+		name    string
+		size    int64
+		created time.Time
+	}
+	var snapshots []snapshot
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+		_, created, err := parseName(f.Name())
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{name: f.Name(), size: f.Size(), created: created})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].name < snapshots[j].name })
+
+	var kept []snapshot
+	var total int64
+	for i, sn := range snapshots {
+		if s.maxAge <= 0 || time.Since(sn.created) <= s.maxAge {
+			kept = append(kept, sn)
+			total += sn.size
+			continue
+		}
+		if i == len(snapshots)-1 {
+			log.Printf("WARN spool snapshot %s is older than %s, dropping it", sn.name, s.maxAge)
+			os.Remove(filepath.Join(s.dir, sn.name))
+			continue
+		}
+		log.Printf("WARN spool snapshot %s is older than %s, merging its counters into %s",
+			sn.name, s.maxAge, snapshots[i+1].name)
+		snapshots[i+1].size = s.evict(sn.name, snapshots[i+1].name, false)
+	}
+
+	// the newest snapshot is always kept
+	for s.maxSize > 0 && total > s.maxSize && len(kept) > 1 {
+		log.Printf("WARN spool size exceeds %d bytes, merging the oldest snapshot %s into %s",
+			s.maxSize, kept[0].name, kept[1].name)
+		size := s.evict(kept[0].name, kept[1].name, true)
+		total += size - kept[0].size - kept[1].size
+		kept[1].size = size
+		kept = kept[1:]
+	}
+	return nil
+}
+
+// evict removes snapshot from after merging it into snapshot to (see merge) and returns the new size of to.
+// Snapshot from is dropped if merge fails
+func (s *Spool) evict(from, to string, keepGauges bool) int64 {
+	size, err := s.merge(from, to, keepGauges)
+	if err != nil {
+		log.Printf("ERROR failed to merge spool snapshot %s into %s: %s. Snapshot will be dropped",
+			from, to, err.Error())
+	}
+	os.Remove(filepath.Join(s.dir, from))
+	return size
+}
+
+// merge adds counter deltas of snapshot from to snapshot to. Gauges are moved only if keepGauges is set
+// and snapshot to has no value of the series. Returns the new size of snapshot to
+func (s *Spool) merge(from, to string, keepGauges bool) (int64, error) {
+	older, err := s.Read(from)
+	if err != nil {
+		return s.size(to), err
+	}
+	newer, err := s.Read(to)
+	if err != nil {
+		return s.size(to), err
+	}
+	index := map[string]int{}
+	for i, m := range newer {
+		index[m.MType+":"+structs.SeriesKey(m.ID, m.Labels)] = i
+	}
+	var moved []structs.Metric
+	for _, m := range older {
+		i, ok := index[m.MType+":"+structs.SeriesKey(m.ID, m.Labels)]
+		switch {
+		case m.MType == "counter" && m.Delta != nil && ok && newer[i].Delta != nil:
+			delta := *newer[i].Delta + *m.Delta
+			newer[i].Delta = &delta
+		case m.MType == "counter" || keepGauges && !ok:
+			moved = append(moved, m)
+		}
+	}
+	err = s.write(to, append(moved, newer...))
+	return s.size(to), err
+}
+
+func (s *Spool) size(name string) int64 {
+	f, err := os.Stat(filepath.Join(s.dir, name))
+	if err != nil {
+		return 0
+	}
+	return f.Size()
+}
+
+// List returns names of spooled snapshots, the oldest first
+func (s *Spool) List() ([]string, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	err := s.enforceLimits()
+	if err != nil {
+		return []string{}, err
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+fileExt))
+	if err != nil {
+		return []string{}, fmt.Errorf("failed to list spool dir %s: %s", s.dir, err.Error())
+	}
+	var names = []string{}
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Spool) Read(name string) ([]structs.Metric, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return []structs.Metric{}, fmt.Errorf("failed to read spool snapshot %s: %s", name, err.Error())
+	}
+	var metrics = []structs.Metric{}
+	err = json.Unmarshal(b, &metrics)
+	if err != nil {
+		return []structs.Metric{}, fmt.Errorf("failed to decode spool snapshot %s: %s", name, err.Error())
+	}
+	return metrics, nil
+}
+
+// Replace overwrites snapshot with metrics which are still unsent
+func (s *Spool) Replace(name string, metrics []structs.Metric) error {
+	if len(metrics) == 0 {
+		return s.Remove(name)
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.write(name, metrics)
+}
+
+func (s *Spool) Remove(name string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	err := os.Remove(filepath.Join(s.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove spool snapshot %s: %s", name, err.Error())
+	}
+	return nil
+}
+
+// Replay sends spooled snapshots in order. send returns metrics which were not accepted and may be sent again,
+// metrics rejected permanently must not be returned, otherwise the snapshot would block the spool forever.
+// Replay stops at the first snapshot that was not sent completely and returns false,
+// so newer data is not sent before the older one
+func (s *Spool) Replay(send func([]structs.Metric) []structs.Metric) (bool, error) {
+	names, err := s.List()
+	if err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		metrics, err := s.Read(name)
+		if err != nil {
+			log.Printf("ERROR %s. Snapshot will be dropped", err.Error())
+			s.Remove(name)
+			continue
+		}
+		unsent := send(metrics)
+		err = s.Replace(name, unsent)
+		if err != nil {
+			return false, err
+		}
+		if len(unsent) > 0 {
+			return false, nil
+		}
+		log.Printf("INFO spool snapshot %s was sent", name)
+	}
+	return true, nil
+}
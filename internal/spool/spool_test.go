@@ -0,0 +1,241 @@
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+func counter(id string, delta int64) structs.Metric {
+	return structs.Metric{ID: id, MType: "counter", Delta: &delta}
+}
+
+func gauge(id string, value float64) structs.Metric {
+	return structs.Metric{ID: id, MType: "gauge", Value: &value}
+}
+
+func TestReplay(t *testing.T) {
+	tt := []struct {
+		name      string
+		snapshots [][]structs.Metric
+		// number of metrics the server accepts before going down
+		accept   int
+		replayed bool
+		sent     int
+		left     int
+	}{
+		{name: "empty spool", replayed: true},
+		{name: "all snapshots sent",
+			snapshots: [][]structs.Metric{{counter("a", 1)}, {counter("a", 2), counter("b", 1)}},
+			accept:    10, replayed: true, sent: 3, left: 0},
+		{name: "server goes down",
+			snapshots: [][]structs.Metric{{counter("a", 1)}, {counter("a", 2), counter("b", 1)}, {counter("a", 3)}},
+			accept:    2, replayed: false, sent: 2, left: 2},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := New(dir, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, snapshot := range tc.snapshots {
+				err = s.Put(snapshot)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// spool must survive restarts
+			s, err = New(dir, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sent []structs.Metric
+			replayed, err := s.Replay(func(metrics []structs.Metric) []structs.Metric {
+				for i, m := range metrics {
+					if len(sent) == tc.accept {
+						return metrics[i:]
+					}
+					sent = append(sent, m)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replayed != tc.replayed {
+				t.Errorf("replayed mismatch: have %t, want %t", replayed, tc.replayed)
+			}
+			if len(sent) != tc.sent {
+				t.Errorf("sent metrics count mismatch: have %d, want %d", len(sent), tc.sent)
+			}
+			// snapshots must be replayed in order
+			for i := 1; i < len(sent); i++ {
+				if sent[i].ID == "a" && sent[i-1].ID == "a" && *sent[i].Delta < *sent[i-1].Delta {
+					t.Errorf("snapshots were replayed out of order: %v", sent)
+				}
+			}
+
+			var left int
+			names, err := s.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range names {
+				metrics, err := s.Read(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				left += len(metrics)
+			}
+			if left != tc.left {
+				t.Errorf("spooled metrics count mismatch: have %d, want %d", left, tc.left)
+			}
+		})
+	}
+}
+
+// spooled returns metrics of all snapshots keyed by type and id
+func spooled(t *testing.T, s *Spool) map[string]structs.Metric {
+	names, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := map[string]structs.Metric{}
+	for _, name := range names {
+		metrics, err := s.Read(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range metrics {
+			res[m.MType+":"+m.ID] = m
+		}
+	}
+	return res
+}
+
+func TestLimits(t *testing.T) {
+	t.Run("max size", func(t *testing.T) {
+		s, err := New(t.TempDir(), 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots := [][]structs.Metric{
+			{counter("a", 1), gauge("g", 1), gauge("h", 1)},
+			{counter("a", 2), counter("b", 5), gauge("g", 2)},
+			{counter("a", 3)},
+		}
+		for _, metrics := range snapshots {
+			err = s.Put(metrics)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		names, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 1 {
+			t.Fatalf("snapshots count mismatch: have %d, want 1", len(names))
+		}
+		have := spooled(t, s)
+		if len(have) != 4 {
+			t.Errorf("merged metrics count mismatch: have %d, want 4", len(have))
+		}
+		for id, want := range map[string]int64{"a": 6, "b": 5} {
+			m, ok := have["counter:"+id]
+			if !ok {
+				t.Errorf("counter %s is missing", id)
+			} else if *m.Delta != want {
+				t.Errorf("counter %s deltas must be merged, have %d, want %d", id, *m.Delta, want)
+			}
+		}
+		// g is superseded by the second snapshot, h is not
+		for id, want := range map[string]float64{"g": 2, "h": 1} {
+			m, ok := have["gauge:"+id]
+			if !ok {
+				t.Errorf("gauge %s is missing", id)
+			} else if *m.Value != want {
+				t.Errorf("gauge %s value mismatch: have %f, want %f", id, *m.Value, want)
+			}
+		}
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		dir := t.TempDir()
+		old := filepath.Join(dir, "00000000000000000001.json")
+		err := os.WriteFile(old, []byte(`[{"id":"a","type":"counter","delta":1},{"id":"g","type":"gauge","value":1}]`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := New(dir, 0, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Put([]structs.Metric{counter("a", 2)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 1 {
+			t.Errorf("expired snapshot must be dropped, have %d snapshots", len(names))
+		}
+		have := spooled(t, s)
+		m, ok := have["counter:a"]
+		if !ok {
+			t.Error("counter a is missing")
+		} else if *m.Delta != 3 {
+			t.Errorf("expired counter deltas must be merged, have %d, want 3", *m.Delta)
+		}
+		if _, ok := have["gauge:g"]; ok {
+			t.Error("expired gauge must be dropped")
+		}
+	})
+}
+
+func TestSequence(t *testing.T) {
+	dir := t.TempDir()
+	// snapshot named after creation time before sequence numbers were introduced
+	legacy := filepath.Join(dir, fmt.Sprintf("%020d.json", time.Now().UnixNano()))
+	err := os.WriteFile(legacy, []byte(`[{"id":"a","type":"counter","delta":1}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last string
+	for i := int64(2); i <= 4; i++ {
+		// restarting agent with empty spool must not reuse sequence numbers
+		s, err := New(dir, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Put([]structs.Metric{counter("a", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := names[len(names)-1]
+		if name <= last {
+			t.Errorf("snapshot %s must be ordered after %s", name, last)
+		}
+		last = name
+		if i == 3 {
+			for _, name := range names {
+				err = s.Remove(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+}
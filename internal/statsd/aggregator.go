@@ -0,0 +1,166 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sync"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// gaugeState is a gauge value accumulated during flush window.
+// If no absolute value was received, value is applied to the stored gauge
+type gaugeState struct {
+	value    float64
+	absolute bool
+}
+
+type timerState struct {
+	count float64
+	sum   float64
+	n     int
+	min   float64
+	max   float64
+}
+
+// aggregator accumulates samples between flushes
+type aggregator struct {
+	mx       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]*gaugeState
+	timers   map[string]*timerState
+	// remainders holds fractional parts of the flushed counters (sampled counters are scaled by 1/rate),
+	// they are added to the next flush of the counter
+	remainders map[string]float64
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{
+		counters:   map[string]float64{},
+		gauges:     map[string]*gaugeState{},
+		timers:     map[string]*timerState{},
+		remainders: map[string]float64{},
+	}
+}
+
+func (a *aggregator) add(s sample) {
+	// huge values scaled by small rate may overflow
+	if !finite(s.value / s.rate) {
+		log.Printf("WARN statsd skipping %s sample: value is not finite", s.name)
+		return
+	}
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	switch s.mtype {
+	case typeCounter:
+		a.counters[s.name] += s.value / s.rate
+	case typeGauge:
+		g, ok := a.gauges[s.name]
+		if !ok {
+			g = &gaugeState{}
+			a.gauges[s.name] = g
+		}
+		if s.relative {
+			g.value += s.value
+		} else {
+			g.value, g.absolute = s.value, true
+		}
+	case typeTimer:
+		t, ok := a.timers[s.name]
+		if !ok {
+			t = &timerState{min: s.value, max: s.value}
+			a.timers[s.name] = t
+		}
+		t.count += 1 / s.rate
+		t.sum += s.value
+		t.n++
+		t.min = math.Min(t.min, s.value)
+		t.max = math.Max(t.max, s.value)
+	}
+}
+
+// take returns accumulated state and resets the aggregator
+func (a *aggregator) take() (map[string]float64, map[string]*gaugeState, map[string]*timerState) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	counters, gauges, timers := a.counters, a.gauges, a.timers
+	a.counters = map[string]float64{}
+	a.gauges = map[string]*gaugeState{}
+	a.timers = map[string]*timerState{}
+	return counters, gauges, timers
+}
+
+// counter rounds accumulated value of the counter carrying the fractional remainder over to the next flush.
+// Values that are not finite or don't fit counter delta are dropped
+func (a *aggregator) counter(id string, v float64) (structs.Metric, bool) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	v += a.remainders[id]
+	delete(a.remainders, id)
+	rounded := math.Round(v)
+	if math.IsNaN(rounded) || rounded >= math.MaxInt64 || rounded < math.MinInt64 {
+		log.Printf("WARN statsd dropping counter %s: value %f is out of range", id, v)
+		return structs.Metric{}, false
+	}
+	if v != rounded {
+		a.remainders[id] = v - rounded
+	}
+	delta := int64(rounded)
+	return structs.Metric{ID: id, MType: "counter", Delta: &delta}, true
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func gauge(id string, v float64) structs.Metric {
+	return structs.Metric{ID: id, MType: "gauge", Value: &v}
+}
+
+// metrics converts accumulated state to storage metrics.
+// Timers are stored as <name>.count counter and <name>.mean/.min/.max gauges
+func (a *aggregator) metrics(ctx context.Context, store structs.Storage) []structs.Metric {
+	counters, gauges, timers := a.take()
+	var metrics []structs.Metric
+	for id, v := range counters {
+		if m, ok := a.counter(id, v); ok {
+			metrics = append(metrics, m)
+		}
+	}
+	for id, g := range gauges {
+		value := g.value
+		if !g.absolute {
+			current, err := store.GetMetric(ctx, structs.Metric{ID: id, MType: "gauge"})
+			switch {
+			case err == nil:
+				value += *current.Value
+			case errors.Is(err, structs.ErrMetricNotFound):
+			default:
+				log.Printf("ERROR statsd failed to get gauge %s, relative update will be skipped: %s",
+					id, err.Error())
+				continue
+			}
+		}
+		if !finite(value) {
+			log.Printf("WARN statsd dropping gauge %s: value is not finite", id)
+			continue
+		}
+		metrics = append(metrics, gauge(id, value))
+	}
+	for id, t := range timers {
+		if m, ok := a.counter(id+".count", t.count); ok {
+			metrics = append(metrics, m)
+		}
+		if !finite(t.sum) {
+			log.Printf("WARN statsd dropping timer %s gauges: sum is not finite", id)
+			continue
+		}
+		metrics = append(metrics,
+			gauge(id+".mean", t.sum/float64(t.n)),
+			gauge(id+".min", t.min),
+			gauge(id+".max", t.max))
+	}
+	return metrics
+}
@@ -0,0 +1,71 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// StatsD metric types
+const (
+	typeCounter = "c"
+	typeGauge   = "g"
+	typeTimer   = "ms"
+)
+
+// sample is a single parsed StatsD line
+type sample struct {
+	name  string
+	mtype string
+	value float64
+	// relative is set for gauges sent with explicit sign (+N/-N)
+	relative bool
+	rate     float64
+}
+
+// parseLine parses `name:value|type[|@rate][|#tags]` line. Tags are ignored
+func parseLine(line string) (sample, error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 2 {
+		return sample{}, fmt.Errorf("%w: line '%s' has no metric type", structs.ErrMetricNullAttr, line)
+	}
+	colon := strings.LastIndex(fields[0], ":")
+	if colon <= 0 {
+		return sample{}, fmt.Errorf("%w: line '%s' has no metric name", structs.ErrMetricNullAttr, line)
+	}
+	s := sample{name: fields[0][:colon], rate: 1}
+
+	raw, mtype := fields[0][colon+1:], fields[1]
+	switch mtype {
+	case typeCounter, typeTimer:
+	case typeGauge:
+		s.relative = strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-")
+	default:
+		return sample{}, fmt.Errorf("%w: line '%s' has unsupported type %s", structs.ErrMetricBadType, line, mtype)
+	}
+	s.mtype = mtype
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return sample{}, fmt.Errorf("%w: line '%s' has bad value: %s", structs.ErrMetricBadAttrValue, line, err.Error())
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return sample{}, fmt.Errorf("%w: line '%s' has non-finite value", structs.ErrMetricBadAttrValue, line)
+	}
+	s.value = value
+
+	for _, f := range fields[2:] {
+		if !strings.HasPrefix(f, "@") {
+			continue
+		}
+		rate, err := strconv.ParseFloat(f[1:], 64)
+		if err != nil || !(rate > 0 && rate <= 1) {
+			return sample{}, fmt.Errorf("%w: line '%s' has bad sample rate", structs.ErrMetricBadAttrValue, line)
+		}
+		s.rate = rate
+	}
+	return s, nil
+}
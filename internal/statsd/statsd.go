@@ -0,0 +1,173 @@
+// Package statsd implements StatsD listener: metrics received over UDP and TCP
+// are aggregated within a flush window and written to the storage
+package statsd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// maxPacketSize is the biggest UDP datagram the listener accepts
+const maxPacketSize = 65535
+
+type Server struct {
+	udp      net.PacketConn
+	tcp      net.Listener
+	interval time.Duration
+	store    structs.Storage
+	agg      *aggregator
+
+	connsMx sync.Mutex
+	conns   map[net.Conn]struct{}
+}
+
+// Listen binds UDP and TCP sockets on the same address
+func Listen(address string, interval time.Duration, store structs.Storage) (*Server, error) {
+	udp, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	// address may have zero port, so TCP listener takes the port chosen for UDP
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+	return &Server{udp: udp, tcp: tcp, interval: interval, store: store,
+		agg: newAggregator(), conns: map[net.Conn]struct{}{}}, nil
+}
+
+// Addr returns the address the listener is bound to
+func (s *Server) Addr() string {
+	return s.udp.LocalAddr().String()
+}
+
+// Start receives metrics and flushes them to the storage every interval.
+// On ctx.Done() sockets are closed and the remaining metrics are flushed
+func (s *Server) Start(ctx context.Context, wg *sync.WaitGroup) {
+	log.Printf("INFO statsd starting at %s", s.Addr())
+	defer wg.Done()
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go s.serveUDP(&readers)
+	go s.serveTCP(&readers)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("INFO statsd received ctx.Done(), returning")
+			s.close()
+			readers.Wait()
+			// ctx is done, the remaining metrics are written without it
+			s.flush(context.Background())
+			return
+		case <-ticker.C:
+			s.flush(ctx)
+		}
+	}
+}
+
+func (s *Server) close() {
+	s.udp.Close()
+	s.tcp.Close()
+	s.connsMx.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.connsMx.Unlock()
+}
+
+func (s *Server) flush(ctx context.Context) {
+	metrics := s.agg.metrics(ctx, s.store)
+	if len(metrics) == 0 {
+		return
+	}
+	err := s.store.UpdateMetrics(ctx, metrics)
+	if err != nil {
+		log.Printf("ERROR statsd failed to write %d metrics to storage: %s", len(metrics), err.Error())
+		return
+	}
+	log.Printf("INFO statsd flushed %d metrics", len(metrics))
+}
+
+func (s *Server) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	sm, err := parseLine(line)
+	if err != nil {
+		log.Printf("WARN statsd skipping line: %s", err.Error())
+		return
+	}
+	s.agg.add(sm)
+}
+
+func (s *Server) serveUDP(wg *sync.WaitGroup) {
+	defer wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if !isClosed(err) {
+				log.Printf("ERROR statsd UDP read failed: %s", err.Error())
+			}
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			s.handleLine(line)
+		}
+	}
+}
+
+func (s *Server) serveTCP(wg *sync.WaitGroup) {
+	defer wg.Done()
+	var conns sync.WaitGroup
+	defer conns.Wait()
+	for {
+		c, err := s.tcp.Accept()
+		if err != nil {
+			if !isClosed(err) {
+				log.Printf("ERROR statsd TCP accept failed: %s", err.Error())
+			}
+			return
+		}
+		s.connsMx.Lock()
+		s.conns[c] = struct{}{}
+		s.connsMx.Unlock()
+		conns.Add(1)
+		go s.serveConn(c, &conns)
+	}
+}
+
+func (s *Server) serveConn(c net.Conn, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer func() {
+		s.connsMx.Lock()
+		delete(s.conns, c)
+		s.connsMx.Unlock()
+		c.Close()
+	}()
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		s.handleLine(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil && !isClosed(err) {
+		log.Printf("WARN statsd TCP connection %s failed: %s", c.RemoteAddr(), err.Error())
+	}
+}
+
+func isClosed(err error) bool {
+	return errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,192 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+func TestParseLine(t *testing.T) {
+	tt := []struct {
+		name    string
+		line    string
+		want    sample
+		wantErr error
+	}{
+		{name: "counter", line: "requests:3|c",
+			want: sample{name: "requests", mtype: "c", value: 3, rate: 1}},
+		{name: "counter with rate and tags", line: "requests:1|c|@0.1|#env:prod",
+			want: sample{name: "requests", mtype: "c", value: 1, rate: 0.1}},
+		{name: "gauge", line: "temperature:21.5|g",
+			want: sample{name: "temperature", mtype: "g", value: 21.5, rate: 1}},
+		{name: "relative gauge", line: "queue:-4|g",
+			want: sample{name: "queue", mtype: "g", value: -4, relative: true, rate: 1}},
+		{name: "timer", line: "db.query:320|ms",
+			want: sample{name: "db.query", mtype: "ms", value: 320, rate: 1}},
+		{name: "no name", line: ":1|c", wantErr: structs.ErrMetricNullAttr},
+		{name: "no type", line: "requests:1", wantErr: structs.ErrMetricNullAttr},
+		{name: "unsupported type", line: "users:12|s", wantErr: structs.ErrMetricBadType},
+		{name: "bad value", line: "requests:abc|c", wantErr: structs.ErrMetricBadAttrValue},
+		{name: "bad rate", line: "requests:1|c|@2", wantErr: structs.ErrMetricBadAttrValue},
+		{name: "NaN rate", line: "requests:1|c|@NaN", wantErr: structs.ErrMetricBadAttrValue},
+		{name: "NaN value", line: "temperature:NaN|g", wantErr: structs.ErrMetricBadAttrValue},
+		{name: "infinite value", line: "requests:+Inf|c", wantErr: structs.ErrMetricBadAttrValue},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			have, err := parseLine(tc.line)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tc.wantErr)
+			}
+			if have != tc.want {
+				t.Errorf("sample mismatch: have %+v, want %+v", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	store := structs.NewMemoryStorage()
+	stored := float64(10)
+	err := store.UpdateMetric(context.Background(), structs.Metric{ID: "queue", MType: "gauge", Value: &stored})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newAggregator()
+	for _, line := range []string{
+		"requests:1|c", "requests:1|c|@0.5",
+		"queue:+5|g", "queue:-2|g",
+		"temperature:+3|g", "temperature:20|g", "temperature:+1|g",
+		"new:-1|g",
+		"db:10|ms", "db:30|ms|@0.5",
+	} {
+		s, err := parseLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.add(s)
+	}
+	err = store.UpdateMetrics(context.Background(), a.metrics(context.Background(), store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counters := map[string]int64{"requests": 3, "db.count": 3}
+	gauges := map[string]float64{"queue": 13, "temperature": 21, "new": -1,
+		"db.mean": 20, "db.min": 10, "db.max": 30}
+	for id, want := range counters {
+		m, err := store.GetMetric(context.Background(), structs.Metric{ID: id, MType: "counter"})
+		if err != nil {
+			t.Fatalf("counter %s: %s", id, err.Error())
+		}
+		if *m.Delta != want {
+			t.Errorf("counter %s mismatch: have %d, want %d", id, *m.Delta, want)
+		}
+	}
+	for id, want := range gauges {
+		m, err := store.GetMetric(context.Background(), structs.Metric{ID: id, MType: "gauge"})
+		if err != nil {
+			t.Fatalf("gauge %s: %s", id, err.Error())
+		}
+		if *m.Value != want {
+			t.Errorf("gauge %s mismatch: have %f, want %f", id, *m.Value, want)
+		}
+	}
+
+	if metrics := a.metrics(context.Background(), store); len(metrics) != 0 {
+		t.Errorf("aggregator must be empty after flush, have %d metrics", len(metrics))
+	}
+}
+
+func TestAggregatorRemainder(t *testing.T) {
+	store := structs.NewMemoryStorage()
+	a := newAggregator()
+	// every flush gets 1/0.3 = 3.33 requests and 1.5 halves, rounding each flush would give 30 and 20
+	for i := 0; i < 10; i++ {
+		for _, line := range []string{"requests:1|c|@0.3", "half:1.5|c", "huge:1e308|c|@1e-10"} {
+			s, err := parseLine(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.add(s)
+		}
+		err := store.UpdateMetrics(context.Background(), a.metrics(context.Background(), store))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, want := range map[string]int64{"requests": 33, "half": 15} {
+		m, err := store.GetMetric(context.Background(), structs.Metric{ID: id, MType: "counter"})
+		if err != nil {
+			t.Fatalf("counter %s: %s", id, err.Error())
+		}
+		if *m.Delta != want {
+			t.Errorf("counter %s mismatch: have %d, want %d", id, *m.Delta, want)
+		}
+	}
+	_, err := store.GetMetric(context.Background(), structs.Metric{ID: "huge", MType: "counter"})
+	if !errors.Is(err, structs.ErrMetricNotFound) {
+		t.Errorf("non-finite counter must be dropped, have error %v", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	store := structs.NewMemoryStorage()
+	s, err := Listen("127.0.0.1:0", time.Hour, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go s.Start(ctx, &wg)
+
+	for _, network := range []string{"udp", "tcp"} {
+		conn, err := net.Dial(network, s.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fmt.Fprint(conn, "hits:2|c\nload:0.5|g\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	// waiting for metrics to be received, remaining metrics are flushed on shutdown
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.agg.mx.Lock()
+		hits := s.agg.counters["hits"]
+		s.agg.mx.Unlock()
+		if hits == 4 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	m, err := store.GetMetric(context.Background(), structs.Metric{ID: "hits", MType: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *m.Delta != 4 {
+		t.Errorf("hits mismatch: have %d, want 4", *m.Delta)
+	}
+	m, err = store.GetMetric(context.Background(), structs.Metric{ID: "load", MType: "gauge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *m.Value != 0.5 {
+		t.Errorf("load mismatch: have %f, want 0.5", *m.Value)
+	}
+}
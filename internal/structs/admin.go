@@ -0,0 +1,77 @@
+package structs
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/hash"
+)
+
+// Administrative actions changing stored series
+const (
+	AdminDelete = "delete"
+	AdminReset  = "reset"
+)
+
+// AdminRequestWindow is how long signed administrative request is accepted after (or before) its timestamp,
+// so a captured request can not be replayed later
+const AdminRequestWindow = 5 * time.Minute
+
+// AdminRequest deletes series of the metric or resets series of the counter.
+// Match holds label matchers selecting the series (all series of the metric if empty),
+// Timestamp is unix time the request was signed at
+type AdminRequest struct {
+	Action    string
+	ID        string
+	MType     string
+	Match     []string
+	Timestamp int64
+	Hash      string
+}
+
+// CalculateHash signs action, id, type, matchers in the order they were sent and timestamp
+func (a AdminRequest) CalculateHash(key string) string {
+	return hash.Sign(key, fmt.Sprintf("%s:%s:%s:%s:%d", a.Action, a.ID, a.MType, strings.Join(a.Match, ";"),
+		a.Timestamp))
+}
+
+func (a *AdminRequest) SetHash(key string) {
+	a.Hash = a.CalculateHash(key)
+}
+
+// Authorize rejects the request unless administrative requests are restricted by key or trusted subnet
+// (the subnet itself is checked by the caller). Requests must be signed when the key is set
+// and their timestamp must be within AdminRequestWindow from now
+func (a AdminRequest) Authorize(key string, trustedSubnet net.IPNet) error {
+	if key == "" && trustedSubnet.String() == "0.0.0.0/0" {
+		return fmt.Errorf("%w: %s requests are disabled, neither key nor trusted subnet is configured",
+			ErrAccessDenied, a.Action)
+	}
+	if key == "" {
+		return nil
+	}
+	if a.CalculateHash(key) != a.Hash {
+		return fmt.Errorf("%w: invalid hash value", ErrAccessDenied)
+	}
+	age := time.Since(time.Unix(a.Timestamp, 0))
+	if age > AdminRequestWindow || age < -AdminRequestWindow {
+		return fmt.Errorf("%w: request timestamp %d is not within %s from now",
+			ErrAccessDenied, a.Timestamp, AdminRequestWindow)
+	}
+	return nil
+}
+
+// Matchers parses Match
+func (a AdminRequest) Matchers() ([]LabelMatcher, error) {
+	var matchers []LabelMatcher
+	for _, raw := range a.Match {
+		m, err := ParseLabelMatcher(raw)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
@@ -0,0 +1,67 @@
+package structs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/hash"
+)
+
+// Labels attached by the agent to every reported metric
+const (
+	LabelHost     = "host"
+	LabelInstance = "instance"
+)
+
+// Agent describes an agent which has reported metrics to the server
+type Agent struct {
+	Instance string    `json:"instance"`
+	Host     string    `json:"host,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+	// Silent is set when agent has not reported metrics for the server agent timeout
+	Silent bool `json:"silent"`
+}
+
+func (a Agent) AsText() string {
+	state := "active"
+	if a.Silent {
+		state = "silent"
+	}
+	return fmt.Sprintf("%s host:%s last_seen:%s %s", a.Instance, a.Host, a.LastSeen.Format(time.RFC3339Nano), state)
+}
+
+// AgentLister is implemented by storages which keep track of reporting agents
+type AgentLister interface {
+	Agents(timeout time.Duration) []Agent
+}
+
+// AgentList is a response for agents listing
+type AgentList struct {
+	Agents []Agent `json:"agents"`
+	Hash   string  `json:"hash,omitempty"`
+}
+
+func (l *AgentList) lines() []string {
+	lines := make([]string, 0, len(l.Agents))
+	for _, a := range l.Agents {
+		lines = append(lines, a.AsText())
+	}
+	return lines
+}
+
+func (l *AgentList) CalculateHash(key string) string {
+	return hash.Sign(key, strings.Join(l.lines(), ";"))
+}
+
+func (l *AgentList) SetHash(key string) {
+	l.Hash = l.CalculateHash(key)
+}
+
+func (l *AgentList) AsText() string {
+	str := strings.Join(l.lines(), "\n")
+	if l.Hash != "" {
+		str += fmt.Sprintf("\nhash:%s", l.Hash)
+	}
+	return str
+}
@@ -6,3 +6,9 @@ var ErrMetricNotFound = errors.New("metric not found")
 var ErrMetricBadType = errors.New("metric has unsupported type")
 var ErrMetricNullAttr = errors.New("metric has not set attribute")
 var ErrMetricBadAttrValue = errors.New("metric has bad attribute value")
+var ErrHistoryDisabled = errors.New("metric history is not enabled")
+var ErrBadResolution = errors.New("rollup resolution is not supported")
+var ErrStorageUnavailable = errors.New("storage is unavailable")
+var ErrMetricAmbiguous = errors.New("label matchers select several series of the metric")
+var ErrAgentsNotTracked = errors.New("agents are not tracked by the storage")
+var ErrAccessDenied = errors.New("access denied")
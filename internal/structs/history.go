@@ -0,0 +1,157 @@
+package structs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zklevsha/go-musthave-devops/internal/hash"
+)
+
+// Sample is a timestamped metric value.
+// For counters Delta holds the accumulated value after the update was applied
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Delta     *int64    `json:"delta,omitempty"`
+	Value     *float64  `json:"value,omitempty"`
+}
+
+// Float returns sample value as float64 (used for rollups)
+func (s Sample) Float() float64 {
+	if s.Delta != nil {
+		return float64(*s.Delta)
+	}
+	return *s.Value
+}
+
+func (s Sample) AsText() string {
+	if s.Delta != nil {
+		return fmt.Sprintf("%s %d", s.Timestamp.Format(time.RFC3339Nano), *s.Delta)
+	}
+	return fmt.Sprintf("%s %.3f", s.Timestamp.Format(time.RFC3339Nano), *s.Value)
+}
+
+// Rollup aggregates samples of one metric within a bucket of fixed resolution.
+// Timestamp is the start of the bucket
+type Rollup struct {
+	Timestamp time.Time `json:"timestamp"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Avg       float64   `json:"avg"`
+	Count     int64     `json:"count"`
+}
+
+func (r Rollup) AsText() string {
+	return fmt.Sprintf("%s min:%.3f max:%.3f avg:%.3f count:%d",
+		r.Timestamp.Format(time.RFC3339Nano), r.Min, r.Max, r.Avg, r.Count)
+}
+
+// Merge combines two rollups of the same bucket
+func (r Rollup) Merge(o Rollup) Rollup {
+	if o.Count == 0 {
+		return r
+	}
+	if r.Count == 0 {
+		return o
+	}
+	res := Rollup{Timestamp: r.Timestamp, Min: r.Min, Max: r.Max, Count: r.Count + o.Count}
+	if o.Min < res.Min {
+		res.Min = o.Min
+	}
+	if o.Max > res.Max {
+		res.Max = o.Max
+	}
+	res.Avg = (r.Avg*float64(r.Count) + o.Avg*float64(o.Count)) / float64(res.Count)
+	return res
+}
+
+// Supported rollup resolutions
+const RollupMinute = time.Minute
+const RollupHour = time.Hour
+
+// RetentionPolicy describes how long metric history is kept:
+// raw samples are kept for Raw, then rolled up per minute,
+// minute rollups are kept for Rollup, then rolled up per hour,
+// hour rollups are kept for RollupHour, then deleted
+type RetentionPolicy struct {
+	Raw        time.Duration
+	Rollup     time.Duration
+	RollupHour time.Duration
+}
+
+// RollupSamples aggregates samples into buckets of the given resolution
+func RollupSamples(samples []Sample, resolution time.Duration) []Rollup {
+	var rollups []Rollup
+	for _, sample := range samples {
+		v := sample.Float()
+		r := Rollup{Timestamp: sample.Timestamp.Truncate(resolution),
+			Min: v, Max: v, Avg: v, Count: 1}
+		rollups = MergeRollups(rollups, []Rollup{r})
+	}
+	return rollups
+}
+
+// RollupRollups aggregates rollups into buckets of the (bigger) resolution
+func RollupRollups(rollups []Rollup, resolution time.Duration) []Rollup {
+	var res []Rollup
+	for _, r := range rollups {
+		r.Timestamp = r.Timestamp.Truncate(resolution)
+		res = MergeRollups(res, []Rollup{r})
+	}
+	return res
+}
+
+// MergeRollups adds src rollups to dst merging rollups of the same bucket.
+// dst stays sorted by timestamp
+func MergeRollups(dst []Rollup, src []Rollup) []Rollup {
+	for _, r := range src {
+		i := sort.Search(len(dst), func(i int) bool { return !dst[i].Timestamp.Before(r.Timestamp) })
+		if i < len(dst) && dst[i].Timestamp.Equal(r.Timestamp) {
+			dst[i] = dst[i].Merge(r)
+			continue
+		}
+		dst = append(dst, Rollup{})
+		copy(dst[i+1:], dst[i:])
+		dst[i] = r
+	}
+	return dst
+}
+
+// MetricHistory is a response for history range queries
+type MetricHistory struct {
+	ID         string   `json:"id"`
+	MType      string   `json:"type"`
+	Labels     Labels   `json:"labels,omitempty"`
+	Resolution string   `json:"resolution,omitempty"`
+	Samples    []Sample `json:"samples,omitempty"`
+	Rollups    []Rollup `json:"rollups,omitempty"`
+	Hash       string   `json:"hash,omitempty"`
+}
+
+func (h *MetricHistory) lines() []string {
+	var lines []string
+	for _, s := range h.Samples {
+		lines = append(lines, s.AsText())
+	}
+	for _, r := range h.Rollups {
+		lines = append(lines, r.AsText())
+	}
+	return lines
+}
+
+func (h *MetricHistory) CalculateHash(key string) string {
+	return hash.Sign(key, fmt.Sprintf("%s%s:%s:%s", h.ID, h.Labels.String(), h.MType, strings.Join(h.lines(), ";")))
+}
+
+func (h *MetricHistory) SetHash(key string) {
+	h.Hash = h.CalculateHash(key)
+}
+
+func (h *MetricHistory) AsText() string {
+	str := strings.Join(h.lines(), "\n")
+	if h.Hash != "" {
+		str += fmt.Sprintf("\nhash:%s", h.Hash)
+	}
+	return str
+}
@@ -1,17 +1,31 @@
 package structs
 
+import (
+	"context"
+	"time"
+)
+
 type ServerResponse interface {
 	AsText() string
 	SetHash(key string)
 }
 
+// Storage keeps metrics. Calls are bounded by ctx: storages backed by a database
+// cancel queries when the request is cancelled
 type Storage interface {
-	GetMetric(metric Metric) (Metric, error)
-	GetMetrics() ([]Metric, error)
-	UpdateMetric(metric Metric) error
-	UpdateMetrics(metrics []Metric) error
-	ResetCounter(ID string) error
-	Avaliable() error
+	GetMetric(ctx context.Context, metric Metric) (Metric, error)
+	GetMetrics(ctx context.Context) ([]Metric, error)
+	ListMetrics(ctx context.Context, q ListQuery) (MetricList, error)
+	UpdateMetric(ctx context.Context, metric Metric) error
+	UpdateMetrics(ctx context.Context, metrics []Metric) error
+	// ResetCounter and DeleteMetric change the series selected by matchers, all series of the metric if none
+	ResetCounter(ctx context.Context, ID string, matchers []LabelMatcher) error
+	DeleteMetric(ctx context.Context, metric Metric, matchers []LabelMatcher) error
+	GetMetricHistory(ctx context.Context, metric Metric, from time.Time, to time.Time) ([]Sample, error)
+	GetMetricRollups(ctx context.Context, metric Metric, resolution time.Duration,
+		from time.Time, to time.Time) ([]Rollup, error)
+	CompactHistory(ctx context.Context, policy RetentionPolicy, now time.Time) error
+	Avaliable(ctx context.Context) error
 	Close()
 	Init() error
 }
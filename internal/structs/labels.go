@@ -0,0 +1,165 @@
+package structs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Labels distinguish series of the same metric (e.g. reported by different hosts)
+type Labels map[string]string
+
+// String returns canonical form of labels: {name1="value1",name2="value2"} sorted by name.
+// Empty labels are rendered as empty string
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ","))
+}
+
+// Equal reports whether labels have the same names and values
+func (l Labels) Equal(o Labels) bool {
+	if len(l) != len(o) {
+		return false
+	}
+	for k, v := range l {
+		if ov, ok := o[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SeriesKey identifies series of the metric in storage maps
+func SeriesKey(id string, labels Labels) string {
+	return id + "\x00" + labels.String()
+}
+
+// Label matcher operators (same as in Prometheus selectors)
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+)
+
+// LabelMatcher selects series by label value. Missing label is matched as empty value
+type LabelMatcher struct {
+	Name  string
+	Op    string
+	Value string
+	re    *regexp.Regexp
+}
+
+// ParseLabelMatcher parses matcher in name=value, name!=value, name=~regexp or name!~regexp form.
+// Regular expressions are anchored
+func ParseLabelMatcher(s string) (LabelMatcher, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return LabelMatcher{}, fmt.Errorf("%w: bad label matcher '%s'", ErrMetricBadAttrValue, s)
+	}
+	m := LabelMatcher{Name: s[:i]}
+	rest := s[i:]
+	for _, op := range []string{MatchNotEqual, MatchRegexp, MatchNotRegexp, MatchEqual} {
+		if strings.HasPrefix(rest, op) {
+			m.Op, m.Value = op, rest[len(op):]
+			break
+		}
+	}
+	if m.Op == "" {
+		return LabelMatcher{}, fmt.Errorf("%w: bad label matcher '%s'", ErrMetricBadAttrValue, s)
+	}
+	if m.Op == MatchRegexp || m.Op == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return LabelMatcher{}, fmt.Errorf("%w: bad label matcher regexp '%s': %s",
+				ErrMetricBadAttrValue, m.Value, err.Error())
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+func (m LabelMatcher) Matches(labels Labels) bool {
+	v := labels[m.Name]
+	switch m.Op {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+func (m LabelMatcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Op, m.Value)
+}
+
+// Source returns matcher in the form accepted by ParseLabelMatcher
+func (m LabelMatcher) Source() string {
+	return m.Name + m.Op + m.Value
+}
+
+// MatchLabels reports whether labels satisfy all matchers
+func MatchLabels(labels Labels, matchers []LabelMatcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// FindSeries returns the series of metric m selected by matchers.
+// The series with labels equal to equality matchers is preferred, otherwise matchers must select
+// exactly one series of the metric
+func FindSeries(ctx context.Context, store Storage, m Metric, matchers []LabelMatcher) (Metric, error) {
+	var exact Labels
+	for _, lm := range matchers {
+		if lm.Op == MatchEqual && lm.Value != "" {
+			if exact == nil {
+				exact = Labels{}
+			}
+			exact[lm.Name] = lm.Value
+		}
+	}
+	found, err := store.GetMetric(ctx, Metric{ID: m.ID, MType: m.MType, Labels: exact})
+	switch {
+	case err == nil && MatchLabels(found.Labels, matchers):
+		return found, nil
+	case err != nil && !errors.Is(err, ErrMetricNotFound):
+		return Metric{}, err
+	}
+
+	metrics, err := store.GetMetrics(ctx)
+	if err != nil {
+		return Metric{}, err
+	}
+	var selected []Metric
+	for _, s := range metrics {
+		if s.ID == m.ID && s.MType == m.MType && MatchLabels(s.Labels, matchers) {
+			selected = append(selected, s)
+		}
+	}
+	switch len(selected) {
+	case 0:
+		return Metric{}, ErrMetricNotFound
+	case 1:
+		return selected[0], nil
+	default:
+		return Metric{}, fmt.Errorf("%w: %s has %d matching series", ErrMetricAmbiguous, m.ID, len(selected))
+	}
+}
@@ -0,0 +1,106 @@
+package structs
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLabelsString(t *testing.T) {
+	tt := []struct {
+		name   string
+		labels Labels
+		want   string
+	}{
+		{name: "no labels", labels: nil, want: ""},
+		{name: "sorted by name", labels: Labels{"host": "web1", "env": "prod"}, want: `{env="prod",host="web1"}`},
+		{name: "quoted value", labels: Labels{"path": `C:\tmp "x"`}, want: `{path="C:\\tmp \"x\""}`},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if have := tc.labels.String(); have != tc.want {
+				t.Errorf("labels mismatch: have %s, want %s", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseLabelMatcher(t *testing.T) {
+	labels := Labels{"host": "web1", "env": "prod"}
+	tt := []struct {
+		matcher string
+		want    bool
+		wantErr error
+	}{
+		{matcher: "host=web1", want: true},
+		{matcher: "host=web2", want: false},
+		{matcher: "host!=web2", want: true},
+		{matcher: "env=~prod|stage", want: true},
+		{matcher: "env=~pro", want: false},
+		{matcher: "env!~dev.*", want: true},
+		{matcher: "dc=", want: true},
+		{matcher: "dc!=", want: false},
+		{matcher: "host", wantErr: ErrMetricBadAttrValue},
+		{matcher: "=web1", wantErr: ErrMetricBadAttrValue},
+		{matcher: "host!web1", wantErr: ErrMetricBadAttrValue},
+		{matcher: "host=~(", wantErr: ErrMetricBadAttrValue},
+	}
+	for _, tc := range tt {
+		t.Run(tc.matcher, func(t *testing.T) {
+			m, err := ParseLabelMatcher(tc.matcher)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if have := m.Matches(labels); have != tc.want {
+				t.Errorf("match mismatch: have %t, want %t", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestFindSeries(t *testing.T) {
+	store := NewMemoryStorage()
+	for _, labels := range []Labels{nil, {"host": "web1"}, {"host": "web1", "env": "prod"}, {"host": "web2"}} {
+		v := float64(len(labels))
+		err := store.UpdateMetric(context.Background(), Metric{ID: "load", MType: "gauge", Value: &v, Labels: labels})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tt := []struct {
+		name       string
+		matchers   []string
+		wantLabels Labels
+		wantErr    error
+	}{
+		{name: "no matchers", wantLabels: nil},
+		{name: "exact series is preferred", matchers: []string{"host=web1"}, wantLabels: Labels{"host": "web1"}},
+		{name: "single matching series", matchers: []string{"env=prod"},
+			wantLabels: Labels{"host": "web1", "env": "prod"}},
+		{name: "regexp", matchers: []string{"host=~.*2"}, wantLabels: Labels{"host": "web2"}},
+		{name: "several series", matchers: []string{"host=~web.*"}, wantErr: ErrMetricAmbiguous},
+		{name: "nothing matches", matchers: []string{"host=web3"}, wantErr: ErrMetricNotFound},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var matchers []LabelMatcher
+			for _, raw := range tc.matchers {
+				m, err := ParseLabelMatcher(raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				matchers = append(matchers, m)
+			}
+			m, err := FindSeries(context.Background(), store, Metric{ID: "load", MType: "gauge"}, matchers)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && !m.Labels.Equal(tc.wantLabels) {
+				t.Errorf("labels mismatch: have %s, want %s", m.Labels, tc.wantLabels)
+			}
+		})
+	}
+}
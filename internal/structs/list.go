@@ -0,0 +1,163 @@
+package structs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zklevsha/go-musthave-devops/internal/hash"
+)
+
+// Metrics listing sort keys
+const (
+	SortByID   = "id"
+	SortByType = "type"
+)
+
+// Metrics listing page size limits
+const (
+	ListLimitDefault = 100
+	ListLimitMax     = 1000
+)
+
+// ListQuery selects metrics page for listing
+type ListQuery struct {
+	// MType filters metrics by type (all types if empty)
+	MType string
+	// Prefix filters metrics by ID prefix
+	Prefix string
+	// Regexp filters metrics by ID (unanchored match)
+	Regexp *regexp.Regexp
+	// SortBy is SortByID (then type and labels) or SortByType (then ID and labels)
+	SortBy string
+	Desc   bool
+	// Cursor is NextCursor of the previous page (first page if empty)
+	Cursor string
+	Limit  int
+}
+
+// ListCursor is the position of the last metric of the page
+type ListCursor struct {
+	ID     string `json:"i"`
+	MType  string `json:"t"`
+	Labels string `json:"l,omitempty"`
+}
+
+// Encode returns opaque cursor representation
+func (c ListCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeListCursor parses cursor returned by ListCursor.Encode
+func DecodeListCursor(s string) (ListCursor, error) {
+	var c ListCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil {
+		return ListCursor{}, fmt.Errorf("%w: bad cursor %s", ErrMetricBadAttrValue, s)
+	}
+	return c, nil
+}
+
+// sortKey returns metric fields in query sort order
+func (q ListQuery) sortKey(id, mtype, labels string) [3]string {
+	if q.SortBy == SortByType {
+		return [3]string{mtype, id, labels}
+	}
+	return [3]string{id, mtype, labels}
+}
+
+// MetricList is a page of metrics listing
+type MetricList struct {
+	Metrics    []Metric `json:"metrics"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	Hash       string   `json:"hash,omitempty"`
+}
+
+func (l *MetricList) lines() []string {
+	lines := make([]string, 0, len(l.Metrics))
+	for _, m := range l.Metrics {
+		lines = append(lines, fmt.Sprintf("%s%s:%s:%s", m.ID, m.Labels.String(), m.MType, m.AsText()))
+	}
+	return lines
+}
+
+func (l *MetricList) CalculateHash(key string) string {
+	return hash.Sign(key, strings.Join(l.lines(), ";")+":"+l.NextCursor)
+}
+
+func (l *MetricList) SetHash(key string) {
+	l.Hash = l.CalculateHash(key)
+}
+
+func (l *MetricList) AsText() string {
+	str := strings.Join(l.lines(), "\n")
+	if l.NextCursor != "" {
+		str += fmt.Sprintf("\nnext_cursor:%s", l.NextCursor)
+	}
+	if l.Hash != "" {
+		str += fmt.Sprintf("\nhash:%s", l.Hash)
+	}
+	return str
+}
+
+// ListMetrics filters, sorts and paginates metrics in memory (used by storages without query support)
+func ListMetrics(metrics []Metric, q ListQuery) (MetricList, error) {
+	var cursor *[3]string
+	if q.Cursor != "" {
+		c, err := DecodeListCursor(q.Cursor)
+		if err != nil {
+			return MetricList{}, err
+		}
+		key := q.sortKey(c.ID, c.MType, c.Labels)
+		cursor = &key
+	}
+	less := func(a, b [3]string) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return a[i] < b[i] != q.Desc
+			}
+		}
+		return false
+	}
+
+	type keyed struct {
+		key    [3]string
+		metric Metric
+	}
+	var selected []keyed
+	for _, m := range metrics {
+		if q.MType != "" && m.MType != q.MType ||
+			!strings.HasPrefix(m.ID, q.Prefix) ||
+			q.Regexp != nil && !q.Regexp.MatchString(m.ID) {
+			continue
+		}
+		key := q.sortKey(m.ID, m.MType, m.Labels.String())
+		if cursor != nil && !less(*cursor, key) {
+			continue
+		}
+		selected = append(selected, keyed{key: key, metric: m})
+	}
+	sort.Slice(selected, func(i, j int) bool { return less(selected[i].key, selected[j].key) })
+
+	list := MetricList{Metrics: []Metric{}}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = ListLimitDefault
+	}
+	for i, k := range selected {
+		if i == limit {
+			last := list.Metrics[len(list.Metrics)-1]
+			list.NextCursor = ListCursor{ID: last.ID, MType: last.MType, Labels: last.Labels.String()}.Encode()
+			break
+		}
+		list.Metrics = append(list.Metrics, k.metric)
+	}
+	return list, nil
+}
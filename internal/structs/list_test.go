@@ -0,0 +1,61 @@
+package structs
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestListMetrics(t *testing.T) {
+	v := 1.0
+	d := int64(1)
+	metrics := []Metric{
+		{ID: "b", MType: "gauge", Value: &v},
+		{ID: "a", MType: "counter", Delta: &d},
+		{ID: "a", MType: "gauge", Value: &v, Labels: Labels{"host": "web2"}},
+		{ID: "a", MType: "gauge", Value: &v, Labels: Labels{"host": "web1"}},
+		{ID: "c", MType: "counter", Delta: &d},
+	}
+	key := func(m Metric) string { return m.ID + m.Labels.String() + ":" + m.MType }
+
+	tt := []struct {
+		name  string
+		query ListQuery
+		pages []string
+	}{
+		{name: "sort by id", query: ListQuery{Limit: 10},
+			pages: []string{`a:counter,a{host="web1"}:gauge,a{host="web2"}:gauge,b:gauge,c:counter`}},
+		{name: "sort by type", query: ListQuery{SortBy: SortByType, Limit: 10},
+			pages: []string{`a:counter,c:counter,a{host="web1"}:gauge,a{host="web2"}:gauge,b:gauge`}},
+		{name: "descending", query: ListQuery{Desc: true, Limit: 10},
+			pages: []string{`c:counter,b:gauge,a{host="web2"}:gauge,a{host="web1"}:gauge,a:counter`}},
+		{name: "filters", query: ListQuery{MType: "gauge", Regexp: regexp.MustCompile("^[ab]$"), Prefix: "a", Limit: 10},
+			pages: []string{`a{host="web1"}:gauge,a{host="web2"}:gauge`}},
+		{name: "pagination", query: ListQuery{Limit: 2},
+			pages: []string{`a:counter,a{host="web1"}:gauge`, `a{host="web2"}:gauge,b:gauge`, `c:counter`}},
+		{name: "descending pagination", query: ListQuery{Desc: true, SortBy: SortByType, Limit: 3},
+			pages: []string{`b:gauge,a{host="web2"}:gauge,a{host="web1"}:gauge`, `c:counter,a:counter`}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			q := tc.query
+			for i, want := range tc.pages {
+				list, err := ListMetrics(metrics, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var keys []string
+				for _, m := range list.Metrics {
+					keys = append(keys, key(m))
+				}
+				if have := strings.Join(keys, ","); have != want {
+					t.Errorf("page %d mismatch: have %s, want %s", i, have, want)
+				}
+				if last := i == len(tc.pages)-1; last != (list.NextCursor == "") {
+					t.Fatalf("page %d: unexpected next_cursor %q", i, list.NextCursor)
+				}
+				q.Cursor = list.NextCursor
+			}
+		})
+	}
+}
@@ -7,19 +7,22 @@ import (
 )
 
 type Metric struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
-	Hash  string   `json:"hash,omitempty"`  // hmac метрики
+	ID     string   `json:"id"`               // имя метрики
+	MType  string   `json:"type"`             // параметр, принимающий значение gauge или counter
+	Delta  *int64   `json:"delta,omitempty"`  // значение метрики в случае передачи counter
+	Value  *float64 `json:"value,omitempty"`  // значение метрики в случае передачи gauge
+	Hash   string   `json:"hash,omitempty"`   // hmac метрики
+	Labels Labels   `json:"labels,omitempty"` // метки, различающие серии одной метрики
 }
 
+// CalculateHash signs id, labels, type and value.
+// Metrics without labels are signed as before labels were introduced
 func (m Metric) CalculateHash(key string) string {
 	var str string
 	if m.MType == "gauge" {
-		str = fmt.Sprintf("%s:gauge:%f", m.ID, *m.Value)
+		str = fmt.Sprintf("%s%s:gauge:%f", m.ID, m.Labels.String(), *m.Value)
 	} else {
-		str = fmt.Sprintf("%s:counter:%d", m.ID, *m.Delta)
+		str = fmt.Sprintf("%s%s:counter:%d", m.ID, m.Labels.String(), *m.Delta)
 	}
 	return hash.Sign(key, str)
 }
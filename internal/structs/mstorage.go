@@ -1,39 +1,91 @@
 package structs
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"sort"
 	"sync"
+	"time"
 )
 
 type MemoryStorage struct {
-	counters   map[string]int64
-	gauges     map[string]float64
-	countersMx sync.RWMutex
-	gaugesMx   sync.RWMutex
+	counters    map[string]*counterSeries
+	gauges      map[string]*gaugeSeries
+	history     map[string][]Sample
+	rollups     map[time.Duration]map[string][]Rollup
+	countersMx  sync.RWMutex
+	gaugesMx    sync.RWMutex
+	historyMx   sync.RWMutex
+	keepHistory bool
 }
 
-func (s *MemoryStorage) GetMetric(m Metric) (Metric, error) {
+// counterSeries and gaugeSeries are values of the series keyed by SeriesKey(id, labels)
+type counterSeries struct {
+	id     string
+	labels Labels
+	value  int64
+}
+
+type gaugeSeries struct {
+	id     string
+	labels Labels
+	value  float64
+}
+
+func historyKey(mtype string, id string, labels Labels) string {
+	return fmt.Sprintf("%s:%s", mtype, SeriesKey(id, labels))
+}
+
+func (s *MemoryStorage) addSample(m Metric, sample Sample) {
+	if !s.keepHistory {
+		return
+	}
+	key := historyKey(m.MType, m.ID, m.Labels)
+	s.historyMx.Lock()
+	// timestamp is taken under the lock to keep samples ordered
+	sample.Timestamp = time.Now()
+	s.history[key] = append(s.history[key], sample)
+	s.historyMx.Unlock()
+}
+
+// copyLabels returns labels copy, so stored series do not share the map with callers
+func copyLabels(labels Labels) Labels {
+	if len(labels) == 0 {
+		return nil
+	}
+	c := make(Labels, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
+
+func (s *MemoryStorage) GetMetric(ctx context.Context, m Metric) (Metric, error) {
+	key := SeriesKey(m.ID, m.Labels)
 	switch m.MType {
 	case "counter":
 		s.countersMx.RLock()
-		v, ok := s.counters[m.ID]
+		c, ok := s.counters[key]
 		s.countersMx.RUnlock()
 		if ok {
+			v := c.value
 			m.Delta = &v
 			return m, nil
 		} else {
-			log.Printf("WARN: counter metric %s was not found", m.ID)
+			log.Printf("WARN: counter metric %s%s was not found", m.ID, m.Labels.String())
 			return Metric{}, ErrMetricNotFound
 		}
 	case "gauge":
 		s.gaugesMx.RLock()
-		v, ok := s.gauges[m.ID]
+		g, ok := s.gauges[key]
 		s.gaugesMx.RUnlock()
 		if ok {
+			v := g.value
 			m.Value = &v
 			return m, nil
 		} else {
-			log.Printf("WARN: gauge metric %s does not exists", m.ID)
+			log.Printf("WARN: gauge metric %s%s does not exists", m.ID, m.Labels.String())
 			return Metric{}, ErrMetricNotFound
 		}
 	default:
@@ -42,34 +94,57 @@ func (s *MemoryStorage) GetMetric(m Metric) (Metric, error) {
 	}
 }
 
-func (s *MemoryStorage) GetMetrics() ([]Metric, error) {
+func (s *MemoryStorage) GetMetrics(ctx context.Context) ([]Metric, error) {
 	var metrics = []Metric{}
 	s.countersMx.RLock()
-	for k, v := range s.counters {
-		cDelta := v
-		metrics = append(metrics, Metric{ID: k, MType: "counter", Delta: &cDelta})
+	for _, c := range s.counters {
+		cDelta := c.value
+		metrics = append(metrics, Metric{ID: c.id, MType: "counter", Delta: &cDelta, Labels: copyLabels(c.labels)})
 	}
 	s.countersMx.RUnlock()
 
 	s.gaugesMx.RLock()
-	for k, v := range s.gauges {
-		gValue := v
-		metrics = append(metrics, Metric{ID: k, MType: "gauge", Value: &gValue})
+	for _, g := range s.gauges {
+		gValue := g.value
+		metrics = append(metrics, Metric{ID: g.id, MType: "gauge", Value: &gValue, Labels: copyLabels(g.labels)})
 	}
 	s.gaugesMx.RUnlock()
 	return metrics, nil
 }
 
-func (s *MemoryStorage) UpdateMetric(m Metric) error {
+func (s *MemoryStorage) ListMetrics(ctx context.Context, q ListQuery) (MetricList, error) {
+	metrics, err := s.GetMetrics(ctx)
+	if err != nil {
+		return MetricList{}, err
+	}
+	return ListMetrics(metrics, q)
+}
+
+func (s *MemoryStorage) UpdateMetric(ctx context.Context, m Metric) error {
+	key := SeriesKey(m.ID, m.Labels)
 	switch m.MType {
 	case "counter":
 		s.countersMx.Lock()
-		//S1036 - Unnecessary guard around map access
-		s.counters[m.ID] += *m.Delta
+		c, ok := s.counters[key]
+		if !ok {
+			c = &counterSeries{id: m.ID, labels: copyLabels(m.Labels)}
+			s.counters[key] = c
+		}
+		c.value += *m.Delta
+		delta := c.value
+		// sample is added under the series lock, so history of concurrent updates is ordered by value
+		s.addSample(m, Sample{Delta: &delta})
 		s.countersMx.Unlock()
 	case "gauge":
+		value := *m.Value
 		s.gaugesMx.Lock()
-		s.gauges[m.ID] = *m.Value
+		g, ok := s.gauges[key]
+		if !ok {
+			g = &gaugeSeries{id: m.ID, labels: copyLabels(m.Labels)}
+			s.gauges[key] = g
+		}
+		g.value = value
+		s.addSample(m, Sample{Value: &value})
 		s.gaugesMx.Unlock()
 	default:
 		log.Printf("ERROR: cant update %s. Metric has unknown type: %s", m.ID, m.MType)
@@ -78,9 +153,9 @@ func (s *MemoryStorage) UpdateMetric(m Metric) error {
 	return nil
 }
 
-func (s *MemoryStorage) UpdateMetrics(metrics []Metric) error {
+func (s *MemoryStorage) UpdateMetrics(ctx context.Context, metrics []Metric) error {
 	for _, m := range metrics {
-		err := s.UpdateMetric(m)
+		err := s.UpdateMetric(ctx, m)
 		if err != nil {
 			return err
 		}
@@ -88,19 +163,187 @@ func (s *MemoryStorage) UpdateMetrics(metrics []Metric) error {
 	return nil
 }
 
-func (s *MemoryStorage) ResetCounter(ID string) error {
-	if _, ok := s.counters[ID]; ok {
+// ResetCounter resets series of the counter selected by matchers
+func (s *MemoryStorage) ResetCounter(ctx context.Context, ID string, matchers []LabelMatcher) error {
+	s.countersMx.Lock()
+	defer s.countersMx.Unlock()
+	found := false
+	for _, c := range s.counters {
+		if c.id == ID && MatchLabels(c.labels, matchers) {
+			c.value = 0
+			found = true
+		}
+	}
+	if !found {
+		return ErrMetricNotFound
+	}
+	return nil
+}
+
+// DeleteMetric deletes series of the metric selected by matchers together with their history
+func (s *MemoryStorage) DeleteMetric(ctx context.Context, m Metric, matchers []LabelMatcher) error {
+	var deleted []string
+	switch m.MType {
+	case "counter":
 		s.countersMx.Lock()
-		s.counters[ID] = 0
+		for key, c := range s.counters {
+			if c.id == m.ID && MatchLabels(c.labels, matchers) {
+				delete(s.counters, key)
+				deleted = append(deleted, historyKey(m.MType, c.id, c.labels))
+			}
+		}
 		s.countersMx.Unlock()
-		return nil
-	} else {
+	case "gauge":
+		s.gaugesMx.Lock()
+		for key, g := range s.gauges {
+			if g.id == m.ID && MatchLabels(g.labels, matchers) {
+				delete(s.gauges, key)
+				deleted = append(deleted, historyKey(m.MType, g.id, g.labels))
+			}
+		}
+		s.gaugesMx.Unlock()
+	default:
+		return ErrMetricBadType
+	}
+	if len(deleted) == 0 {
 		return ErrMetricNotFound
 	}
 
+	if s.keepHistory {
+		s.historyMx.Lock()
+		for _, key := range deleted {
+			delete(s.history, key)
+			for _, byKey := range s.rollups {
+				delete(byKey, key)
+			}
+		}
+		s.historyMx.Unlock()
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetMetricHistory(ctx context.Context, m Metric,
+	from time.Time, to time.Time) ([]Sample, error) {
+	if !s.keepHistory {
+		return []Sample{}, ErrHistoryDisabled
+	}
+	if m.MType != "counter" && m.MType != "gauge" {
+		return []Sample{}, ErrMetricBadType
+	}
+	s.historyMx.RLock()
+	defer s.historyMx.RUnlock()
+	history, ok := s.history[historyKey(m.MType, m.ID, m.Labels)]
+	if !ok {
+		return []Sample{}, ErrMetricNotFound
+	}
+	var samples = []Sample{}
+	for _, sample := range history {
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+func (s *MemoryStorage) GetMetricRollups(ctx context.Context, m Metric, resolution time.Duration,
+	from time.Time, to time.Time) ([]Rollup, error) {
+	if !s.keepHistory {
+		return []Rollup{}, ErrHistoryDisabled
+	}
+	if m.MType != "counter" && m.MType != "gauge" {
+		return []Rollup{}, ErrMetricBadType
+	}
+	s.historyMx.RLock()
+	defer s.historyMx.RUnlock()
+	byKey, ok := s.rollups[resolution]
+	if !ok {
+		return []Rollup{}, ErrBadResolution
+	}
+	key := historyKey(m.MType, m.ID, m.Labels)
+	_, hasRaw := s.history[key]
+	all, ok := byKey[key]
+	if !ok && !hasRaw {
+		return []Rollup{}, ErrMetricNotFound
+	}
+	var rollups = []Rollup{}
+	for _, r := range all {
+		if r.Timestamp.Before(from) || r.Timestamp.After(to) {
+			continue
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, nil
+}
+
+func (s *MemoryStorage) CompactHistory(ctx context.Context, policy RetentionPolicy, now time.Time) error {
+	if !s.keepHistory {
+		return ErrHistoryDisabled
+	}
+	s.historyMx.Lock()
+	defer s.historyMx.Unlock()
+
+	minutes := s.rollups[RollupMinute]
+	hours := s.rollups[RollupHour]
+	rawCutoff := now.Add(-policy.Raw).Truncate(RollupMinute)
+	minuteCutoff := now.Add(-policy.Rollup).Truncate(RollupHour)
+	hourCutoff := now.Add(-policy.RollupHour)
+
+	// raw samples -> minute rollups
+	if policy.Raw > 0 {
+		for key, samples := range s.history {
+			i := sort.Search(len(samples), func(i int) bool { return !samples[i].Timestamp.Before(rawCutoff) })
+			if i == 0 {
+				continue
+			}
+			minutes[key] = MergeRollups(minutes[key], RollupSamples(samples[:i], RollupMinute))
+			s.history[key] = append([]Sample{}, samples[i:]...)
+		}
+	}
+
+	// minute rollups -> hour rollups
+	if policy.Rollup > 0 {
+		for key, rollups := range minutes {
+			i := sort.Search(len(rollups), func(i int) bool { return !rollups[i].Timestamp.Before(minuteCutoff) })
+			if i == 0 {
+				continue
+			}
+			hours[key] = MergeRollups(hours[key], RollupRollups(rollups[:i], RollupHour))
+			minutes[key] = append([]Rollup{}, rollups[i:]...)
+		}
+	}
+
+	// deleting old hour rollups
+	if policy.RollupHour > 0 {
+		for key, rollups := range hours {
+			i := sort.Search(len(rollups), func(i int) bool { return !rollups[i].Timestamp.Before(hourCutoff) })
+			hours[key] = append([]Rollup{}, rollups[i:]...)
+		}
+	}
+
+	// forgetting metrics without any history left, series may have only rollups after raw samples expired
+	for _, byKey := range []map[string][]Rollup{minutes, hours} {
+		for key := range byKey {
+			s.forgetEmpty(key)
+		}
+	}
+	for key := range s.history {
+		s.forgetEmpty(key)
+	}
+	return nil
+}
+
+// forgetEmpty deletes history of the series if neither samples nor rollups are left (historyMx must be held)
+func (s *MemoryStorage) forgetEmpty(key string) {
+	minutes, hours := s.rollups[RollupMinute], s.rollups[RollupHour]
+	if len(s.history[key]) == 0 && len(minutes[key]) == 0 && len(hours[key]) == 0 {
+		delete(s.history, key)
+		delete(minutes, key)
+		delete(hours, key)
+	}
 }
 
-func (s *MemoryStorage) Avaliable() error {
+func (s *MemoryStorage) Avaliable(ctx context.Context) error {
 	return nil
 
 }
@@ -114,9 +357,27 @@ func (s *MemoryStorage) Init() error {
 
 func NewMemoryStorage() Storage {
 	return &MemoryStorage{
-		counters:   map[string]int64{},
-		gauges:     map[string]float64{},
+		counters:   map[string]*counterSeries{},
+		gauges:     map[string]*gaugeSeries{},
 		gaugesMx:   sync.RWMutex{},
 		countersMx: sync.RWMutex{},
 	}
 }
+
+// NewMemoryStorageWithHistory returns MemoryStorage
+// which also keeps timestamped samples for every update
+func NewMemoryStorageWithHistory() Storage {
+	return &MemoryStorage{
+		counters: map[string]*counterSeries{},
+		gauges:   map[string]*gaugeSeries{},
+		history:  map[string][]Sample{},
+		rollups: map[time.Duration]map[string][]Rollup{
+			RollupMinute: {},
+			RollupHour:   {},
+		},
+		gaugesMx:    sync.RWMutex{},
+		countersMx:  sync.RWMutex{},
+		historyMx:   sync.RWMutex{},
+		keepHistory: true,
+	}
+}
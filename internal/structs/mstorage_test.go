@@ -0,0 +1,67 @@
+package structs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCounterHistoryOrder(t *testing.T) {
+	s := NewMemoryStorageWithHistory()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				d := int64(1)
+				err := s.UpdateMetric(context.Background(), Metric{ID: "requests", MType: "counter", Delta: &d})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	samples, err := s.GetMetricHistory(context.Background(), Metric{ID: "requests", MType: "counter"},
+		time.Time{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 4000 {
+		t.Fatalf("samples count mismatch: have %d, want 4000", len(samples))
+	}
+	for i := 1; i < len(samples); i++ {
+		if *samples[i].Delta < *samples[i-1].Delta || samples[i].Timestamp.Before(samples[i-1].Timestamp) {
+			t.Fatalf("history is out of order: %d at %s follows %d at %s", *samples[i].Delta, samples[i].Timestamp,
+				*samples[i-1].Delta, samples[i-1].Timestamp)
+		}
+	}
+}
+
+func TestCompactHistoryForgetsRollups(t *testing.T) {
+	s := NewMemoryStorageWithHistory().(*MemoryStorage)
+	now := time.Now()
+	// raw samples of both series have already expired
+	minuteOnly, hourOnly := historyKey("gauge", "a", nil), historyKey("gauge", "b", nil)
+	s.rollups[RollupMinute][minuteOnly] = []Rollup{{Timestamp: now.Add(-48 * time.Hour), Count: 1}}
+	s.rollups[RollupHour][hourOnly] = []Rollup{{Timestamp: now.Add(-60 * 24 * time.Hour), Count: 1}}
+
+	err := s.CompactHistory(context.Background(),
+		RetentionPolicy{Raw: time.Minute, Rollup: time.Hour, RollupHour: 30 * 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.rollups[RollupMinute][minuteOnly]) != 0 {
+		t.Error("minute rollups must be compacted")
+	}
+	if len(s.rollups[RollupHour][minuteOnly]) != 1 {
+		t.Error("minute rollups must be rolled up per hour")
+	}
+	if _, ok := s.rollups[RollupHour][hourOnly]; ok {
+		t.Error("expired hour rollups must be forgotten")
+	}
+}
@@ -1,3 +1,36 @@
 package structs
 
+import "context"
+
 type RequestCtxBody struct{}
+
+// RequestCtxAgent marks metrics updates sent by agents (the /update/ and /updates/ endpoints and
+// their gRPC counterparts). Only these updates register agents, other ingestion protocols
+// may carry the instance label as well
+type RequestCtxAgent struct{}
+
+// AgentContext returns ctx of metrics update sent by an agent
+func AgentContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, RequestCtxAgent{}, true)
+}
+
+// IsAgentContext reports whether ctx belongs to metrics update sent by an agent
+func IsAgentContext(ctx context.Context) bool {
+	agent, _ := ctx.Value(RequestCtxAgent{}).(bool)
+	return agent
+}
+
+// RequestCtxClient marks storage calls made while serving HTTP or gRPC request, as opposed to
+// background work (history compaction, StatsD and Graphite flushes)
+type RequestCtxClient struct{}
+
+// ClientContext returns ctx of the client request
+func ClientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, RequestCtxClient{}, true)
+}
+
+// IsClientContext reports whether ctx belongs to a client request
+func IsClientContext(ctx context.Context) bool {
+	client, _ := ctx.Value(RequestCtxClient{}).(bool)
+	return client
+}
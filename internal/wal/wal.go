@@ -0,0 +1,214 @@
+// Package wal implements append-only write-ahead log of metric updates for the memory storage.
+// Records are stored as JSON lines, so a record torn by crash is detected as incomplete last line
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+// Record operations
+const (
+	OpUpdate = "update"
+	OpReset  = "reset"
+	OpDelete = "delete"
+)
+
+// Record is a single storage change. Seq grows with every change and is saved to dumps,
+// so records already saved by a dump are not replayed again
+type Record struct {
+	Seq     uint64           `json:"seq,omitempty"`
+	Op      string           `json:"op"`
+	Metrics []structs.Metric `json:"metrics,omitempty"`
+	// ID and MType of reset counter or deleted metric, Match selects its series
+	ID    string   `json:"id,omitempty"`
+	MType string   `json:"type,omitempty"`
+	Match []string `json:"match,omitempty"`
+}
+
+// NewMatch returns matchers in the form stored in Record.Match
+func NewMatch(matchers []structs.LabelMatcher) []string {
+	var match []string
+	for _, m := range matchers {
+		match = append(match, m.Source())
+	}
+	return match
+}
+
+// Apply makes the recorded change in the storage
+func (r Record) Apply(ctx context.Context, store structs.Storage) error {
+	switch r.Op {
+	case OpUpdate:
+		return store.UpdateMetrics(ctx, r.Metrics)
+	case OpReset, OpDelete:
+		matchers, err := structs.AdminRequest{Match: r.Match}.Matchers()
+		if err != nil {
+			return err
+		}
+		if r.Op == OpReset {
+			return store.ResetCounter(ctx, r.ID, matchers)
+		}
+		return store.DeleteMetric(ctx, structs.Metric{ID: r.ID, MType: r.MType}, matchers)
+	default:
+		return fmt.Errorf("unknown operation %s", r.Op)
+	}
+}
+
+type Log struct {
+	path       string
+	f          *os.File
+	syncAlways bool
+	// dirty is set when records were written after the last fsync
+	dirty bool
+	mx    sync.Mutex
+}
+
+// Open opens log for appending. If syncAlways is set, every record is fsynced before Append returns,
+// otherwise records are fsynced by Sync calls.
+// Incomplete last record (torn by crash) is cut off
+func Open(path string, syncAlways bool) (*Log, error) {
+	err := repair(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL %s: %s", path, err.Error())
+	}
+	return &Log{path: path, f: f, syncAlways: syncAlways}, nil
+}
+
+// repair truncates file to the last complete record
+func repair(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read WAL %s: %s", path, err.Error())
+	}
+	size := bytes.LastIndexByte(data, '\n') + 1
+	if size == len(data) {
+		return nil
+	}
+	log.Printf("WARN WAL %s has incomplete last record (%d bytes), it will be dropped", path, len(data)-size)
+	err = os.Truncate(path, int64(size))
+	if err != nil {
+		return fmt.Errorf("failed to truncate WAL %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// Append writes record to the log
+func (l *Log) Append(r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode WAL record: %s", err.Error())
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	_, err = l.f.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("%w: failed to write WAL %s: %s", structs.ErrStorageUnavailable, l.path, err.Error())
+	}
+	l.dirty = true
+	if l.syncAlways {
+		return l.sync()
+	}
+	return nil
+}
+
+func (l *Log) sync() error {
+	if !l.dirty {
+		return nil
+	}
+	err := l.f.Sync()
+	if err != nil {
+		return fmt.Errorf("%w: failed to sync WAL %s: %s", structs.ErrStorageUnavailable, l.path, err.Error())
+	}
+	l.dirty = false
+	return nil
+}
+
+// Sync flushes written records to disk
+func (l *Log) Sync() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.sync()
+}
+
+// Truncate drops all records (called when the changes were saved elsewhere)
+func (l *Log) Truncate() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	err := l.f.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("failed to truncate WAL %s: %s", l.path, err.Error())
+	}
+	l.dirty = true
+	return l.sync()
+}
+
+func (l *Log) Close() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	err := l.sync()
+	if err != nil {
+		return err
+	}
+	return l.f.Close()
+}
+
+// Replay applies log records newer than after (seq of the restored dump) to the storage
+// in the order they were written. Records the storage fails to apply are skipped:
+// they failed the same way when were written. Returns number of applied records and the last seq in the log
+func Replay(ctx context.Context, path string, store structs.Storage, after uint64) (int, uint64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open WAL %s: %s", path, err.Error())
+	}
+	defer f.Close()
+
+	var replayed int
+	var last uint64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r Record
+		err := json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			log.Printf("WARN WAL %s: skipping undecodable record at line %d: %s", path, line, err.Error())
+			continue
+		}
+		if r.Seq > last {
+			last = r.Seq
+		}
+		// the dump was saved before the log was truncated
+		if r.Seq != 0 && r.Seq <= after {
+			continue
+		}
+		err = r.Apply(ctx, store)
+		if err != nil {
+			log.Printf("WARN WAL %s: skipping %s record at line %d: %s", path, r.Op, line, err.Error())
+			continue
+		}
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		return replayed, last, fmt.Errorf("failed to read WAL %s: %s", path, err.Error())
+	}
+	return replayed, last, nil
+}
@@ -0,0 +1,127 @@
+package wal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zklevsha/go-musthave-devops/internal/structs"
+)
+
+func TestReplay(t *testing.T) {
+	d := int64(2)
+	v := 1.5
+	tt := []struct {
+		name    string
+		records []Record
+		// tail is appended to the log after records (e.g. torn record)
+		tail string
+		// after is seq of the restored dump
+		after    uint64
+		replayed int
+		counter  int64
+		gauge    bool
+	}{
+		{name: "updates", replayed: 2, counter: 4, gauge: true, records: []Record{
+			{Op: OpUpdate, Metrics: []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d}}},
+			{Op: OpUpdate, Metrics: []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d},
+				{ID: "Alloc", MType: "gauge", Value: &v}}},
+		}},
+		{name: "reset and delete", replayed: 4, counter: 2, records: []Record{
+			{Op: OpUpdate, Metrics: []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d},
+				{ID: "Alloc", MType: "gauge", Value: &v}}},
+			{Op: OpReset, ID: "PollCount"},
+			{Op: OpDelete, ID: "Alloc", MType: "gauge"},
+			{Op: OpUpdate, Metrics: []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d}}},
+		}},
+		{name: "reset and delete matched series", replayed: 2, counter: 2, records: []Record{
+			{Op: OpUpdate, Metrics: []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d},
+				{ID: "Alloc", MType: "gauge", Value: &v}}},
+			{Op: OpReset, ID: "PollCount", Match: []string{"host=web1"}},
+			{Op: OpDelete, ID: "Alloc", MType: "gauge", Match: []string{"host!=web1"}},
+		}},
+		{name: "failed change is skipped", replayed: 1, counter: 2, records: []Record{
+			{Op: OpUpdate, Metrics: []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d}}},
+			{Op: OpDelete, ID: "Unknown", MType: "gauge"},
+		}},
+		{name: "records saved by dump are skipped", after: 2, replayed: 1, counter: 2, gauge: true, records: []Record{
+			{Seq: 1, Op: OpUpdate, Metrics: []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d}}},
+			{Seq: 2, Op: OpUpdate, Metrics: []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d}}},
+			{Seq: 3, Op: OpUpdate, Metrics: []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d},
+				{ID: "Alloc", MType: "gauge", Value: &v}}},
+		}},
+		{name: "torn record", replayed: 1, counter: 2, tail: `{"op":"update","metrics":[{"id":"Poll`, records: []Record{
+			{Op: OpUpdate, Metrics: []structs.Metric{{ID: "PollCount", MType: "counter", Delta: &d}}},
+		}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.wal")
+			l, err := Open(path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range tc.records {
+				if err := l.Append(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			l.Close()
+			if tc.tail != "" {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteString(tc.tail)
+				f.Close()
+			}
+
+			store := structs.NewMemoryStorage()
+			n, _, err := Replay(context.Background(), path, store, tc.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tc.replayed {
+				t.Errorf("replayed records mismatch: have %d, want %d", n, tc.replayed)
+			}
+			counter, err := store.GetMetric(context.Background(), structs.Metric{ID: "PollCount", MType: "counter"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *counter.Delta != tc.counter {
+				t.Errorf("counter mismatch: have %d, want %d", *counter.Delta, tc.counter)
+			}
+			_, err = store.GetMetric(context.Background(), structs.Metric{ID: "Alloc", MType: "gauge"})
+			if (err == nil) != tc.gauge {
+				t.Errorf("gauge presence mismatch: have %t, want %t", err == nil, tc.gauge)
+			}
+		})
+	}
+}
+
+func TestOpenRepairsTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+	err := os.WriteFile(path, []byte(`{"op":"reset","id":"PollCount"}`+"\n"+`{"op":"res`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Append(Record{Op: OpReset, ID: "Other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"op":"reset","id":"PollCount"}` + "\n" + `{"op":"reset","id":"Other"}` + "\n"
+	if string(data) != want {
+		t.Errorf("WAL content mismatch: have %q, want %q", data, want)
+	}
+}